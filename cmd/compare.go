@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	logger "github.com/charmbracelet/log"
+
+	"paige/pkg/diff"
+	"paige/pkg/server"
+)
+
+// chapterComparison is the comparison of one sample chapter.
+type chapterComparison struct {
+	Path string `json:"path"`
+	server.CompareResponse
+	Error string `json:"error,omitempty"`
+}
+
+// runCompare summarizes every sample chapter (.txt or .md) under a directory with each
+// COMPARE_MODELS model and writes a report: Markdown, or the raw results for a .json path.
+//
+//	paige compare [-out compare-report.md] [-models a,b] <dir>
+func runCompare(ctx context.Context, srv *server.Server, args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ContinueOnError)
+	out := flags.String("out", "compare-report.md", "report path; a .json path writes the raw results")
+	models := flags.String("models", "", "comma-separated COMPARE_MODELS entries to compare; empty compares all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	dir := flags.Arg(0)
+	if dir == "" {
+		return errors.New("usage: paige compare [-out report.md] [-models a,b] <dir>")
+	}
+
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ext := strings.ToLower(filepath.Ext(path)); !d.IsDir() && (ext == ".txt" || ext == ".md") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no .txt or .md chapters in %s", dir)
+	}
+	slices.Sort(paths)
+
+	var selected []string
+	if *models != "" {
+		for _, m := range strings.Split(*models, ",") {
+			selected = append(selected, strings.TrimSpace(m))
+		}
+	}
+
+	var chapters []chapterComparison
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rel, _ := filepath.Rel(dir, path)
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		logger.Info("comparing chapter", "path", rel)
+		resp, err := srv.Compare(ctx, server.CompareRequest{Text: string(text), ID: "compare/" + filepath.ToSlash(rel), Models: selected})
+		chapter := chapterComparison{Path: rel, CompareResponse: resp}
+		if errors.Is(err, server.ErrCompareModels) {
+			return err
+		}
+		if err != nil {
+			chapter.Error = err.Error()
+		}
+		chapters = append(chapters, chapter)
+	}
+
+	var report []byte
+	if strings.EqualFold(filepath.Ext(*out), ".json") {
+		report, err = json.MarshalIndent(chapters, "", "  ")
+		if err != nil {
+			return err
+		}
+	} else {
+		report = []byte(compareReport(chapters))
+	}
+	if err := os.WriteFile(*out, report, 0o644); err != nil {
+		return err
+	}
+	logger.Info("wrote comparison report", "path", *out, "chapters", len(chapters))
+	return nil
+}
+
+// compareReport renders the comparisons as Markdown: totals per model, then every chapter.
+func compareReport(chapters []chapterComparison) string {
+	type total struct {
+		ok, failed                 int
+		latencyMS                  int64
+		prompt, completion, cached int64
+		cost                       float64
+	}
+	var names []string
+	totals := make(map[string]*total)
+	for _, ch := range chapters {
+		for _, run := range ch.Runs {
+			t, ok := totals[run.Name]
+			if !ok {
+				t = new(total)
+				totals[run.Name] = t
+				names = append(names, run.Name)
+			}
+			if run.Error != "" {
+				t.failed++
+			} else {
+				t.ok++
+			}
+			t.latencyMS += run.LatencyMS
+			t.prompt += run.Usage.PromptTokens
+			t.completion += run.Usage.CompletionTokens
+			t.cached += run.Usage.CachedTokens
+			t.cost += run.Cost
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Model comparison\n\n%d chapters, models: %s.\n\n## Totals\n\n", len(chapters), strings.Join(names, ", "))
+	b.WriteString("| Model | Chapters | Failed | Latency (s) | Prompt tokens | Cached tokens | Completion tokens | Cost (USD) |\n")
+	b.WriteString("|---|---:|---:|---:|---:|---:|---:|---:|\n")
+	for _, name := range names {
+		t := totals[name]
+		fmt.Fprintf(&b, "| %s | %d | %d | %.1f | %d | %d | %d | %.4f |\n", name, t.ok, t.failed, float64(t.latencyMS)/1000, t.prompt, t.cached, t.completion, t.cost)
+	}
+
+	for _, ch := range chapters {
+		fmt.Fprintf(&b, "\n## %s\n\n", ch.Path)
+		if ch.Error != "" {
+			fmt.Fprintf(&b, "Not compared: %s\n", ch.Error)
+			continue
+		}
+		b.WriteString("| Model | Answered by | Latency (s) | Calls | Prompt tokens | Completion tokens | Characters | Events | Error |\n")
+		b.WriteString("|---|---|---:|---:|---:|---:|---:|---:|---|\n")
+		for _, run := range ch.Runs {
+			var chars, events int
+			if run.Summary != nil {
+				chars = len(run.Summary.Characters)
+				for _, day := range run.Summary.Timeline {
+					events += len(day.Events)
+				}
+			}
+			fmt.Fprintf(&b, "| %s | %s | %.1f | %d | %d | %d | %d | %d | %s |\n", run.Name, run.Model, float64(run.LatencyMS)/1000, run.Calls,
+				run.Usage.PromptTokens, run.Usage.CompletionTokens, chars, events, strings.ReplaceAll(run.Error, "|", `\|`))
+		}
+		if len(ch.Diffs) > 0 {
+			b.WriteByte('\n')
+		}
+		for _, d := range ch.Diffs {
+			fmt.Fprintf(&b, "- %s vs %s: %d characters and %d events differ\n", d.Other, d.Base, d.ChangedCharacters, d.ChangedEvents)
+			for _, c := range d.Diff.Characters {
+				if c.State != diff.Unchanged {
+					fmt.Fprintf(&b, "  - %s %s\n", changeLabel[c.State], c.Name)
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+var changeLabel = map[diff.ChangeType]string{diff.Added: "only in other:", diff.Removed: "only in base:", diff.Modified: "differs:"}
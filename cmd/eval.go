@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	logger "github.com/charmbracelet/log"
+
+	"paige/pkg/eval"
+	"paige/pkg/inference"
+	"paige/pkg/server"
+)
+
+// runEval scores name and summary extraction on gold-annotated stories with the configured
+// inferencer, appends the run to the results file and reports it against the previous run.
+// Saved summaries are left untouched. With -min-f1 it fails when the names F1 falls below the
+// threshold, for CI runs against the fake or a replayed cassette.
+//
+//	paige eval [-results EvalResults.json] [-label name] [-min-f1 0.8] <gold file or dir>
+func runEval(ctx context.Context, srv *server.Server, args []string) error {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	results := flags.String("results", "EvalResults.json", "file the runs are appended to; empty skips storing")
+	label := flags.String("label", "", "name of this run, e.g. the prompt or model change under test")
+	minF1 := flags.Float64("min-f1", 0, "fail when the names F1 is below this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.Arg(0) == "" {
+		return errors.New("usage: paige eval [-results EvalResults.json] [-label name] [-min-f1 0.8] <gold file or dir>")
+	}
+	stories, err := eval.LoadGold(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	srv.Ephemeral = true
+	runner := &eval.Runner{Handler: srv.Echo}
+	if srv.Ledger != nil {
+		runner.Usage = srv.Ledger.Story
+	}
+	logger.Info("evaluating gold stories", "stories", len(stories))
+	run := runner.Run(ctx, *label, inference.ModelOf(srv.Inferencer), stories)
+
+	var previous *eval.Run
+	if *results != "" {
+		runs, err := eval.LoadRuns(*results)
+		if err != nil {
+			return err
+		}
+		if len(runs) > 0 {
+			previous = &runs[len(runs)-1]
+		}
+		if err := eval.SaveRun(*results, run); err != nil {
+			return err
+		}
+	}
+	eval.Report(os.Stdout, run, previous)
+
+	if run.Total.Names.F1 < *minF1 {
+		return fmt.Errorf("names F1 %.4f is below %.4f", run.Total.Names.F1, *minF1)
+	}
+	return nil
+}
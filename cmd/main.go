@@ -1,19 +1,29 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	logger "github.com/charmbracelet/log"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
+	"google.golang.org/genai"
 
 	"paige/pkg/inference"
+	"paige/pkg/inference/fake"
+	"paige/pkg/queue"
+	fakequeue "paige/pkg/queue/fake"
 	"paige/pkg/queue/novelai"
 	"paige/pkg/schema"
 	"paige/pkg/server"
@@ -23,46 +33,126 @@ import (
 func main() {
 	ctx, done := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 
-	model := os.Getenv("OPENAI_MODEL")
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	openAI := inference.NewOpenAIInferencer(apiKey, model)
-	var inf inference.Inferencer
-	if apiKey != "" {
-		inf = openAI
-		logger.Info("Using OpenAI as inferencer")
-	} else if grokKey := os.Getenv("GROK_API_KEY"); grokKey != "" {
-		inf = inference.NewGrokInferencer(grokKey, os.Getenv("GROK_MODEL"))
-		logger.Info("Using Grok as inferencer")
-	} else if geminiKey := os.Getenv("GEMINI_API_KEY"); geminiKey != "" {
-		var err error
-		inf, err = inference.NewGeminiInferencer(geminiKey, os.Getenv("GEMINI_MODEL"))
+	provider := strings.ToLower(os.Getenv("INFERENCE_PROVIDER"))
+	if provider == "" {
+		provider = detectProvider()
+	}
+	inf, err := newInferencer(provider)
+	if err != nil {
+		logger.Fatal("failed to create inferencer", "provider", provider, "error", err)
+	}
+	logger.Info("Using inferencer", "provider", provider, "model", inference.ModelOf(inf))
+
+	if path := os.Getenv("INFERENCE_CASSETTE"); path != "" {
+		cassette, err := fake.OpenCassette(path)
 		if err != nil {
-			logger.Fatal(err)
-		}
-		logger.Info("Using Gemini as inferencer")
-	} else if kimiKey := os.Getenv("KIMI_API_KEY"); kimiKey != "" {
-		inf = inference.NewKimiInferencer(kimiKey, os.Getenv("KIMI_MODEL"))
-		logger.Info("Using Kimi as inferencer")
-	} else if moonshotKey := os.Getenv("MOONSHOT_API_KEY"); moonshotKey != "" {
-		inf = inference.NewMoonshotInferencer(moonshotKey, os.Getenv("MOONSHOT_MODEL"))
-		logger.Info("Using Moonshot as inferencer")
-	} else {
-		openAI.ChangeBaseURL("http://localhost:1234/v1")
-		openAI.SetModel("")
-		inf = openAI
-		logger.Info("Using local LM Studio as inferencer")
+			logger.Fatal("failed to open cassette", "path", path, "error", err)
+		}
+		mode := fake.Mode(cmp.Or(strings.ToLower(os.Getenv("INFERENCE_CASSETTE_MODE")), string(fake.ModeAuto)))
+		inf = fake.WithRecorder(inf, cassette, mode)
+		logger.Info("Recording inference cassette", "path", path, "mode", mode, "interactions", len(cassette.Interactions))
 	}
 
-	naiToken := os.Getenv("NOVELAI_TOKEN")
-	if naiToken == "" {
-		logger.Warn("NOVELAI_TOKEN not set, image generation will be disabled")
+	provided := inf
+
+	usage, _ := utils.Load[inference.LedgerData]("Usage.json")
+	prices, err := utils.Load[inference.PriceTable]("Prices.json")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warnf("Failed to load Prices.json: %v", err)
+	}
+	ledger := inference.NewLedger(usage, prices, envFloat("USAGE_DAILY_BUDGET", 0))
+
+	var cache *inference.DiskCache
+	if ttl := envDuration("INFERENCE_CACHE_TTL", 0); ttl > 0 {
+		dir := cmp.Or(os.Getenv("INFERENCE_CACHE_DIR"), filepath.Join("cache", "inference"))
+		cache = inference.NewDiskCache(dir, ttl, int64(envInt("INFERENCE_CACHE_MAX_MB", 256))<<20)
+		logger.Info("Caching inference responses", "dir", dir, "ttl", ttl)
+	}
+
+	var transcripts *inference.TranscriptLog
+	if dir := os.Getenv("TRANSCRIPT_DIR"); dir != "" {
+		redact := inference.ParseRedact(os.Getenv("TRANSCRIPT_REDACT"))
+		transcripts = inference.NewTranscriptLog(dir, int64(envInt("TRANSCRIPT_MAX_MB", 64))<<20, envInt("TRANSCRIPT_MAX_FILES", 10), redact)
+		logger.Info("Logging inference transcripts", "dir", dir, "redact", redact)
+	}
+
+	// decorate wraps a provider with accounting, rate limits, retries, caching and transcripts.
+	var limiters []*inference.Limiter
+	decorate := func(inf inference.Inferencer, provider string) inference.Inferencer {
+		inf = inference.WithAccounting(inf, provider, ledger)
+		if limits := providerLimits(provider); limits.RPM > 0 || limits.TPM > 0 {
+			limited := inference.WithLimit(inf, provider, limits)
+			inf = limited
+			limiters = append(limiters, limited.Limiter)
+			logger.Info("Rate limiting inferencer", "provider", provider, "rpm", limits.RPM, "tpm", limits.TPM)
+		}
+		inf = inference.WithRetry(inf, retryPolicy())
+		if cache != nil {
+			inf = inference.WithCache(inf, provider, cache)
+		}
+		if transcripts != nil {
+			logged := inference.WithTranscripts(inf, provider, transcripts)
+			logged.OnError = func(err error) { logger.Warn("failed writing transcript", "error", err) }
+			inf = logged
+		}
+		return inf
+	}
+	inf = decorate(inf, provider)
+
+	// Ensemble and compare members share one decorated inferencer per provider.
+	members := map[string]inference.Inferencer{provider: inf}
+	member := func(name string) inference.Inferencer {
+		if m, ok := members[name]; ok {
+			return m
+		}
+		m, err := newInferencer(name)
+		if err != nil {
+			logger.Fatal("failed to create inferencer", "provider", name, "error", err)
+		}
+		members[name] = decorate(m, name)
+		return members[name]
+	}
+
+	var ensemble []server.NameVoter
+	for _, name := range envList("NAME_ENSEMBLE") {
+		name = strings.ToLower(name)
+		ensemble = append(ensemble, server.NameVoter{Name: name, Inferencer: member(name)})
+	}
+	if len(ensemble) > 0 || envInt("NAME_ENSEMBLE_SAMPLES", 1) > 1 {
+		logger.Info("Extracting names by ensemble vote", "members", len(ensemble), "samples", envInt("NAME_ENSEMBLE_SAMPLES", 1))
+	}
+
+	// COMPARE_MODELS entries are provider[:model], e.g. openai:gpt-4o-mini.
+	var compared []server.ComparedModel
+	for _, entry := range envList("COMPARE_MODELS") {
+		name, model, _ := strings.Cut(entry, ":")
+		compared = append(compared, server.ComparedModel{Name: entry, Inferencer: member(strings.ToLower(name)), Model: model})
+	}
+
+	q, err := newQueue()
+	if err != nil {
+		logger.Fatal("failed to create image queue", "error", err)
 	}
-	q := novelai.New(naiToken)
 	q.Start()
 	defer q.Stop()
 
 	srv := server.NewServer(ctx, inf, q)
 	srv.Echo.Logger.SetLevel(log.DEBUG)
+	srv.Limiters = limiters
+	srv.Ledger = ledger
+	srv.Cache = cache
+	srv.Transcripts = transcripts
+	srv.NameEnsemble = ensemble
+	srv.CompareModels = compared
+	srv.NameSamples = envInt("NAME_ENSEMBLE_SAMPLES", 1)
+	srv.NameQuorum = envInt("NAME_ENSEMBLE_QUORUM", 0)
+	srv.SummarizeParallelism = envInt("SUMMARIZE_PARALLELISM", 4)
+	srv.RepairAttempts = envInt("REPAIR_MAX_ATTEMPTS", 0)
+	srv.Prompts.Dir = cmp.Or(os.Getenv("PROMPT_DIR"), "prompts")
+	if window := envInt("CONTEXT_WINDOW", 0); window > 0 {
+		srv.ContextWindow = window
+	}
+	logger.Info("Chunking by tokens", "tokenizer", srv.Tokenizer.Name(), "context_window", srv.ContextWindow)
 
 	summaries, err := utils.Load[map[string]schema.Summary]("CharacterSummary.json")
 	if err == nil && summaries != nil {
@@ -86,11 +176,45 @@ func main() {
 	}
 	srv.Forbids = forbids
 
+	go srv.RunUsageSaves(ctx, envDuration("USAGE_SAVE_INTERVAL", 30*time.Second))
+
+	if len(os.Args) > 1 {
+		// Subcommands run against the configured inferencers without serving.
+		var err error
+		switch os.Args[1] {
+		case "compare":
+			err = runCompare(ctx, srv, os.Args[2:])
+		case "eval":
+			err = runEval(ctx, srv, os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q", os.Args[1])
+		}
+		_ = srv.SaveUsage()
+		if err != nil {
+			logger.Fatal("command failed", "command", os.Args[1], "error", err)
+		}
+		return
+	}
+
 	addr := ":8080"
 	if envAddr := os.Getenv("PORT"); envAddr != "" {
 		addr = ":" + strings.TrimLeft(envAddr, `:`)
 	}
 
+	batches, _ := utils.Load[map[string]*server.BatchJob]("Batches.json")
+	srv.Batches = batches
+	if provider == "fake" {
+		// The fake provider serves a stand-in batch API answered by its script, so batch mode can
+		// be exercised end to end without a network.
+		srv.Echo.Any("/fake/v1/*", echo.WrapHandler(fake.NewBatchServer(provided)))
+		batcher := inference.NewPresetInferencer(inference.Presets["openai"], "fake", inference.ModelOf(provided))
+		batcher.ChangeBaseURL("http://localhost" + addr + "/fake/v1")
+		srv.Batcher = batcher
+	}
+	if srv.Batcher != nil {
+		go srv.RunBatches(ctx, envDuration("BATCH_POLL_INTERVAL", time.Minute))
+	}
+
 	finishedShutDown := make(chan struct{})
 	go func() {
 		<-ctx.Done()
@@ -106,3 +230,175 @@ func main() {
 	}
 	<-finishedShutDown
 }
+
+// detectProvider picks the first provider with an API key set, falling back to local LM Studio.
+func detectProvider() string {
+	for _, name := range []string{"openai", "grok", "gemini", "kimi", "moonshot", "anthropic", "openrouter"} {
+		if os.Getenv(strings.ToUpper(name)+"_API_KEY") != "" {
+			return name
+		}
+	}
+	return "lmstudio"
+}
+
+// newInferencer creates the named provider from <PROVIDER>_API_KEY, <PROVIDER>_MODEL
+// and, for OpenAI-compatible presets, <PROVIDER>_BASE_URL.
+func newInferencer(provider string) (inference.Inferencer, error) {
+	env := strings.ToUpper(provider)
+	apiKey, model := os.Getenv(env+"_API_KEY"), os.Getenv(env+"_MODEL")
+	switch provider {
+	case "fake":
+		path := cmp.Or(os.Getenv("FAKE_SCRIPT"), "fake.json")
+		inf, err := fake.Load(path)
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Warn("fake script not found, every inference call will fail", "path", path)
+			return fake.New(), nil
+		}
+		return inf, err
+	case "gemini":
+		inf, err := inference.NewGeminiInferencer(apiKey, model)
+		if err != nil {
+			return nil, err
+		}
+		if threshold := os.Getenv("GEMINI_SAFETY_THRESHOLD"); threshold != "" {
+			inf.SafetySettings = inference.GeminiSafety(genai.HarmBlockThreshold(strings.ToUpper(threshold)))
+		}
+		inf.ContextCacheTTL = envDuration("GEMINI_CONTEXT_CACHE_TTL", 0)
+		inf.Reasoning = reasoningPolicy(provider)
+		return inf, nil
+	case "anthropic":
+		inf := inference.NewAnthropicInferencer(apiKey, model)
+		if baseURL := os.Getenv(env + "_BASE_URL"); baseURL != "" {
+			inf.ChangeBaseURL(baseURL)
+		}
+		inf.MaxTokens = int64(envInt("ANTHROPIC_MAX_TOKENS", 0))
+		if caching, err := strconv.ParseBool(os.Getenv("ANTHROPIC_PROMPT_CACHING")); err == nil {
+			inf.PromptCaching = caching
+		}
+		inf.Reasoning = reasoningPolicy(provider)
+		return inf, nil
+	}
+	preset, ok := inference.Presets[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+	inf := inference.NewPresetInferencer(preset, apiKey, model)
+	if baseURL := os.Getenv(env + "_BASE_URL"); baseURL != "" {
+		inf.ChangeBaseURL(baseURL)
+	}
+	inf.Reasoning = reasoningPolicy(provider)
+	return inf, nil
+}
+
+// reasoningPolicy reads <PROVIDER>_REASONING_EFFORT and <PROVIDER>_THINKING_BUDGET with
+// per-task <PROVIDER>_REASONING_EFFORT_<TASK> and <PROVIDER>_THINKING_BUDGET_<TASK> overrides
+// (e.g. OPENAI_REASONING_EFFORT_NAMES=minimal, GEMINI_THINKING_BUDGET_SUMMARIZE=2048).
+func reasoningPolicy(provider string) inference.ReasoningPolicy {
+	prefix := strings.ToUpper(provider) + "_"
+	policy := inference.ReasoningPolicy{
+		Default: inference.Reasoning{
+			Effort: strings.ToLower(os.Getenv(prefix + "REASONING_EFFORT")),
+			Budget: int64(envInt(prefix+"THINKING_BUDGET", 0)),
+		},
+	}
+	for _, task := range inference.Tasks {
+		suffix := "_" + strings.ToUpper(task)
+		effort, hasEffort := os.LookupEnv(prefix + "REASONING_EFFORT" + suffix)
+		_, hasBudget := os.LookupEnv(prefix + "THINKING_BUDGET" + suffix)
+		if !hasEffort && !hasBudget {
+			continue
+		}
+		r := policy.Default
+		if hasEffort {
+			r.Effort = strings.ToLower(effort)
+		}
+		if hasBudget {
+			r.Budget = int64(envInt(prefix+"THINKING_BUDGET"+suffix, int(r.Budget)))
+		}
+		if policy.Tasks == nil {
+			policy.Tasks = make(map[string]inference.Reasoning)
+		}
+		policy.Tasks[task] = r
+	}
+	return policy
+}
+
+// newQueue creates the NovelAI image queue, or a fake one answering with the PNGs in
+// FAKE_IMAGES_DIR (or a placeholder) when IMAGE_QUEUE=fake.
+func newQueue() (queue.Queue, error) {
+	if strings.EqualFold(os.Getenv("IMAGE_QUEUE"), "fake") {
+		if dir := os.Getenv("FAKE_IMAGES_DIR"); dir != "" {
+			return fakequeue.FromDir(dir)
+		}
+		return fakequeue.New(), nil
+	}
+	naiToken := os.Getenv("NOVELAI_TOKEN")
+	if naiToken == "" {
+		logger.Warn("NOVELAI_TOKEN not set, image generation will be disabled")
+	}
+	return novelai.New(naiToken), nil
+}
+
+// retryPolicy reads RETRY_MAX_ATTEMPTS, RETRY_BASE_DELAY, RETRY_MAX_DELAY and
+// per-task RETRY_MAX_ATTEMPTS_<TASK> overrides (e.g. RETRY_MAX_ATTEMPTS_SUMMARIZE).
+func retryPolicy() inference.RetryPolicy {
+	policy := inference.DefaultRetryPolicy()
+	policy.MaxAttempts = envInt("RETRY_MAX_ATTEMPTS", policy.MaxAttempts)
+	policy.BaseDelay = envDuration("RETRY_BASE_DELAY", policy.BaseDelay)
+	policy.MaxDelay = envDuration("RETRY_MAX_DELAY", policy.MaxDelay)
+	for _, task := range inference.Tasks {
+		key := "RETRY_MAX_ATTEMPTS_" + strings.ToUpper(task)
+		if _, ok := os.LookupEnv(key); !ok {
+			continue
+		}
+		if policy.TaskAttempts == nil {
+			policy.TaskAttempts = make(map[string]int)
+		}
+		policy.TaskAttempts[task] = envInt(key, policy.MaxAttempts)
+	}
+	return policy
+}
+
+// providerLimits reads <PROVIDER>_RPM and <PROVIDER>_TPM (e.g. OPENAI_RPM, GEMINI_TPM).
+func providerLimits(provider string) inference.Limits {
+	prefix := strings.ToUpper(provider) + "_"
+	return inference.Limits{
+		RPM: envInt(prefix+"RPM", 0),
+		TPM: envInt(prefix+"TPM", 0),
+	}
+}
+
+func envInt(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envFloat(key string, fallback float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// envList splits a comma-separated variable into its trimmed, non-empty entries.
+func envList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -13,6 +13,7 @@ require (
 	github.com/openai/openai-go/v3 v3.9.0
 	github.com/pkoukk/tiktoken-go v0.1.8
 	github.com/segmentio/ksuid v1.0.4
+	golang.org/x/sync v0.18.0
 	google.golang.org/genai v1.36.0
 )
 
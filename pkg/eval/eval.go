@@ -0,0 +1,341 @@
+// Package eval scores name and summary extraction against gold-annotated stories.
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"paige/pkg/schema"
+)
+
+// Gold is a story annotated with the expected extraction.
+type Gold struct {
+	ID      string `json:"id"`
+	Source  string `json:"source,omitempty"`
+	Chapter string `json:"chapter,omitempty"`
+	// Text is the story. Paragraphs, keyed by paragraph number from 1, may be given instead;
+	// otherwise they are split from Text at blank lines, which is how heat is keyed.
+	Text       string            `json:"text,omitempty"`
+	Paragraphs map[string]string `json:"paragraphs,omitempty"`
+	Characters []GoldCharacter   `json:"characters"`
+	// Heat is the expected level (0-3) per paragraph; unlisted paragraphs are not scored.
+	Heat map[string]float64 `json:"heat,omitempty"`
+}
+
+// GoldCharacter is an expected character. Kind, Gender and Species are scored when set.
+type GoldCharacter struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	Kind    string   `json:"kind,omitempty"`
+	Gender  string   `json:"gender,omitempty"`
+	Species string   `json:"species,omitempty"`
+}
+
+var blankLines = regexp.MustCompile(`\n\s*\n`)
+
+// LoadGold reads a gold story file, or every .json file under a directory in path order.
+func LoadGold(path string) ([]Gold, error) {
+	var files []string
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".json") {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+
+	var stories []Gold
+	for _, file := range files {
+		bin, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var g Gold
+		if err := json.Unmarshal(bin, &g); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if g.ID == "" {
+			g.ID = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		if len(g.Paragraphs) == 0 {
+			g.Paragraphs = make(map[string]string)
+			for _, p := range blankLines.Split(strings.TrimSpace(g.Text), -1) {
+				if p = strings.TrimSpace(p); p != "" {
+					g.Paragraphs[strconv.Itoa(len(g.Paragraphs)+1)] = p
+				}
+			}
+		}
+		if g.Text == "" {
+			g.Text = g.joined()
+		}
+		if len(g.Paragraphs) == 0 {
+			return nil, fmt.Errorf("%s: no text", file)
+		}
+		stories = append(stories, g)
+	}
+	if len(stories) == 0 {
+		return nil, fmt.Errorf("no gold stories in %s", path)
+	}
+	return stories, nil
+}
+
+// joined returns the paragraphs in order, separated by blank lines.
+func (g Gold) joined() string {
+	keys := make([]string, 0, len(g.Paragraphs))
+	for k := range g.Paragraphs {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x - y
+	})
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = g.Paragraphs[k]
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// PR is a precision and recall tally. The rates are filled by score.
+type PR struct {
+	Matched   int     `json:"matched"`
+	Predicted int     `json:"predicted"`
+	Expected  int     `json:"expected"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+func (p *PR) add(o PR) {
+	p.Matched += o.Matched
+	p.Predicted += o.Predicted
+	p.Expected += o.Expected
+	p.score()
+}
+
+// score computes the rates. Nothing predicted is perfectly precise only when nothing was
+// expected, so an empty answer does not score well.
+func (p *PR) score() {
+	p.Precision, p.Recall = ratio(p.Matched, p.Predicted, p.Expected == 0), ratio(p.Matched, p.Expected, true)
+	p.F1 = 0
+	if p.Precision+p.Recall > 0 {
+		p.F1 = 2 * p.Precision * p.Recall / (p.Precision + p.Recall)
+	}
+}
+
+func ratio(n, d int, emptyIsPerfect bool) float64 {
+	if d == 0 {
+		if emptyIsPerfect {
+			return 1
+		}
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// Accuracy is the share of correct field values.
+type Accuracy struct {
+	Correct  int     `json:"correct"`
+	Total    int     `json:"total"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+func (a *Accuracy) add(correct, total int) {
+	a.Correct += correct
+	a.Total += total
+	a.Accuracy = ratio(a.Correct, a.Total, true)
+}
+
+// MAE is a mean absolute error over paragraphs.
+type MAE struct {
+	Paragraphs int     `json:"paragraphs"`
+	AbsError   float64 `json:"abs_error"`
+	MAE        float64 `json:"mae"`
+}
+
+func (m *MAE) add(o MAE) {
+	m.Paragraphs += o.Paragraphs
+	m.AbsError += o.AbsError
+	m.MAE = 0
+	if m.Paragraphs > 0 {
+		m.MAE = m.AbsError / float64(m.Paragraphs)
+	}
+}
+
+// Score is the extraction quality of one story or a whole run.
+type Score struct {
+	// Names and NameAliases score /api/names; Characters and Aliases the summary's characters.
+	// Alias scores compare every surface form (name and aliases) of matched characters.
+	Names       PR `json:"names"`
+	NameAliases PR `json:"name_aliases"`
+	Characters  PR `json:"characters"`
+	Aliases     PR `json:"aliases"`
+	// Fields scores each annotated field of matched summary characters.
+	Fields        map[string]*Accuracy `json:"fields,omitempty"`
+	FieldAccuracy Accuracy             `json:"field_accuracy"`
+	Heat          MAE                  `json:"heat"`
+}
+
+// Add accumulates o into s.
+func (s *Score) Add(o Score) {
+	s.Names.add(o.Names)
+	s.NameAliases.add(o.NameAliases)
+	s.Characters.add(o.Characters)
+	s.Aliases.add(o.Aliases)
+	for name, a := range o.Fields {
+		if s.Fields == nil {
+			s.Fields = make(map[string]*Accuracy)
+		}
+		if s.Fields[name] == nil {
+			s.Fields[name] = new(Accuracy)
+		}
+		s.Fields[name].add(a.Correct, a.Total)
+	}
+	s.FieldAccuracy.add(o.FieldAccuracy.Correct, o.FieldAccuracy.Total)
+	s.Heat.add(o.Heat)
+}
+
+// Candidate is a predicted character name with its aliases.
+type Candidate struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// ScoreNames scores names predicted for g.
+func ScoreNames(g Gold, predicted []Candidate) Score {
+	var s Score
+	s.Names, s.NameAliases = scoreCandidates(g.Characters, predicted)
+	return s
+}
+
+// ScoreSummary scores the characters, fields and heat of a summary of g.
+func ScoreSummary(g Gold, summary schema.Summary) Score {
+	var s Score
+	candidates := make([]Candidate, len(summary.Characters))
+	for i, c := range summary.Characters {
+		candidates[i] = Candidate{Name: c.Name, Aliases: c.Aliases}
+	}
+	s.Characters, s.Aliases = scoreCandidates(g.Characters, candidates)
+
+	for gi, pi := range match(g.Characters, candidates) {
+		gold, pred := g.Characters[gi], summary.Characters[pi]
+		for _, f := range []struct{ name, want, got string }{
+			{"kind", gold.Kind, pred.Kind},
+			{"gender", gold.Gender, pred.Gender},
+			{"species", gold.Species, pred.Species},
+		} {
+			if f.want == "" {
+				continue
+			}
+			correct := 0
+			if normalizeField(f.want) == normalizeField(f.got) {
+				correct = 1
+			}
+			if s.Fields == nil {
+				s.Fields = make(map[string]*Accuracy)
+			}
+			if s.Fields[f.name] == nil {
+				s.Fields[f.name] = new(Accuracy)
+			}
+			s.Fields[f.name].add(correct, 1)
+			s.FieldAccuracy.add(correct, 1)
+		}
+	}
+
+	var heat MAE
+	for k, want := range g.Heat {
+		heat.add(MAE{Paragraphs: 1, AbsError: math.Abs(summary.Heat[k] - want)})
+	}
+	s.Heat = heat
+	return s
+}
+
+// normalizeField ignores case and the asterisk marking estimated values.
+func normalizeField(v string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimRight(strings.TrimSpace(v), "*")))
+}
+
+func scoreCandidates(gold []GoldCharacter, predicted []Candidate) (names, aliases PR) {
+	matches := match(gold, predicted)
+	names = PR{Matched: len(matches), Predicted: len(predicted), Expected: len(gold)}
+	names.score()
+	for gi, pi := range matches {
+		want, got := forms(gold[gi].Name, gold[gi].Aliases), forms(predicted[pi].Name, predicted[pi].Aliases)
+		for f := range got {
+			if _, ok := want[f]; ok {
+				aliases.Matched++
+			}
+		}
+		aliases.Predicted += len(got)
+		aliases.Expected += len(want)
+	}
+	aliases.score()
+	return names, aliases
+}
+
+// match pairs gold characters with predicted ones, returning predicted indices by gold index.
+// Exact name matches are paired first, then any shared name or alias; each prediction is used
+// once.
+func match(gold []GoldCharacter, predicted []Candidate) map[int]int {
+	matches := make(map[int]int)
+	used := make(map[int]bool)
+	for gi, g := range gold {
+		for pi, p := range predicted {
+			if !used[pi] && strings.EqualFold(strings.TrimSpace(g.Name), strings.TrimSpace(p.Name)) {
+				matches[gi], used[pi] = pi, true
+				break
+			}
+		}
+	}
+	for gi, g := range gold {
+		if _, ok := matches[gi]; ok {
+			continue
+		}
+		want := forms(g.Name, g.Aliases)
+		for pi, p := range predicted {
+			if used[pi] {
+				continue
+			}
+			if overlaps(want, forms(p.Name, p.Aliases)) {
+				matches[gi], used[pi] = pi, true
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// forms returns the lower-cased, distinct surface forms of a character.
+func forms(name string, aliases []string) map[string]struct{} {
+	out := make(map[string]struct{}, len(aliases)+1)
+	for _, n := range append([]string{name}, aliases...) {
+		if n = strings.ToLower(strings.TrimSpace(n)); n != "" {
+			out[n] = struct{}{}
+		}
+	}
+	return out
+}
+
+func overlaps(a, b map[string]struct{}) bool {
+	for k := range a {
+		if _, ok := b[k]; ok {
+			return true
+		}
+	}
+	return false
+}
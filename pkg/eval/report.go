@@ -0,0 +1,79 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Report writes run's scores per story and in total, with the change from previous when given.
+func Report(w io.Writer, run Run, previous *Run) {
+	fmt.Fprintf(w, "Evaluation %s", run.ID)
+	if run.Label != "" {
+		fmt.Fprintf(w, " (%s)", run.Label)
+	}
+	fmt.Fprintf(w, ", model %s, %d stories in %.1fs\n\n", run.Model, len(run.Stories), float64(run.DurationMS)/1000)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "story\tnames P/R\tcharacters P/R\taliases P/R\tfields\theat MAE\tcost\terrors")
+	for _, s := range run.Stories {
+		sc := s.Score
+		fmt.Fprintf(tw, "%s\t%.2f/%.2f\t%.2f/%.2f\t%.2f/%.2f\t%.2f\t%.2f\t$%.4f\t%d\n", s.ID,
+			sc.Names.Precision, sc.Names.Recall, sc.Characters.Precision, sc.Characters.Recall,
+			sc.Aliases.Precision, sc.Aliases.Recall, sc.FieldAccuracy.Accuracy, sc.Heat.MAE, s.Cost, len(s.Errors))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "metric\tvalue"
+	if previous != nil {
+		header += "\tprevious\tchange"
+	}
+	fmt.Fprintln(tw, header)
+	row := func(name string, value float64, prev func(Run) float64) {
+		fmt.Fprintf(tw, "%s\t%.4f", name, value)
+		if previous != nil {
+			p := prev(*previous)
+			fmt.Fprintf(tw, "\t%.4f\t%+.4f", p, value-p)
+		}
+		fmt.Fprintln(tw)
+	}
+	metric := func(name string, get func(Run) float64) { row(name, get(run), get) }
+	metric("names precision", func(r Run) float64 { return r.Total.Names.Precision })
+	metric("names recall", func(r Run) float64 { return r.Total.Names.Recall })
+	metric("names F1", func(r Run) float64 { return r.Total.Names.F1 })
+	metric("name aliases F1", func(r Run) float64 { return r.Total.NameAliases.F1 })
+	metric("characters precision", func(r Run) float64 { return r.Total.Characters.Precision })
+	metric("characters recall", func(r Run) float64 { return r.Total.Characters.Recall })
+	metric("characters F1", func(r Run) float64 { return r.Total.Characters.F1 })
+	metric("aliases precision", func(r Run) float64 { return r.Total.Aliases.Precision })
+	metric("aliases recall", func(r Run) float64 { return r.Total.Aliases.Recall })
+	metric("field accuracy", func(r Run) float64 { return r.Total.FieldAccuracy.Accuracy })
+	fields := make([]string, 0, len(run.Total.Fields))
+	for name := range run.Total.Fields {
+		fields = append(fields, name)
+	}
+	slices.Sort(fields)
+	for _, name := range fields {
+		metric(name+" accuracy", func(r Run) float64 {
+			if a := r.Total.Fields[name]; a != nil {
+				return a.Accuracy
+			}
+			return 0
+		})
+	}
+	metric("heat MAE", func(r Run) float64 { return r.Total.Heat.MAE })
+	metric("cost (USD)", func(r Run) float64 { return r.Cost })
+	metric("tokens", func(r Run) float64 { return float64(r.Usage.PromptTokens + r.Usage.CompletionTokens) })
+	tw.Flush()
+
+	for _, s := range run.Stories {
+		for _, e := range s.Errors {
+			fmt.Fprintf(w, "\n%s: %s", s.ID, strings.TrimSpace(e))
+		}
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,188 @@
+package eval
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"paige/pkg/inference"
+	"paige/pkg/schema"
+	"paige/pkg/utils"
+)
+
+// Runner evaluates gold stories through a paige server's /api/names and /api/summarize
+// handlers, so the whole pipeline runs, including chunking, repair and consolidation.
+type Runner struct {
+	Handler http.Handler
+	// Usage returns what was recorded for a story so far, to attribute cost; nil skips cost.
+	Usage func(story string) inference.Totals
+}
+
+// Run is one evaluation of every gold story.
+type Run struct {
+	ID    string `json:"id"`
+	Label string `json:"label,omitempty"`
+	// Model is the configured inferencer's model.
+	Model      string          `json:"model,omitempty"`
+	Started    time.Time       `json:"started"`
+	DurationMS int64           `json:"duration_ms"`
+	Stories    []StoryResult   `json:"stories"`
+	Total      Score           `json:"total"`
+	Calls      int64           `json:"calls"`
+	Usage      inference.Usage `json:"usage"`
+	Cost       float64         `json:"cost"`
+}
+
+// StoryResult is the evaluation of one gold story.
+type StoryResult struct {
+	ID    string          `json:"id"`
+	Score Score           `json:"score"`
+	Calls int64           `json:"calls"`
+	Usage inference.Usage `json:"usage"`
+	Cost  float64         `json:"cost"`
+	// Errors lists failed requests; a failed task scores as if nothing was extracted.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Run evaluates every story in order.
+func (r *Runner) Run(ctx context.Context, label, model string, stories []Gold) Run {
+	run := Run{ID: time.Now().UTC().Format("20060102T150405.000Z"), Label: label, Model: model, Started: time.Now().UTC()}
+	for _, g := range stories {
+		if ctx.Err() != nil {
+			break
+		}
+		res := r.Story(ctx, g)
+		run.Stories = append(run.Stories, res)
+		run.Total.Add(res.Score)
+		run.Calls += res.Calls
+		run.Usage.Add(res.Usage)
+		run.Cost += res.Cost
+	}
+	run.DurationMS = time.Since(run.Started).Milliseconds()
+	return run
+}
+
+// Story extracts names and a summary of g and scores both.
+func (r *Runner) Story(ctx context.Context, g Gold) StoryResult {
+	res := StoryResult{ID: g.ID}
+	// Gold stories get their own ID so they never extend a stored summary.
+	id := "eval/" + g.ID
+	story := id
+	if g.Source != "" {
+		story = g.Source + ":" + id
+	}
+	var before inference.Totals
+	if r.Usage != nil {
+		before = r.Usage(story)
+	}
+
+	var names struct {
+		Characters []Candidate `json:"characters"`
+	}
+	body, err := r.post(ctx, "/api/names", map[string]string{"text": g.Text, "id": id, "source": g.Source})
+	if err == nil {
+		err = json.Unmarshal(body, &names)
+	}
+	if err != nil {
+		res.Errors = append(res.Errors, "names: "+err.Error())
+	}
+	res.Score.Add(ScoreNames(g, names.Characters))
+
+	summary, err := r.summarize(ctx, id, g)
+	if err != nil {
+		res.Errors = append(res.Errors, "summarize: "+err.Error())
+	}
+	res.Score.Add(ScoreSummary(g, summary))
+
+	if r.Usage != nil {
+		after := r.Usage(story)
+		res.Calls = after.Calls - before.Calls
+		res.Cost = after.Cost - before.Cost
+		res.Usage = inference.Usage{
+			PromptTokens:     after.Usage.PromptTokens - before.Usage.PromptTokens,
+			CompletionTokens: after.Usage.CompletionTokens - before.Usage.CompletionTokens,
+			CachedTokens:     after.Usage.CachedTokens - before.Usage.CachedTokens,
+			CacheWriteTokens: after.Usage.CacheWriteTokens - before.Usage.CacheWriteTokens,
+			ReasoningTokens:  after.Usage.ReasoningTokens - before.Usage.ReasoningTokens,
+		}
+	}
+	return res
+}
+
+// summarize reads the summarization event stream up to its done event.
+func (r *Runner) summarize(ctx context.Context, id string, g Gold) (schema.Summary, error) {
+	body, err := r.post(ctx, "/api/summarize", map[string]any{"id": id, "source": g.Source, "chapter": g.Chapter, "paragraphs": g.Paragraphs})
+	if err != nil {
+		return schema.Summary{}, err
+	}
+	var event string
+	var errs []string
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := strings.TrimPrefix(line, "data: ")
+			switch event {
+			case "done":
+				var summary schema.Summary
+				err := json.Unmarshal([]byte(data), &summary)
+				return summary, err
+			case "error":
+				errs = append(errs, data)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return schema.Summary{}, errors.New(strings.Join(errs, "; "))
+	}
+	return schema.Summary{}, fmt.Errorf("no summary in response: %s", body[:min(len(body), 200)])
+}
+
+func (r *Runner) post(ctx context.Context, path string, payload any) ([]byte, error) {
+	bin, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, path, bytes.NewReader(bin))
+	req.Header.Set("Content-Type", "application/json")
+	// Cached responses would score the cache and report no calls, cost or latency.
+	req.Header.Set("X-Paige-Cache", "bypass")
+	rec := httptest.NewRecorder()
+	r.Handler.ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Result().Body)
+	if rec.Code != http.StatusOK {
+		return body, fmt.Errorf("status %d: %s", rec.Code, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// LoadRuns reads the stored runs, oldest first; a missing file has none.
+func LoadRuns(path string) ([]Run, error) {
+	runs, err := utils.Load[[]Run](path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return runs, err
+}
+
+// SaveRun appends run to the runs stored at path.
+func SaveRun(path string, run Run) error {
+	runs, err := LoadRuns(path)
+	if err != nil {
+		return err
+	}
+	return utils.Save(path, append(runs, run))
+}
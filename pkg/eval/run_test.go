@@ -0,0 +1,53 @@
+package eval
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"paige/pkg/inference"
+	"paige/pkg/inference/fake"
+	"paige/pkg/schema"
+	"paige/pkg/server"
+)
+
+// TestRunGold scores the gold stories against the scripted fake, as CI does with
+// FAKE_SCRIPT=eval/fake.json paige eval eval/gold.
+func TestRunGold(t *testing.T) {
+	stories, err := LoadGold("../../eval/gold")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := fake.Load("../../eval/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger := inference.NewLedger(inference.LedgerData{}, nil, 0)
+	cache := inference.NewDiskCache(t.TempDir(), time.Hour, 0)
+	inf := inference.WithCache(inference.WithAccounting(script, "fake", ledger), "fake", cache)
+
+	ctx := context.Background()
+	srv := server.NewServer(ctx, inf, nil)
+	srv.Summary = make(map[string]schema.Summary)
+	srv.Ephemeral = true
+	srv.Ledger = ledger
+	srv.Cache = cache
+	runner := &Runner{Handler: srv.Echo, Usage: ledger.Story}
+
+	first := runner.Run(ctx, "", inference.ModelOf(inf), stories)
+	for _, s := range first.Stories {
+		if len(s.Errors) > 0 {
+			t.Errorf("story %s: %v", s.ID, s.Errors)
+		}
+	}
+	if f1 := first.Total.Names.F1; f1 < 0.9 {
+		t.Errorf("names F1 = %.4f, want at least 0.9", f1)
+	}
+	if first.Calls == 0 {
+		t.Fatal("first run recorded no calls")
+	}
+	// A repeat run must reach the provider again rather than score the cache.
+	if second := runner.Run(ctx, "", inference.ModelOf(inf), stories); second.Calls != first.Calls {
+		t.Errorf("repeat run calls = %d, want %d", second.Calls, first.Calls)
+	}
+}
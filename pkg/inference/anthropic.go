@@ -0,0 +1,299 @@
+package inference
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+const anthropicVersion = "2023-06-01"
+
+// AnthropicInferencer implements Inferencer using the Anthropic Messages API.
+type AnthropicInferencer struct {
+	client  *http.Client
+	apiKey  string
+	model   string
+	baseURL string
+	// MaxTokens caps max_tokens, which the Messages API requires and rejects above the model's
+	// limit. Zero takes the cap from MaxOutputTokens.
+	MaxTokens int64
+	// Reasoning enables extended thinking with a token budget per task.
+	Reasoning ReasoningPolicy
+	// PromptCaching marks the system prompt and the stable prefix of the user message as cache
+	// breakpoints, so repeated calls read them from the prompt cache.
+	PromptCaching bool
+}
+
+// NewAnthropicInferencer creates a new inferencer instance for the Anthropic Messages API.
+func NewAnthropicInferencer(apiKey string, model string) *AnthropicInferencer {
+	return &AnthropicInferencer{
+		client:        &http.Client{Timeout: 10 * time.Minute},
+		apiKey:        apiKey,
+		model:         cmp.Or(model, "claude-sonnet-4-5"),
+		baseURL:       "https://api.anthropic.com",
+		PromptCaching: true,
+	}
+}
+
+func (o *AnthropicInferencer) ChangeBaseURL(baseURL string) {
+	o.baseURL = strings.TrimRight(baseURL, "/")
+}
+
+func (o *AnthropicInferencer) SetModel(model string) {
+	o.model = model
+}
+
+// Model returns the default model used when params do not set one.
+func (o *AnthropicInferencer) Model() string {
+	return o.model
+}
+
+// ReasoningFor returns the reasoning settings applied to task.
+func (o *AnthropicInferencer) ReasoningFor(task string) Reasoning {
+	return o.Reasoning.For(task)
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int64              `json:"max_tokens"`
+	System      []anthropicBlock   `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice  *anthropicChoice   `json:"tool_choice,omitempty"`
+	Thinking    *anthropicThinking `json:"thinking,omitempty"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int64  `json:"budget_tokens"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type anthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+type anthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type anthropicChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type anthropicResponse struct {
+	Model   string `json:"model"`
+	Content []struct {
+		Type     string          `json:"type"`
+		Text     string          `json:"text,omitempty"`
+		Thinking string          `json:"thinking,omitempty"`
+		Name     string          `json:"name,omitempty"`
+		Input    json.RawMessage `json:"input,omitempty"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens              int64 `json:"input_tokens"`
+		OutputTokens             int64 `json:"output_tokens"`
+		CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+	} `json:"usage"`
+}
+
+// AnthropicError is returned when the Messages API responds with a non-2xx status.
+type AnthropicError struct {
+	StatusCode int
+	Type       string
+	Message    string
+	// RetryAfter is the delay requested by the retry-after header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *AnthropicError) Error() string {
+	return fmt.Sprintf("anthropic %d %s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+// Infer sends text to the Anthropic Messages endpoint and returns the output.
+// A json_schema response format is enforced by forcing a single tool call whose input is the schema.
+func (o *AnthropicInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	if params == nil {
+		params = new(openai.ChatCompletionNewParams)
+	}
+	model := cmp.Or(params.Model, o.model)
+	limit := cmp.Or(o.MaxTokens, int64(MaxOutputTokensFor(model)), 4096)
+	req := anthropicRequest{
+		Model:     model,
+		MaxTokens: min(cmp.Or(params.MaxCompletionTokens.Value, params.MaxTokens.Value, 4096), limit),
+		System:    o.blocks(system),
+		Messages:  []anthropicMessage{{Role: "user", Content: o.blocks(splitPrefix(ctx, user))}},
+	}
+	// Recent models reject temperature and top_p together, so only temperature is mapped.
+	temperature := min(cmp.Or(params.Temperature.Value, 0.3), 1)
+	req.Temperature = &temperature
+
+	var tool string
+	if js := params.ResponseFormat.OfJSONSchema; js != nil {
+		tool = js.JSONSchema.Name
+		req.Tools = []anthropicTool{{
+			Name:        tool,
+			Description: js.JSONSchema.Description.Value,
+			InputSchema: plainSchema(js.JSONSchema.Schema),
+		}}
+		req.ToolChoice = &anthropicChoice{Type: "tool", Name: tool}
+	}
+
+	// Extended thinking needs max_tokens above the budget, the default temperature and a tool
+	// choice the model may decline; a text answer is used when it does not call the tool.
+	if budget := o.Reasoning.For(ScopeFrom(ctx).Task).Budget; budget > 0 {
+		req.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+		req.MaxTokens = min(req.MaxTokens+budget, max(limit, budget+1))
+		req.Temperature = nil
+		if req.ToolChoice != nil {
+			req.ToolChoice = &anthropicChoice{Type: "auto"}
+		}
+	}
+
+	resp, err := o.send(ctx, req)
+	if err != nil {
+		return Result{}, classifyAnthropic(err)
+	}
+
+	var out, text strings.Builder
+	var thoughts []string
+	for _, block := range resp.Content {
+		switch {
+		case block.Type == "thinking":
+			thoughts = append(thoughts, block.Thinking)
+		case block.Type == "tool_use" && tool != "" && block.Name == tool && out.Len() == 0:
+			out.Write(block.Input)
+		case block.Type == "text":
+			text.WriteString(block.Text)
+		}
+	}
+	if tool == "" || out.Len() == 0 {
+		content, inline := SplitThink(text.String())
+		out.Reset()
+		out.WriteString(content)
+		thoughts = append(thoughts, inline)
+	}
+	res := Result{
+		Content:   out.String(),
+		Reasoning: joinReasoning(thoughts...),
+		Model:     resp.Model,
+		Usage: Usage{
+			PromptTokens:     resp.Usage.InputTokens + resp.Usage.CacheCreationInputTokens + resp.Usage.CacheReadInputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			CachedTokens:     resp.Usage.CacheReadInputTokens,
+			CacheWriteTokens: resp.Usage.CacheCreationInputTokens,
+		},
+	}
+	return res, finishError("anthropic", resp.StopReason, res.Content)
+}
+
+// blocks splits texts into content blocks, skipping empty ones. With PromptCaching every block
+// but the last is a cache breakpoint: the system prompt alone, or the stable prefix of the user
+// message followed by its variable rest.
+func (o *AnthropicInferencer) blocks(texts ...string) []anthropicBlock {
+	var out []anthropicBlock
+	for _, text := range texts {
+		if text != "" {
+			out = append(out, anthropicBlock{Type: "text", Text: text})
+		}
+	}
+	if !o.PromptCaching || len(out) == 0 {
+		return out
+	}
+	breakpoints := out
+	if len(texts) > 1 {
+		breakpoints = out[:len(out)-1]
+	}
+	for i := range breakpoints {
+		breakpoints[i].CacheControl = &anthropicCacheControl{Type: "ephemeral"}
+	}
+	return out
+}
+
+func (o *AnthropicInferencer) send(ctx context.Context, body anthropicRequest) (*anthropicResponse, error) {
+	bin, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/v1/messages", bytes.NewReader(bin))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", o.apiKey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &AnthropicError{StatusCode: resp.StatusCode, Message: string(data), RetryAfter: retryAfter(resp.Header)}
+		var payload struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &payload) == nil && payload.Error.Type != "" {
+			apiErr.Type = payload.Error.Type
+			apiErr.Message = payload.Error.Message
+		}
+		return nil, apiErr
+	}
+
+	var out anthropicResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &out, nil
+}
+
+// Edit mirrors Infer but allows the caller to provide editing-specific defaults.
+func (o *AnthropicInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	if params == nil {
+		params = new(openai.ChatCompletionNewParams)
+	}
+	if params.MaxCompletionTokens.Value == 0 {
+		params.MaxCompletionTokens = openai.Int(int64(len(user) * 2))
+	}
+	if params.Temperature.Value == 0 {
+		params.Temperature = openai.Float(0.2)
+	}
+	return o.Infer(ctx, params, system, user)
+}
+
+// Verify validates the result against schema and returns the violations found.
+func (o *AnthropicInferencer) Verify(ctx context.Context, schema any, result string) ([]Violation, error) {
+	return Validate(schema, result)
+}
@@ -0,0 +1,261 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// anthropicServer serves reply as the Messages API and records the last request body.
+func anthropicServer(t *testing.T, status int, header http.Header, reply string) (*AnthropicInferencer, *anthropicRequest) {
+	t.Helper()
+	got := new(anthropicRequest)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("path = %q, want /v1/messages", r.URL.Path)
+		}
+		if r.Header.Get("X-Api-Key") != "key" || r.Header.Get("Anthropic-Version") != anthropicVersion {
+			t.Errorf("missing auth or version header: %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, reply)
+	}))
+	t.Cleanup(srv.Close)
+	inf := NewAnthropicInferencer("key", "claude-test")
+	inf.ChangeBaseURL(srv.URL)
+	inf.PromptCaching = false
+	return inf, got
+}
+
+const anthropicText = `{"model":"claude-test","content":[{"type":"text","text":"hello"}],"stop_reason":"end_turn",
+	"usage":{"input_tokens":10,"output_tokens":5,"cache_read_input_tokens":3,"cache_creation_input_tokens":2}}`
+
+func TestAnthropicSystemPrompt(t *testing.T) {
+	inf, got := anthropicServer(t, http.StatusOK, nil, anthropicText)
+	res, err := inf.Infer(context.Background(), nil, "be brief", "say hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.System) != 1 || got.System[0].Text != "be brief" {
+		t.Errorf("system = %+v, want one block with the system prompt", got.System)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Role != "user" || got.Messages[0].Content[0].Text != "say hello" {
+		t.Errorf("messages = %+v, want one user message", got.Messages)
+	}
+	if res.Content != "hello" || res.Model != "claude-test" {
+		t.Errorf("result = %+v", res)
+	}
+	want := Usage{PromptTokens: 15, CompletionTokens: 5, CachedTokens: 3, CacheWriteTokens: 2}
+	if res.Usage != want {
+		t.Errorf("usage = %+v, want %+v", res.Usage, want)
+	}
+}
+
+func TestAnthropicParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      *openai.ChatCompletionNewParams
+		maxTokens   int64
+		temperature float64
+	}{
+		{"defaults", nil, 4096, 0.3},
+		{"max completion tokens", &openai.ChatCompletionNewParams{MaxCompletionTokens: openai.Int(1000), Temperature: openai.Float(0.7)}, 1000, 0.7},
+		{"legacy max tokens", &openai.ChatCompletionNewParams{MaxTokens: openai.Int(2000)}, 2000, 0.3},
+		{"capped to the model limit", &openai.ChatCompletionNewParams{MaxCompletionTokens: openai.Int(1 << 20)}, 64000, 0.3},
+		{"temperature capped at 1", &openai.ChatCompletionNewParams{Temperature: openai.Float(1.5)}, 4096, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inf, got := anthropicServer(t, http.StatusOK, nil, anthropicText)
+			if _, err := inf.Infer(context.Background(), tt.params, "", "hi"); err != nil {
+				t.Fatal(err)
+			}
+			if got.MaxTokens != tt.maxTokens {
+				t.Errorf("max_tokens = %d, want %d", got.MaxTokens, tt.maxTokens)
+			}
+			if got.Temperature == nil || *got.Temperature != tt.temperature {
+				t.Errorf("temperature = %v, want %v", got.Temperature, tt.temperature)
+			}
+			if got.Model != "claude-test" {
+				t.Errorf("model = %q", got.Model)
+			}
+		})
+	}
+}
+
+func TestAnthropicMaxTokensPerModel(t *testing.T) {
+	tests := []struct {
+		model string
+		limit int64
+		want  int64
+	}{
+		{"claude-3-5-haiku-latest", 0, 8192},
+		{"claude-opus-4-1", 0, 32000},
+		{"claude-opus-4-5", 0, 64000},
+		{"claude-sonnet-4-5", 0, 64000},
+		{"claude-opus-4-1", 16000, 16000},
+	}
+	for _, tt := range tests {
+		inf, got := anthropicServer(t, http.StatusOK, nil, anthropicText)
+		inf.MaxTokens = tt.limit
+		params := &openai.ChatCompletionNewParams{Model: tt.model, MaxCompletionTokens: openai.Int(65536)}
+		if _, err := inf.Infer(context.Background(), params, "", "hi"); err != nil {
+			t.Fatal(err)
+		}
+		if got.MaxTokens != tt.want {
+			t.Errorf("%s with limit %d: max_tokens = %d, want %d", tt.model, tt.limit, got.MaxTokens, tt.want)
+		}
+	}
+}
+
+func TestAnthropicJSONSchemaToolUse(t *testing.T) {
+	inf, got := anthropicServer(t, http.StatusOK, nil, `{"model":"claude-test","stop_reason":"tool_use","content":[
+		{"type":"text","text":"Here you go"},
+		{"type":"tool_use","name":"names","input":{"names":["Ada"]}}]}`)
+	params := &openai.ChatCompletionNewParams{
+		ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{JSONSchema: openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   "names",
+				Schema: map[string]any{"$schema": "https://json-schema.org/draft/2020-12/schema", "type": "object"},
+			}},
+		},
+	}
+	res, err := inf.Infer(context.Background(), params, "", "who is here?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ToolChoice == nil || got.ToolChoice.Type != "tool" || got.ToolChoice.Name != "names" {
+		t.Errorf("tool_choice = %+v, want the names tool forced", got.ToolChoice)
+	}
+	if len(got.Tools) != 1 || got.Tools[0].Name != "names" {
+		t.Fatalf("tools = %+v", got.Tools)
+	}
+	if s, _ := got.Tools[0].InputSchema.(map[string]any); s["type"] != "object" || s["$schema"] != nil {
+		t.Errorf("input_schema = %v, want the schema without $schema", got.Tools[0].InputSchema)
+	}
+	if res.Content != `{"names":["Ada"]}` {
+		t.Errorf("content = %q, want the tool input", res.Content)
+	}
+}
+
+func TestAnthropicStopReason(t *testing.T) {
+	tests := []struct {
+		reason, text string
+		want         error
+	}{
+		{"end_turn", "done", nil},
+		{"stop_sequence", "done", nil},
+		{"max_tokens", "partial", ErrTruncated},
+		{"refusal", "", ErrContentRefused},
+		{"end_turn", " ", ErrEmptyCompletion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			reply, _ := json.Marshal(map[string]any{
+				"model":       "claude-test",
+				"stop_reason": tt.reason,
+				"content":     []map[string]string{{"type": "text", "text": tt.text}},
+			})
+			inf, _ := anthropicServer(t, http.StatusOK, nil, string(reply))
+			res, err := inf.Infer(context.Background(), nil, "", "hi")
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if tt.reason == "max_tokens" && res.Content != "partial" {
+				t.Errorf("truncated content = %q, want it kept", res.Content)
+			}
+		})
+	}
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		header    http.Header
+		body      string
+		kind      error
+		retryable bool
+		wait      time.Duration
+	}{
+		{
+			name:      "rate limited",
+			status:    http.StatusTooManyRequests,
+			header:    http.Header{"Retry-After": {"7"}},
+			body:      `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`,
+			kind:      ErrRateLimited,
+			retryable: true,
+			wait:      7 * time.Second,
+		},
+		{
+			name:      "overloaded",
+			status:    529,
+			body:      `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			retryable: true,
+		},
+		{
+			name:      "server error",
+			status:    http.StatusInternalServerError,
+			body:      `{"type":"error","error":{"type":"api_error","message":"Internal server error"}}`,
+			retryable: true,
+		},
+		{
+			name:   "context overflow",
+			status: http.StatusBadRequest,
+			body:   `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`,
+			kind:   ErrContextTooLong,
+		},
+		{
+			name:   "invalid request",
+			status: http.StatusBadRequest,
+			body:   `{"type":"error","error":{"type":"invalid_request_error","message":"temperature: range"}}`,
+		},
+		{
+			name:   "authentication",
+			status: http.StatusUnauthorized,
+			body:   `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			kind:   ErrAuth,
+		},
+		{
+			name:   "permission",
+			status: http.StatusForbidden,
+			body:   `{"type":"error","error":{"type":"permission_error","message":"not allowed"}}`,
+			kind:   ErrAuth,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inf, _ := anthropicServer(t, tt.status, tt.header, tt.body)
+			_, err := inf.Infer(context.Background(), nil, "", "hi")
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("err = %v, want *Error", err)
+			}
+			if e.Provider != "anthropic" || e.StatusCode != tt.status {
+				t.Errorf("provider, status = %q, %d", e.Provider, e.StatusCode)
+			}
+			if tt.kind == nil && e.Kind != nil || tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Errorf("kind = %v, want %v", e.Kind, tt.kind)
+			}
+			wait, ok := retryable(err)
+			if ok != tt.retryable || wait != tt.wait {
+				t.Errorf("retryable = %v, %v; want %v, %v", wait, ok, tt.wait, tt.retryable)
+			}
+		})
+	}
+}
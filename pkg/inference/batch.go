@@ -0,0 +1,246 @@
+package inference
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// ErrBatchUnsupported is returned when the configured provider has no batch API.
+var ErrBatchUnsupported = errors.New("provider does not support batches")
+
+// BatchRequest is one call of a batch. Its result is matched back by CustomID, since batch
+// results arrive in any order.
+type BatchRequest struct {
+	CustomID string
+	// Scope selects per-task policies such as reasoning effort, as WithScope does for Infer.
+	Scope  Scope
+	Params *openai.ChatCompletionNewParams
+	System string
+	User   string
+}
+
+// Batch states reported in BatchStatus.State.
+const (
+	BatchPending   = "pending"
+	BatchCompleted = "completed"
+	BatchFailed    = "failed"
+)
+
+// BatchStatus is the progress of a submitted batch.
+type BatchStatus struct {
+	ID string `json:"id"`
+	// State is one of the Batch* states; Provider holds the provider's own status.
+	State     string `json:"state"`
+	Provider  string `json:"provider_status,omitempty"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	Failed    int64  `json:"failed"`
+	Error     string `json:"error,omitempty"`
+
+	OutputFileID string `json:"output_file_id,omitempty"`
+	ErrorFileID  string `json:"error_file_id,omitempty"`
+}
+
+// BatchResult is the outcome of one request of a batch.
+type BatchResult struct {
+	CustomID string
+	Result   Result
+	Err      error
+}
+
+// Batcher submits calls to a provider's asynchronous batch API.
+type Batcher interface {
+	SubmitBatch(ctx context.Context, reqs []BatchRequest) (BatchStatus, error)
+	BatchStatus(ctx context.Context, id string) (BatchStatus, error)
+	// BatchResults downloads the results of a finished batch, in the order the provider wrote them.
+	BatchResults(ctx context.Context, status BatchStatus) ([]BatchResult, error)
+}
+
+// BatcherOf returns the Batcher behind inf, looking through decorators. OpenAI-compatible
+// backends whose preset lacks batch support are skipped.
+func BatcherOf(inf Inferencer) (Batcher, bool) {
+	for inf != nil {
+		if b, ok := inf.(Batcher); ok {
+			if p, ok := b.(interface{ Preset() Preset }); ok && !p.Preset().Batch {
+				return nil, false
+			}
+			return b, true
+		}
+		u, ok := inf.(Unwrapper)
+		if !ok {
+			break
+		}
+		inf = u.Unwrap()
+	}
+	return nil, false
+}
+
+// batchLine is a line of the JSONL input file.
+type batchLine struct {
+	CustomID string                         `json:"custom_id"`
+	Method   string                         `json:"method"`
+	URL      string                         `json:"url"`
+	Body     openai.ChatCompletionNewParams `json:"body"`
+}
+
+// batchOutput is a line of the output and error files.
+type batchOutput struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// SubmitBatch uploads reqs as a JSONL file and creates a chat completions batch for it.
+func (o *OpenAIInferencer) SubmitBatch(ctx context.Context, reqs []BatchRequest) (BatchStatus, error) {
+	if !o.preset.Batch {
+		return BatchStatus{}, &Error{Provider: o.preset.Name, Err: ErrBatchUnsupported}
+	}
+	var input bytes.Buffer
+	enc := json.NewEncoder(&input)
+	for _, r := range reqs {
+		line := batchLine{
+			CustomID: r.CustomID,
+			Method:   http.MethodPost,
+			URL:      "/v1/chat/completions",
+			Body:     o.request(WithScope(ctx, r.Scope), r.Params, r.System, r.User),
+		}
+		if err := enc.Encode(line); err != nil {
+			return BatchStatus{}, fmt.Errorf("failed to encode batch request %s: %w", r.CustomID, err)
+		}
+	}
+
+	file, err := o.client.Files.New(ctx, openai.FileNewParams{
+		File:    openai.File(&input, "batch.jsonl", "application/jsonl"),
+		Purpose: openai.FilePurposeBatch,
+	})
+	if err != nil {
+		return BatchStatus{}, classifyOpenAI(o.preset.Name, err)
+	}
+	batch, err := o.client.Batches.New(ctx, openai.BatchNewParams{
+		CompletionWindow: openai.BatchNewParamsCompletionWindow24h,
+		Endpoint:         openai.BatchNewParamsEndpointV1ChatCompletions,
+		InputFileID:      file.ID,
+	})
+	if err != nil {
+		return BatchStatus{}, classifyOpenAI(o.preset.Name, err)
+	}
+	return batchStatus(batch), nil
+}
+
+// BatchStatus polls a submitted batch.
+func (o *OpenAIInferencer) BatchStatus(ctx context.Context, id string) (BatchStatus, error) {
+	batch, err := o.client.Batches.Get(ctx, id)
+	if err != nil {
+		return BatchStatus{}, classifyOpenAI(o.preset.Name, err)
+	}
+	return batchStatus(batch), nil
+}
+
+func batchStatus(b *openai.Batch) BatchStatus {
+	status := BatchStatus{
+		ID:           b.ID,
+		Provider:     string(b.Status),
+		Total:        b.RequestCounts.Total,
+		Completed:    b.RequestCounts.Completed,
+		Failed:       b.RequestCounts.Failed,
+		OutputFileID: b.OutputFileID,
+		ErrorFileID:  b.ErrorFileID,
+	}
+	switch b.Status {
+	case openai.BatchStatusCompleted:
+		status.State = BatchCompleted
+	case openai.BatchStatusFailed, openai.BatchStatusCancelled:
+		status.State = BatchFailed
+	case openai.BatchStatusExpired:
+		// Requests finished before expiry are still in the output file.
+		status.State = BatchCompleted
+		status.Error = "batch expired"
+	default:
+		status.State = BatchPending
+	}
+	var msgs []string
+	for _, e := range b.Errors.Data {
+		msgs = append(msgs, strings.TrimSpace(e.Code+" "+e.Message))
+	}
+	if len(msgs) > 0 {
+		status.Error = strings.Join(msgs, "; ")
+	}
+	return status
+}
+
+// BatchResults downloads the output and error files of a finished batch.
+func (o *OpenAIInferencer) BatchResults(ctx context.Context, status BatchStatus) ([]BatchResult, error) {
+	var results []BatchResult
+	for _, id := range []string{status.OutputFileID, status.ErrorFileID} {
+		if id == "" {
+			continue
+		}
+		resp, err := o.client.Files.Content(ctx, id)
+		if err != nil {
+			return results, classifyOpenAI(o.preset.Name, err)
+		}
+		parsed, err := o.batchResults(resp.Body)
+		resp.Body.Close()
+		results = append(results, parsed...)
+		if err != nil {
+			return results, fmt.Errorf("failed to read batch file %s: %w", id, err)
+		}
+	}
+	return results, nil
+}
+
+func (o *OpenAIInferencer) batchResults(r io.Reader) ([]BatchResult, error) {
+	var results []BatchResult
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var line batchOutput
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			return results, err
+		}
+		res := BatchResult{CustomID: line.CustomID}
+		switch {
+		case line.Error != nil:
+			res.Err = &Error{Provider: o.preset.Name, Raw: line.Error.Code + " " + line.Error.Message}
+		case line.Response == nil:
+			res.Err = &Error{Kind: ErrEmptyCompletion, Provider: o.preset.Name, Raw: "no response"}
+		case line.Response.StatusCode != http.StatusOK:
+			raw := string(line.Response.Body)
+			e := &Error{Provider: o.preset.Name, StatusCode: line.Response.StatusCode, Raw: raw, Kind: statusKind(line.Response.StatusCode)}
+			switch {
+			case line.Response.StatusCode == http.StatusForbidden, isContentFilter(raw):
+				e.Kind = ErrContentRefused
+			case isContextOverflow(raw):
+				e.Kind = ErrContextTooLong
+			}
+			res.Err = e
+		default:
+			var completion openai.ChatCompletion
+			if err := json.Unmarshal(line.Response.Body, &completion); err != nil {
+				res.Err = &Error{Provider: o.preset.Name, Raw: string(line.Response.Body), Err: err}
+				break
+			}
+			res.Result, res.Err = o.result(&completion)
+		}
+		results = append(results, res)
+	}
+	return results, sc.Err()
+}
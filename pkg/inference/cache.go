@@ -0,0 +1,299 @@
+package inference
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// DiskCache stores inference results as JSON files under Dir.
+type DiskCache struct {
+	Dir string
+	// TTL is how long an entry stays valid; zero keeps entries forever. The server only builds a
+	// cache for a positive INFERENCE_CACHE_TTL, so it always expires entries.
+	TTL time.Duration
+	// MaxBytes bounds the total size on disk; the oldest entries are evicted first. Zero disables the bound.
+	MaxBytes int64
+
+	mu sync.Mutex
+	// size is the bytes on disk, scanned once on the first Put and tracked from then on.
+	size    int64
+	scanned bool
+}
+
+type cacheEntry struct {
+	CreatedAt time.Time `json:"created_at"`
+	Provider  string    `json:"provider"`
+	Model     string    `json:"model"`
+	Result    Result    `json:"result"`
+}
+
+// NewDiskCache creates a cache rooted at dir.
+func NewDiskCache(dir string, ttl time.Duration, maxBytes int64) *DiskCache {
+	return &DiskCache{Dir: dir, TTL: ttl, MaxBytes: maxBytes}
+}
+
+func (d *DiskCache) path(key string) string {
+	return filepath.Join(d.Dir, key[:2], key+".json")
+}
+
+// Get returns the cached result for key if present and not expired.
+func (d *DiskCache) Get(key string) (Result, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path := d.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Result{}, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		_ = os.Remove(path)
+		return Result{}, false
+	}
+	if d.TTL > 0 && time.Since(e.CreatedAt) > d.TTL {
+		d.remove(path)
+		return Result{}, false
+	}
+	return e.Result, true
+}
+
+// Put stores res under key and evicts old entries beyond MaxBytes.
+func (d *DiskCache) Put(key, provider, model string, res Result) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(cacheEntry{CreatedAt: time.Now().UTC(), Provider: provider, Model: model, Result: res})
+	if err != nil {
+		return err
+	}
+	if err := d.scan(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		d.size -= info.Size()
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	d.size += int64(len(data))
+	return d.evict()
+}
+
+// Forget removes the entry for key, e.g. after the caller rejected its content.
+func (d *DiskCache) Forget(key string) {
+	if key == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.remove(d.path(key))
+}
+
+// remove deletes the entry at path and drops its size from the running total.
+func (d *DiskCache) remove(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if os.Remove(path) == nil && d.scanned {
+		d.size -= info.Size()
+	}
+}
+
+// scan totals the entries already on disk, once, so Put does not walk the directory each time.
+func (d *DiskCache) scan() error {
+	if d.scanned || d.MaxBytes <= 0 {
+		return nil
+	}
+	d.size = 0
+	err := filepath.WalkDir(d.Dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() || !strings.HasSuffix(path, ".json") {
+			return err
+		}
+		if info, err := de.Info(); err == nil {
+			d.size += info.Size()
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	d.scanned = true
+	return nil
+}
+
+// evict removes the oldest entries once the tracked size exceeds MaxBytes. Only then is the
+// directory walked, and the walk resets the running total.
+func (d *DiskCache) evict() error {
+	if d.MaxBytes <= 0 || d.size <= d.MaxBytes {
+		return nil
+	}
+	type file struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var files []file
+	var total int64
+	err := filepath.WalkDir(d.Dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() || !strings.HasSuffix(path, ".json") {
+			return err
+		}
+		info, err := de.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, file{path: path, size: info.Size(), mod: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.size = total
+	if total <= d.MaxBytes {
+		return nil
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.mod.Compare(b.mod) })
+	for _, f := range files {
+		if total <= d.MaxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			total -= f.size
+		}
+	}
+	d.size = total
+	return nil
+}
+
+type cacheBypassKey struct{}
+
+// WithCacheBypass makes calls made with ctx skip cache lookups. Fresh results are still stored.
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+// CachingInferencer serves repeated identical calls from a DiskCache.
+type CachingInferencer struct {
+	Inferencer
+	Provider string
+	Cache    *DiskCache
+}
+
+// WithCache wraps inf with a response cache for the named provider.
+func WithCache(inf Inferencer, provider string, cache *DiskCache) *CachingInferencer {
+	return &CachingInferencer{Inferencer: inf, Provider: provider, Cache: cache}
+}
+
+// Infer serves the call from cache when possible. Only results containing
+// valid JSON are stored, so a malformed generation can simply be retried.
+func (c *CachingInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	return c.do(ctx, "infer", params, system, user, func(res Result) bool {
+		return json.Valid([]byte(jsonPayload(res.Content)))
+	}, c.Inferencer.Infer)
+}
+
+// Edit serves the call from cache when possible.
+func (c *CachingInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	return c.do(ctx, "edit", params, system, user, func(res Result) bool {
+		return strings.TrimSpace(res.Content) != ""
+	}, c.Inferencer.Edit)
+}
+
+type inferFunc func(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error)
+
+func (c *CachingInferencer) do(ctx context.Context, op string, params *openai.ChatCompletionNewParams, system, user string, keep func(Result) bool, call inferFunc) (Result, error) {
+	model := ModelOf(c.Inferencer)
+	if params != nil {
+		model = cmp.Or(params.Model, model)
+	}
+	key, err := c.key(ctx, op, model, params, system, user)
+	if err != nil {
+		return call(ctx, params, system, user)
+	}
+
+	if !cacheBypassed(ctx) {
+		if res, ok := c.Cache.Get(key); ok {
+			res.Cached = true
+			res.CacheKey = key
+			return res, nil
+		}
+	}
+
+	res, err := call(ctx, params, system, user)
+	if err != nil {
+		return res, err
+	}
+	if keep(res) {
+		if err := c.Cache.Put(key, c.Provider, model, res); err == nil {
+			res.CacheKey = key
+		}
+	}
+	return res, nil
+}
+
+// key hashes everything that influences the completion, including the task and the reasoning
+// settings the provider applies for it.
+func (c *CachingInferencer) key(ctx context.Context, op, model string, params *openai.ChatCompletionNewParams, system, user string) (string, error) {
+	var p openai.ChatCompletionNewParams
+	if params != nil {
+		p = *params
+	}
+	p.Messages = nil
+	p.Model = ""
+	bin, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	task := ScopeFrom(ctx).Task
+	reasoning, err := json.Marshal(ReasoningOf(c.Inferencer, task))
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, part := range []string{op, c.Provider, model, string(bin), task, string(reasoning), system, user} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Unwrap returns the wrapped Inferencer.
+func (c *CachingInferencer) Unwrap() Inferencer {
+	return c.Inferencer
+}
+
+// jsonPayload strips reasoning blocks and surrounding prose or code fences from a completion.
+func jsonPayload(s string) string {
+	if i := strings.LastIndex(s, "</think>"); i != -1 {
+		s = s[i+len("</think>"):]
+	}
+	start := strings.IndexAny(s, "{[")
+	end := strings.LastIndexAny(s, "}]")
+	if start == -1 || end < start {
+		return ""
+	}
+	return s[start : end+1]
+}
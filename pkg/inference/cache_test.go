@@ -0,0 +1,148 @@
+package inference
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// reasoningStub applies a reasoning policy like the providers do.
+type reasoningStub struct {
+	*stubInferencer
+	policy ReasoningPolicy
+}
+
+func (r reasoningStub) ReasoningFor(task string) Reasoning {
+	return r.policy.For(task)
+}
+
+const cachedJSON = `{"ok":true}`
+
+func TestCacheServesRepeatedCalls(t *testing.T) {
+	stub := newStub(stubReply{res: Result{Content: cachedJSON}})
+	inf := WithCache(stub, "test", NewDiskCache(t.TempDir(), time.Hour, 0))
+	ctx := context.Background()
+	if res, err := inf.Infer(ctx, nil, "system", "user"); err != nil || res.Cached {
+		t.Fatalf("first call = %+v, %v", res, err)
+	}
+	res, err := inf.Infer(ctx, nil, "system", "user")
+	if err != nil || !res.Cached || res.Content != cachedJSON || res.CacheKey == "" {
+		t.Fatalf("second call = %+v, %v; want a cache hit", res, err)
+	}
+	if n := stub.numCalls(); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+
+	// A bypassed call goes through and refreshes the entry.
+	if res, err := inf.Infer(WithCacheBypass(ctx), nil, "system", "user"); err != nil || res.Cached {
+		t.Errorf("bypassed call = %+v, %v", res, err)
+	}
+	if n := stub.numCalls(); n != 2 {
+		t.Errorf("calls = %d, want the bypass to reach the provider", n)
+	}
+
+	inf.Cache.Forget(res.CacheKey)
+	if res, _ := inf.Infer(ctx, nil, "system", "user"); res.Cached {
+		t.Error("a forgotten entry was served")
+	}
+}
+
+func TestCacheSkipsInvalidJSON(t *testing.T) {
+	stub := newStub(stubReply{res: Result{Content: "not json"}})
+	inf := WithCache(stub, "test", NewDiskCache(t.TempDir(), time.Hour, 0))
+	for range 2 {
+		if _, err := inf.Infer(context.Background(), nil, "", "user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := stub.numCalls(); n != 2 {
+		t.Errorf("calls = %d, want invalid output never cached", n)
+	}
+}
+
+func TestCacheKeySeparatesTaskAndReasoning(t *testing.T) {
+	stub := newStub(stubReply{res: Result{Content: cachedJSON}})
+	inner := reasoningStub{stub, ReasoningPolicy{Tasks: map[string]Reasoning{TaskSummarize: {Effort: "high"}}}}
+	inf := WithCache(inner, "test", NewDiskCache(t.TempDir(), time.Hour, 0))
+	ctx := context.Background()
+	names := WithScope(ctx, Scope{Task: TaskNames})
+	summarize := WithScope(ctx, Scope{Task: TaskSummarize})
+
+	for _, ctx := range []context.Context{names, summarize, names, summarize} {
+		if _, err := inf.Infer(ctx, nil, "system", "user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := stub.numCalls(); n != 2 {
+		t.Errorf("calls = %d, want one per task", n)
+	}
+
+	// The same task under another reasoning effort is a different completion.
+	inner.policy.Tasks[TaskSummarize] = Reasoning{Effort: "low"}
+	if res, _ := inf.Infer(summarize, nil, "system", "user"); res.Cached {
+		t.Error("a result for another reasoning effort was served")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cache := NewDiskCache(t.TempDir(), time.Hour, 0)
+	if err := cache.Put("abcd", "test", "model", Result{Content: cachedJSON}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("abcd"); !ok {
+		t.Fatal("fresh entry missing")
+	}
+	cache.TTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, ok := cache.Get("abcd"); ok {
+		t.Error("expired entry was served")
+	}
+	if _, err := os.Stat(cache.path("abcd")); !os.IsNotExist(err) {
+		t.Errorf("expired entry left on disk: %v", err)
+	}
+
+	cache.TTL = 0
+	if err := cache.Put("abcd", "test", "model", Result{Content: cachedJSON}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, ok := cache.Get("abcd"); !ok {
+		t.Error("entry expired without a TTL")
+	}
+}
+
+func TestCacheEvictsOldest(t *testing.T) {
+	dir := t.TempDir()
+	probe := NewDiskCache(filepath.Join(dir, "probe"), time.Hour, 0)
+	if err := probe.Put("0000", "test", "model", Result{Content: cachedJSON}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(probe.path("0000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Room for two entries, with slack for timestamps that encode a few bytes longer; the third
+	// Put evicts the oldest.
+	cache := NewDiskCache(filepath.Join(dir, "cache"), time.Hour, 2*info.Size()+info.Size()/2)
+	old := time.Now().Add(-time.Hour)
+	for i, key := range []string{"aaaa", "bbbb", "cccc"} {
+		if err := cache.Put(key, "test", "model", Result{Content: cachedJSON}); err != nil {
+			t.Fatal(err)
+		}
+		stamp := old.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(cache.path(key), stamp, stamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := cache.Get("aaaa"); ok {
+		t.Error("oldest entry was not evicted")
+	}
+	for _, key := range []string{"bbbb", "cccc"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package inference
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+	"google.golang.org/genai"
+)
+
+// Provider-neutral failure kinds. Every inferencer maps its errors onto these so
+// handlers can use errors.Is regardless of which provider is configured.
+var (
+	ErrContentRefused  = errors.New("content refused")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrContextTooLong  = errors.New("context too long")
+	ErrAuth            = errors.New("authentication failed")
+	ErrEmptyCompletion = errors.New("empty completion")
+	ErrTruncated       = errors.New("output truncated")
+)
+
+// Error is a classified inference failure.
+type Error struct {
+	// Kind is one of the sentinel errors above, or nil for unclassified failures such as 5xx responses.
+	Kind       error
+	Provider   string
+	StatusCode int
+	// RetryAfter is the delay the provider asked for before retrying, if any.
+	RetryAfter time.Duration
+	// Raw is the provider's raw error payload or reason, kept for diagnostics.
+	Raw string
+	// Err is the underlying provider error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Provider)
+	if e.Kind != nil {
+		sb.WriteString(": ")
+		sb.WriteString(e.Kind.Error())
+	}
+	if e.Err != nil {
+		sb.WriteString(": ")
+		sb.WriteString(e.Err.Error())
+	}
+	return sb.String()
+}
+
+func (e *Error) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// RetryAfter returns the delay requested by a rate-limited provider.
+func RetryAfter(err error) (time.Duration, bool) {
+	var e *Error
+	if errors.As(err, &e) && errors.Is(e.Kind, ErrRateLimited) {
+		return e.RetryAfter, true
+	}
+	return 0, false
+}
+
+// RawError returns the provider payload attached to a classified error, or err's message.
+func RawError(err error) string {
+	var e *Error
+	if errors.As(err, &e) && e.Raw != "" {
+		return e.Raw
+	}
+	return err.Error()
+}
+
+func statusKind(code int) error {
+	switch code {
+	case http.StatusUnauthorized:
+		return ErrAuth
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+func isContextOverflow(msg string) bool {
+	return containsAny(strings.ToLower(msg),
+		"context_length_exceeded", "maximum context length", "context length", "context window",
+		"prompt is too long", "too many tokens", "input token count", "exceeds the maximum number of tokens")
+}
+
+func isContentFilter(msg string) bool {
+	return containsAny(strings.ToLower(msg), "content_filter", "content_policy", "content policy", "safety system")
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyOpenAI maps errors from OpenAI-compatible backends.
+// A 403 is treated as a refusal since that is how these backends report moderated content.
+func classifyOpenAI(provider string, err error) error {
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) {
+		return &Error{Provider: provider, Err: err}
+	}
+	e := &Error{Provider: provider, StatusCode: apiErr.StatusCode, Raw: apiErr.RawJSON(), Err: err, Kind: statusKind(apiErr.StatusCode)}
+	if apiErr.Response != nil {
+		e.RetryAfter = retryAfter(apiErr.Response.Header)
+	}
+	msg := apiErr.Code + " " + apiErr.Message + " " + apiErr.RawJSON()
+	switch {
+	case apiErr.StatusCode == http.StatusForbidden:
+		e.Kind = ErrContentRefused
+	case apiErr.StatusCode == http.StatusBadRequest && isContextOverflow(msg):
+		e.Kind = ErrContextTooLong
+	case apiErr.StatusCode == http.StatusBadRequest && isContentFilter(msg):
+		e.Kind = ErrContentRefused
+	}
+	return e
+}
+
+// classifyGemini maps errors from the Gemini API, including blocked prompts and candidates.
+func classifyGemini(err error) error {
+	var finishErr *GeminiFinishError
+	if errors.As(err, &finishErr) {
+		var kind error
+		switch {
+		case finishErr.Blocked():
+			kind = ErrContentRefused
+		case finishErr.FinishReason == genai.FinishReasonMaxTokens:
+			kind = ErrTruncated
+		}
+		return &Error{Kind: kind, Provider: "gemini", Raw: finishErr.Error(), Err: err}
+	}
+	var apiErr genai.APIError
+	if !errors.As(err, &apiErr) {
+		return &Error{Provider: "gemini", Err: err}
+	}
+	e := &Error{Provider: "gemini", StatusCode: apiErr.Code, Raw: apiErr.Message, Err: err, Kind: statusKind(apiErr.Code)}
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests:
+		e.RetryAfter = geminiRetryDelay(apiErr.Details)
+	case apiErr.Code == http.StatusForbidden:
+		e.Kind = ErrAuth
+	case apiErr.Code == http.StatusBadRequest && isContextOverflow(apiErr.Message):
+		e.Kind = ErrContextTooLong
+	}
+	return e
+}
+
+// classifyAnthropic maps errors from the Anthropic Messages API.
+func classifyAnthropic(err error) error {
+	var apiErr *AnthropicError
+	if !errors.As(err, &apiErr) {
+		return &Error{Provider: "anthropic", Err: err}
+	}
+	e := &Error{Provider: "anthropic", StatusCode: apiErr.StatusCode, RetryAfter: apiErr.RetryAfter, Raw: apiErr.Message, Err: err, Kind: statusKind(apiErr.StatusCode)}
+	switch {
+	case apiErr.Type == "permission_error" || apiErr.Type == "authentication_error":
+		e.Kind = ErrAuth
+	case apiErr.StatusCode == http.StatusBadRequest && isContextOverflow(apiErr.Message):
+		e.Kind = ErrContextTooLong
+	}
+	return e
+}
+
+// finishError classifies a completed response whose finish reason signals a failure.
+// It returns nil for normal completions.
+func finishError(provider, reason, content string) error {
+	switch reason {
+	case "length", "max_tokens":
+		return &Error{Kind: ErrTruncated, Provider: provider, Raw: "finish_reason=" + reason}
+	case "content_filter", "refusal":
+		return &Error{Kind: ErrContentRefused, Provider: provider, Raw: "finish_reason=" + reason}
+	}
+	if strings.TrimSpace(content) == "" {
+		return &Error{Kind: ErrEmptyCompletion, Provider: provider, Raw: "finish_reason=" + reason}
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package fake
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+
+	"paige/pkg/inference"
+)
+
+// BatchServer is a stand-in for the OpenAI Batch and Files APIs. It answers every request of a
+// batch with its Inferencer and writes the results in reverse order, so clients are exercised
+// against out-of-order results. Point an OpenAI-compatible inferencer's base URL at it.
+//
+// Batch lines carry no scope, so scripted responses should match on prompt text rather than task.
+type BatchServer struct {
+	Inferencer inference.Inferencer
+	// Polls is how many status checks report a batch as in progress before it completes.
+	Polls int
+
+	mu      sync.Mutex
+	files   map[string][]byte
+	batches map[string]*batchState
+	next    int
+}
+
+type batchState struct {
+	batch openai.Batch
+	polls int
+}
+
+// NewBatchServer returns a stand-in answering with inf.
+func NewBatchServer(inf inference.Inferencer) *BatchServer {
+	return &BatchServer{
+		Inferencer: inf,
+		Polls:      1,
+		files:      make(map[string][]byte),
+		batches:    make(map[string]*batchState),
+	}
+}
+
+// ServeHTTP routes /files, /files/{id}/content, /batches and /batches/{id}, with or without a
+// /v1 prefix.
+func (b *BatchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if i := strings.Index(path, "/files"); i >= 0 {
+		path = path[i:]
+	} else if i := strings.Index(path, "/batches"); i >= 0 {
+		path = path[i:]
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && path == "/files":
+		b.upload(w, r)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "files" && parts[2] == "content":
+		b.content(w, parts[1])
+	case r.Method == http.MethodPost && path == "/batches":
+		b.create(w, r)
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[0] == "batches":
+		b.get(w, r.Context(), parts[1])
+	default:
+		writeError(w, http.StatusNotFound, "unknown route "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (b *BatchServer) id(prefix string) string {
+	b.next++
+	return fmt.Sprintf("%s-%d", prefix, b.next)
+}
+
+func (b *BatchServer) upload(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	b.mu.Lock()
+	id := b.id("file")
+	b.files[id] = data
+	b.mu.Unlock()
+	writeJSON(w, map[string]any{
+		"id":         id,
+		"object":     "file",
+		"bytes":      len(data),
+		"created_at": time.Now().Unix(),
+		"filename":   header.Filename,
+		"purpose":    r.FormValue("purpose"),
+		"status":     "processed",
+	})
+}
+
+func (b *BatchServer) content(w http.ResponseWriter, id string) {
+	b.mu.Lock()
+	data, ok := b.files[id]
+	b.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no file "+id)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jsonl")
+	_, _ = w.Write(data)
+}
+
+func (b *BatchServer) create(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		InputFileID      string `json:"input_file_id"`
+		Endpoint         string `json:"endpoint"`
+		CompletionWindow string `json:"completion_window"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	input, ok := b.files[body.InputFileID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "no file "+body.InputFileID)
+		return
+	}
+	state := &batchState{batch: openai.Batch{
+		ID:               b.id("batch"),
+		CompletionWindow: body.CompletionWindow,
+		CreatedAt:        time.Now().Unix(),
+		Endpoint:         body.Endpoint,
+		InputFileID:      body.InputFileID,
+		Status:           openai.BatchStatusValidating,
+		RequestCounts:    openai.BatchRequestCounts{Total: int64(bytes.Count(input, []byte("\n")))},
+	}}
+	b.batches[state.batch.ID] = state
+	writeJSON(w, state.batch)
+}
+
+// get reports a batch as in progress for Polls checks, then runs it and reports it completed.
+func (b *BatchServer) get(w http.ResponseWriter, ctx context.Context, id string) {
+	b.mu.Lock()
+	state, ok := b.batches[id]
+	if !ok {
+		b.mu.Unlock()
+		writeError(w, http.StatusNotFound, "no batch "+id)
+		return
+	}
+	if state.batch.Status != openai.BatchStatusCompleted && state.polls < b.Polls {
+		state.polls++
+		state.batch.Status = openai.BatchStatusInProgress
+		batch := state.batch
+		b.mu.Unlock()
+		writeJSON(w, batch)
+		return
+	}
+	run := state.batch.Status != openai.BatchStatusCompleted
+	input := b.files[state.batch.InputFileID]
+	b.mu.Unlock()
+
+	if run {
+		output, failed, counts := b.run(ctx, input)
+		b.mu.Lock()
+		state.batch.Status = openai.BatchStatusCompleted
+		state.batch.CompletedAt = time.Now().Unix()
+		state.batch.RequestCounts = counts
+		if len(output) > 0 {
+			state.batch.OutputFileID = b.id("file")
+			b.files[state.batch.OutputFileID] = output
+		}
+		if len(failed) > 0 {
+			state.batch.ErrorFileID = b.id("file")
+			b.files[state.batch.ErrorFileID] = failed
+		}
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	batch := state.batch
+	b.mu.Unlock()
+	writeJSON(w, batch)
+}
+
+// run answers every line of input and returns the output and error files, each in reverse order.
+func (b *BatchServer) run(ctx context.Context, input []byte) (output, failed []byte, counts openai.BatchRequestCounts) {
+	var outLines, errLines [][]byte
+	for line := range bytes.Lines(input) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		counts.Total++
+		var req struct {
+			CustomID string `json:"custom_id"`
+			Body     struct {
+				Model    string `json:"model"`
+				Messages []struct {
+					Role    string `json:"role"`
+					Content string `json:"content"`
+				} `json:"messages"`
+			} `json:"body"`
+		}
+		if err := json.Unmarshal(line, &req); err != nil {
+			counts.Failed++
+			errLines = append(errLines, batchLine(req.CustomID, nil, "invalid_request", err.Error()))
+			continue
+		}
+		var system, user string
+		for _, m := range req.Body.Messages {
+			switch m.Role {
+			case "system", "developer":
+				system = m.Content
+			case "user":
+				user = m.Content
+			}
+		}
+
+		res, err := b.Inferencer.Infer(ctx, &openai.ChatCompletionNewParams{Model: req.Body.Model}, system, user)
+		if err != nil && !errors.Is(err, inference.ErrTruncated) {
+			counts.Failed++
+			status, code := http.StatusInternalServerError, kindName(err)
+			switch {
+			case errors.Is(err, inference.ErrContentRefused):
+				status, code = http.StatusBadRequest, "content_filter"
+			case errors.Is(err, inference.ErrRateLimited):
+				status = http.StatusTooManyRequests
+			}
+			body, _ := json.Marshal(map[string]any{"error": map[string]string{"message": inference.RawError(err), "code": code}})
+			errLines = append(errLines, batchLine(req.CustomID, &batchResponse{StatusCode: status, Body: body}, "", ""))
+			continue
+		}
+		finish := "stop"
+		if err != nil {
+			finish = "length"
+		}
+		body, _ := json.Marshal(map[string]any{
+			"id":      "chatcmpl-" + req.CustomID,
+			"object":  "chat.completion",
+			"created": time.Now().Unix(),
+			"model":   cmp.Or(res.Model, req.Body.Model),
+			"choices": []map[string]any{{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": res.Content},
+				"finish_reason": finish,
+			}},
+			"usage": map[string]any{
+				"prompt_tokens":             res.Usage.PromptTokens,
+				"completion_tokens":         res.Usage.CompletionTokens,
+				"total_tokens":              res.Usage.PromptTokens + res.Usage.CompletionTokens,
+				"prompt_tokens_details":     map[string]int64{"cached_tokens": res.Usage.CachedTokens},
+				"completion_tokens_details": map[string]int64{"reasoning_tokens": res.Usage.ReasoningTokens},
+			},
+		})
+		counts.Completed++
+		outLines = append(outLines, batchLine(req.CustomID, &batchResponse{StatusCode: http.StatusOK, Body: body}, "", ""))
+	}
+	slices.Reverse(outLines)
+	slices.Reverse(errLines)
+	return bytes.Join(outLines, nil), bytes.Join(errLines, nil), counts
+}
+
+type batchResponse struct {
+	StatusCode int             `json:"status_code"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func batchLine(customID string, resp *batchResponse, code, message string) []byte {
+	line := map[string]any{"id": "req-" + customID, "custom_id": customID, "response": resp}
+	if code != "" || message != "" {
+		line["error"] = map[string]string{"code": code, "message": message}
+	}
+	bin, _ := json.Marshal(line)
+	return append(bin, '\n')
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": message}})
+}
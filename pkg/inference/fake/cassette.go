@@ -0,0 +1,207 @@
+package fake
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/openai/openai-go/v3"
+
+	"paige/pkg/inference"
+	"paige/pkg/utils"
+)
+
+// Mode selects whether a Recorder calls the wrapped inferencer or replays its cassette.
+type Mode string
+
+const (
+	// ModeRecord calls through and appends every interaction to the cassette.
+	ModeRecord Mode = "record"
+	// ModeReplay answers from the cassette only and fails calls it has not recorded.
+	ModeReplay Mode = "replay"
+	// ModeAuto replays recorded calls and records the rest.
+	ModeAuto Mode = "auto"
+)
+
+// ErrNotRecorded is returned in replay mode for a call missing from the cassette.
+var ErrNotRecorded = errors.New("call not recorded in cassette")
+
+// Interaction is one recorded request and its outcome.
+type Interaction struct {
+	Key    string          `json:"key"`
+	Op     string          `json:"op"`
+	Scope  inference.Scope `json:"scope"`
+	Model  string          `json:"model,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	System string          `json:"system"`
+	User   string          `json:"user"`
+
+	Result inference.Result `json:"result"`
+	// Kind and Error describe a failed call; Kind is a key of Kinds.
+	Kind  string `json:"kind,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Cassette is a file of recorded interactions.
+type Cassette struct {
+	Path         string        `json:"-"`
+	Interactions []Interaction `json:"interactions"`
+
+	mu sync.Mutex
+	// replayed counts how many interactions per key were served, so repeated identical calls
+	// replay their recordings in order.
+	replayed map[string]int
+}
+
+// OpenCassette loads the cassette at path, or returns an empty one if the file does not exist.
+func OpenCassette(path string) (*Cassette, error) {
+	c, err := utils.Load[*Cassette](path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Cassette{Path: path}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cassette %s: %w", path, err)
+	}
+	if c == nil {
+		c = new(Cassette)
+	}
+	c.Path = path
+	return c, nil
+}
+
+// next returns the next unreplayed interaction recorded for key.
+func (c *Cassette) next(key string) (Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.replayed == nil {
+		c.replayed = make(map[string]int)
+	}
+	skip := c.replayed[key]
+	for _, in := range c.Interactions {
+		if in.Key != key {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		c.replayed[key]++
+		return in, true
+	}
+	return Interaction{}, false
+}
+
+// record appends in and saves the cassette.
+func (c *Cassette) record(in Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, in)
+	if c.replayed == nil {
+		c.replayed = make(map[string]int)
+	}
+	// A call recorded in auto mode must not be replayed again by the same run.
+	c.replayed[in.Key]++
+	if c.Path == "" {
+		return nil
+	}
+	return utils.Save(c.Path, c)
+}
+
+// Recorder wraps an Inferencer to record its calls to a cassette or replay them from it.
+// Calls are keyed by operation, model, params and prompts, like the response cache.
+type Recorder struct {
+	inference.Inferencer
+	Cassette *Cassette
+	Mode     Mode
+}
+
+// WithRecorder wraps inf with a recorder on cassette.
+func WithRecorder(inf inference.Inferencer, cassette *Cassette, mode Mode) *Recorder {
+	return &Recorder{Inferencer: inf, Cassette: cassette, Mode: mode}
+}
+
+// Infer replays or records the call.
+func (r *Recorder) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (inference.Result, error) {
+	return r.do(ctx, "infer", params, system, user, r.Inferencer.Infer)
+}
+
+// Edit replays or records the call.
+func (r *Recorder) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (inference.Result, error) {
+	return r.do(ctx, "edit", params, system, user, r.Inferencer.Edit)
+}
+
+// Unwrap returns the wrapped Inferencer.
+func (r *Recorder) Unwrap() inference.Inferencer {
+	return r.Inferencer
+}
+
+func (r *Recorder) do(ctx context.Context, op string, params *openai.ChatCompletionNewParams, system, user string, call func(context.Context, *openai.ChatCompletionNewParams, string, string) (inference.Result, error)) (inference.Result, error) {
+	model := inference.ModelOf(r.Inferencer)
+	var p openai.ChatCompletionNewParams
+	if params != nil {
+		p = *params
+		model = cmp.Or(p.Model, model)
+	}
+	p.Messages = nil
+	p.Model = ""
+	bin, err := json.Marshal(p)
+	if err != nil {
+		return inference.Result{}, err
+	}
+	key := interactionKey(op, model, string(bin), system, user)
+
+	if r.Mode != ModeRecord {
+		if in, ok := r.Cassette.next(key); ok {
+			return in.replay()
+		}
+		if r.Mode == ModeReplay {
+			return inference.Result{}, &inference.Error{Provider: "replay", Raw: op + " " + key, Err: ErrNotRecorded}
+		}
+	}
+
+	res, err := call(ctx, params, system, user)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return res, err
+	}
+	in := Interaction{
+		Key:    key,
+		Op:     op,
+		Scope:  inference.ScopeFrom(ctx),
+		Model:  model,
+		Params: bin,
+		System: system,
+		User:   user,
+		Result: res,
+	}
+	if err != nil {
+		in.Kind, in.Error = kindName(err), inference.RawError(err)
+	}
+	if rerr := r.Cassette.record(in); rerr != nil {
+		return res, errors.Join(err, fmt.Errorf("failed to save cassette: %w", rerr))
+	}
+	return res, err
+}
+
+// replay returns the recorded outcome.
+func (in Interaction) replay() (inference.Result, error) {
+	res := in.Result
+	if in.Kind == "" && in.Error == "" {
+		return res, nil
+	}
+	return res, &inference.Error{Kind: Kinds[in.Kind], Provider: "replay", Raw: in.Error}
+}
+
+func interactionKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
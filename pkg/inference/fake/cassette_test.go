@@ -0,0 +1,125 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"paige/pkg/inference"
+)
+
+func TestCassetteReplaysRepeatedCallsInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	cassette, err := OpenCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inf := New(Response{Content: "one", Times: 1}, Response{Content: "two", Times: 1})
+	rec := WithRecorder(inf, cassette, ModeRecord)
+	ctx := context.Background()
+	for _, want := range []string{"one", "two"} {
+		if res, err := rec.Infer(ctx, nil, "system", "user"); err != nil || res.Content != want {
+			t.Fatalf("record = %q, %v; want %q", res.Content, err, want)
+		}
+	}
+
+	replayed, err := OpenCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed.Interactions) != 2 {
+		t.Fatalf("saved %d interactions, want 2", len(replayed.Interactions))
+	}
+	unscripted := New()
+	rep := WithRecorder(unscripted, replayed, ModeReplay)
+	for _, want := range []string{"one", "two"} {
+		if res, err := rep.Infer(ctx, nil, "system", "user"); err != nil || res.Content != want {
+			t.Errorf("replay = %q, %v; want %q", res.Content, err, want)
+		}
+	}
+	if _, err := rep.Infer(ctx, nil, "system", "user"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("third replay err = %v, want ErrNotRecorded", err)
+	}
+	if _, err := rep.Infer(ctx, nil, "system", "other"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("unrecorded prompt err = %v, want ErrNotRecorded", err)
+	}
+	if n := len(unscripted.Calls()); n != 0 {
+		t.Errorf("replay called the wrapped inferencer %d times", n)
+	}
+}
+
+func TestCassetteReplaysClassifiedErrors(t *testing.T) {
+	cassette := new(Cassette)
+	rec := WithRecorder(New(Response{Kind: "content_refused", Error: "blocked"}), cassette, ModeRecord)
+	ctx := context.Background()
+	if _, err := rec.Infer(ctx, nil, "", "text"); !errors.Is(err, inference.ErrContentRefused) {
+		t.Fatalf("record err = %v", err)
+	}
+	if got := cassette.Interactions[0]; got.Kind != "content_refused" || got.Error != "blocked" {
+		t.Errorf("recorded kind, error = %q, %q", got.Kind, got.Error)
+	}
+
+	rep := WithRecorder(New(), &Cassette{Interactions: cassette.Interactions}, ModeReplay)
+	_, err := rep.Infer(ctx, nil, "", "text")
+	if !errors.Is(err, inference.ErrContentRefused) || inference.RawError(err) != "blocked" {
+		t.Errorf("replay err = %v, want a content refusal with its raw payload", err)
+	}
+}
+
+func TestCassetteAutoRecordsMissingCalls(t *testing.T) {
+	inf := New(Response{Content: "fresh"})
+	cassette := &Cassette{}
+	rec := WithRecorder(inf, cassette, ModeAuto)
+	ctx := context.Background()
+	if _, err := rec.Edit(ctx, nil, "", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// A call recorded by this run is not replayed to it again, so the second call goes through.
+	if _, err := rec.Edit(ctx, nil, "", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(inf.Calls()); n != 2 {
+		t.Errorf("wrapped calls = %d, want 2", n)
+	}
+	if n := len(cassette.Interactions); n != 2 {
+		t.Errorf("interactions = %d, want 2", n)
+	}
+
+	replay := WithRecorder(inf, &Cassette{Interactions: cassette.Interactions}, ModeAuto)
+	if res, err := replay.Edit(ctx, nil, "", "a"); err != nil || res.Content != "fresh" {
+		t.Errorf("auto replay = %q, %v", res.Content, err)
+	}
+	if n := len(inf.Calls()); n != 2 {
+		t.Errorf("auto mode called through for a recorded call")
+	}
+	// Infer and Edit with the same prompts are recorded apart.
+	if _, err := WithRecorder(New(), &Cassette{Interactions: cassette.Interactions}, ModeReplay).Infer(ctx, nil, "", "a"); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("infer replayed an edit recording: %v", err)
+	}
+}
+
+func TestScriptMatching(t *testing.T) {
+	inf := New(
+		Response{Task: inference.TaskNames, Content: "names"},
+		Response{Match: "chapter two", Content: "two"},
+		Response{Kind: "rate_limited", Times: 1},
+	)
+	ctx := context.Background()
+	names := inference.WithScope(ctx, inference.Scope{Task: inference.TaskNames})
+	if res, _ := inf.Infer(names, nil, "", "chapter two"); res.Content != "names" {
+		t.Errorf("task match = %q, want names", res.Content)
+	}
+	if res, _ := inf.Infer(ctx, nil, "", "in chapter two"); res.Content != "two" {
+		t.Errorf("substring match = %q, want two", res.Content)
+	}
+	if _, err := inf.Infer(ctx, nil, "", "x"); !errors.Is(err, inference.ErrRateLimited) {
+		t.Errorf("scripted kind err = %v", err)
+	}
+	if _, err := inf.Infer(ctx, nil, "", "x"); !errors.Is(err, ErrUnscripted) {
+		t.Errorf("exhausted response err = %v, want ErrUnscripted", err)
+	}
+	if n := len(inf.Calls()); n != 4 {
+		t.Errorf("calls = %d, want 4", n)
+	}
+}
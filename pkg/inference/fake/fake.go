@@ -0,0 +1,210 @@
+// Package fake provides inferencers that run without a network: a scriptable Inferencer that
+// answers with canned responses, and a Recorder that captures real calls to cassette files and
+// replays them deterministically.
+package fake
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go/v3"
+
+	"paige/pkg/inference"
+	"paige/pkg/utils"
+)
+
+// ErrUnscripted is returned when no response matches a call and no Default is set.
+var ErrUnscripted = errors.New("no scripted response matches the call")
+
+// Kinds maps the error kind names used in scripts and cassettes to the inference sentinels.
+var Kinds = map[string]error{
+	"content_refused":  inference.ErrContentRefused,
+	"rate_limited":     inference.ErrRateLimited,
+	"context_too_long": inference.ErrContextTooLong,
+	"auth":             inference.ErrAuth,
+	"empty_completion": inference.ErrEmptyCompletion,
+	"truncated":        inference.ErrTruncated,
+}
+
+// kindName returns the script name of a classified error's kind, or "" for unclassified errors.
+func kindName(err error) string {
+	for name, kind := range Kinds {
+		if errors.Is(err, kind) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Response is a canned reply to the calls it matches.
+type Response struct {
+	// Match is a substring of the system or user prompt; empty matches every call.
+	Match string `json:"match,omitempty"`
+	// Task restricts the response to calls scoped to that task.
+	Task string `json:"task,omitempty"`
+	// Op restricts the response to "infer" or "edit" calls.
+	Op string `json:"op,omitempty"`
+
+	Content   string          `json:"content,omitempty"`
+	Reasoning string          `json:"reasoning,omitempty"`
+	Usage     inference.Usage `json:"usage"`
+
+	// Kind and Error script a classified failure, e.g. {"kind": "rate_limited"}. Content is still
+	// returned with it, as providers do for truncated output.
+	Kind  string `json:"kind,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Err is returned as is, for failures a script cannot describe.
+	Err error `json:"-"`
+
+	// Times limits how often the response is used; zero never runs out.
+	Times int `json:"times,omitempty"`
+	used  int
+}
+
+func (r *Response) matches(op string, scope inference.Scope, system, user string) bool {
+	if r.Times > 0 && r.used >= r.Times {
+		return false
+	}
+	if r.Op != "" && r.Op != op {
+		return false
+	}
+	if r.Task != "" && r.Task != scope.Task {
+		return false
+	}
+	return r.Match == "" || strings.Contains(system, r.Match) || strings.Contains(user, r.Match)
+}
+
+func (r *Response) result(model string) (inference.Result, error) {
+	res := inference.Result{Content: r.Content, Reasoning: r.Reasoning, Model: model, Usage: r.Usage}
+	switch {
+	case r.Err != nil:
+		return res, r.Err
+	case r.Kind != "" || r.Error != "":
+		kind, ok := Kinds[r.Kind]
+		if r.Kind != "" && !ok {
+			return res, fmt.Errorf("fake: unknown error kind %q", r.Kind)
+		}
+		return res, &inference.Error{Kind: kind, Provider: "fake", Raw: r.Error}
+	}
+	return res, nil
+}
+
+// Call is a request received by the fake Inferencer.
+type Call struct {
+	Op     string                          `json:"op"`
+	Scope  inference.Scope                 `json:"scope"`
+	System string                          `json:"system"`
+	User   string                          `json:"user"`
+	Params *openai.ChatCompletionNewParams `json:"-"`
+}
+
+// Script is the file form of an Inferencer's responses.
+type Script struct {
+	Model     string      `json:"model,omitempty"`
+	Responses []*Response `json:"responses"`
+	Default   *Response   `json:"default,omitempty"`
+}
+
+// Inferencer answers calls with the first matching scripted response and records every call.
+// It is safe for concurrent use.
+type Inferencer struct {
+	mu        sync.Mutex
+	model     string
+	responses []*Response
+	calls     []Call
+
+	// Default answers calls no response matches; nil fails them with ErrUnscripted.
+	Default *Response
+}
+
+// New returns an Inferencer scripted with responses, tried in order.
+func New(responses ...Response) *Inferencer {
+	f := &Inferencer{model: "fake"}
+	for _, r := range responses {
+		f.Add(r)
+	}
+	return f
+}
+
+// Load reads a Script from a JSON file.
+func Load(path string) (*Inferencer, error) {
+	script, err := utils.Load[Script](path)
+	if err != nil {
+		return nil, err
+	}
+	return &Inferencer{
+		model:     cmp.Or(script.Model, "fake"),
+		responses: script.Responses,
+		Default:   script.Default,
+	}, nil
+}
+
+// Add appends a scripted response.
+func (f *Inferencer) Add(r Response) *Inferencer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, &r)
+	return f
+}
+
+// Model returns the model reported in results.
+func (f *Inferencer) Model() string {
+	return f.model
+}
+
+// SetModel changes the model reported in results.
+func (f *Inferencer) SetModel(model string) {
+	f.model = model
+}
+
+// Calls returns a copy of the calls received so far.
+func (f *Inferencer) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// Infer answers with the first scripted response matching the call.
+func (f *Inferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (inference.Result, error) {
+	return f.answer(ctx, "infer", params, system, user)
+}
+
+// Edit answers with the first scripted response matching the call.
+func (f *Inferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (inference.Result, error) {
+	return f.answer(ctx, "edit", params, system, user)
+}
+
+// Verify validates the result against schema like the real providers.
+func (f *Inferencer) Verify(ctx context.Context, schema any, result string) ([]inference.Violation, error) {
+	return inference.Validate(schema, result)
+}
+
+func (f *Inferencer) answer(ctx context.Context, op string, params *openai.ChatCompletionNewParams, system, user string) (inference.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return inference.Result{}, err
+	}
+	scope := inference.ScopeFrom(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{Op: op, Scope: scope, System: system, User: user, Params: params})
+
+	model := f.model
+	if params != nil {
+		model = cmp.Or(params.Model, model)
+	}
+	for _, r := range f.responses {
+		if r.matches(op, scope, system, user) {
+			r.used++
+			return r.result(model)
+		}
+	}
+	if f.Default != nil {
+		return f.Default.result(model)
+	}
+	return inference.Result{}, &inference.Error{Provider: "fake", Raw: "unscripted " + op, Err: ErrUnscripted}
+}
@@ -3,10 +3,14 @@ package inference
 import (
 	"cmp"
 	"context"
-	"errors"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/openai/openai-go/v3"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/genai"
 )
 
@@ -14,9 +18,38 @@ type GeminiInferencer struct {
 	client *genai.Client
 	apiKey string
 	model  string
+
+	// SafetySettings are sent with every request; nil uses the API defaults.
+	SafetySettings []*genai.SafetySetting
+	// MaxTokens caps the output budget, which the API rejects above the model's limit.
+	MaxTokens int32
+	// Reasoning sets the thinking budget per task; thought summaries are returned as Result.Reasoning.
+	Reasoning ReasoningPolicy
+	// ContextCacheTTL enables explicit context caching: the system prompt and the stable prefix of
+	// the user message are stored as cached content for this long and referenced by later calls.
+	// Zero disables it.
+	ContextCacheTTL time.Duration
+
+	cacheMu sync.Mutex
+	caches  map[string]geminiCache
+	// creating makes concurrent chunks sharing a prefix create its cached content once.
+	creating singleflight.Group
+}
+
+// geminiCache tracks a cached content. A prefix is only cached once it is seen a second time,
+// since most story contexts change with every chunk; an entry without a name records the first
+// sighting, or a prefix the API refused to cache when failed is set.
+type geminiCache struct {
+	name    string
+	failed  bool
+	expires time.Time
 }
 
-// NewGeminiInferencer creates a new inferencer instance using OpenAI client.
+// geminiMinCacheChars approximates the smallest cached content the API accepts (1024 tokens).
+// Shorter prefixes are not worth a request that is bound to fail.
+const geminiMinCacheChars = 4 * 1024
+
+// NewGeminiInferencer creates a new inferencer instance using the Gemini API.
 func NewGeminiInferencer(apiKey string, model string) (*GeminiInferencer, error) {
 	if model == "" {
 		model = "gemini-2.5-flash"
@@ -26,12 +59,57 @@ func NewGeminiInferencer(apiKey string, model string) (*GeminiInferencer, error)
 		return nil, err
 	}
 	return &GeminiInferencer{
-		client: client,
-		apiKey: apiKey,
-		model:  model,
+		client:    client,
+		apiKey:    apiKey,
+		model:     model,
+		MaxTokens: 65536,
 	}, nil
 }
 
+// GeminiSafety returns safety settings applying threshold to every text harm category.
+func GeminiSafety(threshold genai.HarmBlockThreshold) []*genai.SafetySetting {
+	categories := []genai.HarmCategory{
+		genai.HarmCategoryHarassment,
+		genai.HarmCategoryHateSpeech,
+		genai.HarmCategorySexuallyExplicit,
+		genai.HarmCategoryDangerousContent,
+		genai.HarmCategoryCivicIntegrity,
+	}
+	settings := make([]*genai.SafetySetting, 0, len(categories))
+	for _, category := range categories {
+		settings = append(settings, &genai.SafetySetting{Category: category, Threshold: threshold})
+	}
+	return settings
+}
+
+// GeminiFinishError reports a prompt Gemini blocked or a candidate that did not finish normally.
+type GeminiFinishError struct {
+	// BlockReason is set when the prompt itself was blocked.
+	BlockReason  genai.BlockedReason
+	FinishReason genai.FinishReason
+	Message      string
+	// Content holds any partial text returned with the candidate.
+	Content string
+}
+
+func (e *GeminiFinishError) Error() string {
+	if e.BlockReason != "" {
+		return fmt.Sprintf("gemini blocked prompt: %s %s", e.BlockReason, e.Message)
+	}
+	return fmt.Sprintf("gemini finished with %s %s", e.FinishReason, e.Message)
+}
+
+// Blocked reports whether the prompt or candidate was blocked for its content. Other finish
+// reasons, such as OTHER or MALFORMED_FUNCTION_CALL, are not refusals and may succeed when retried.
+func (e *GeminiFinishError) Blocked() bool {
+	switch e.FinishReason {
+	case genai.FinishReasonSafety, genai.FinishReasonProhibitedContent, genai.FinishReasonBlocklist,
+		genai.FinishReasonSPII, genai.FinishReasonRecitation:
+		return true
+	}
+	return e.BlockReason != ""
+}
+
 func (o *GeminiInferencer) ChangeConfig(config *genai.ClientConfig) {
 	client, err := genai.NewClient(context.Background(), config)
 	if err != nil {
@@ -40,46 +118,224 @@ func (o *GeminiInferencer) ChangeConfig(config *genai.ClientConfig) {
 	o.client = client
 }
 
-// Infer sends text to the OpenAI chat completion endpoint and returns the output.
-func (o *GeminiInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (string, error) {
+// Model returns the default model used when params do not set one.
+func (o *GeminiInferencer) Model() string {
+	return o.model
+}
+
+// ReasoningFor returns the reasoning settings applied to task.
+func (o *GeminiInferencer) ReasoningFor(task string) Reasoning {
+	return o.Reasoning.For(task)
+}
+
+// Infer sends text to the Gemini generate content endpoint and returns the output.
+// Responses are always JSON; a json_schema response format constrains them to the schema.
+func (o *GeminiInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	return o.generate(ctx, params, system, user, true)
+}
+
+func (o *GeminiInferencer) generate(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string, jsonOutput bool) (Result, error) {
 	if params == nil {
 		params = new(openai.ChatCompletionNewParams)
 	}
 	config := &genai.GenerateContentConfig{
-		SystemInstruction: genai.NewContentFromText(system, genai.RoleModel),
-		ResponseMIMEType:  "application/json",
-		MaxOutputTokens:   int32(cmp.Or(params.MaxCompletionTokens.Value, 4096)),
+		SystemInstruction: genai.NewContentFromText(system, genai.RoleUser),
+		MaxOutputTokens:   min(int32(cmp.Or(params.MaxCompletionTokens.Value, params.MaxTokens.Value, 4096)), cmp.Or(o.MaxTokens, 65536)),
+		Temperature:       genai.Ptr(float32(cmp.Or(params.Temperature.Value, 0.3))),
+		TopP:              genai.Ptr(float32(cmp.Or(params.TopP.Value, 1.0))),
+		SafetySettings:    o.SafetySettings,
+	}
+	if params.Seed.Valid() {
+		config.Seed = genai.Ptr(int32(params.Seed.Value))
+	}
+	if budget := o.Reasoning.For(ScopeFrom(ctx).Task).Budget; budget != 0 {
+		config.ThinkingConfig = &genai.ThinkingConfig{
+			IncludeThoughts: true,
+			ThinkingBudget:  genai.Ptr(int32(budget)),
+		}
+	}
+	if js := params.ResponseFormat.OfJSONSchema; js != nil {
+		config.ResponseMIMEType = "application/json"
+		config.ResponseJsonSchema = plainSchema(js.JSONSchema.Schema)
+	} else if jsonOutput || params.ResponseFormat.OfJSONObject != nil {
+		config.ResponseMIMEType = "application/json"
+	}
+
+	model := cmp.Or(params.Model, o.model)
+	// Prefer a cache holding the story context too; fall back to one holding the system prompt.
+	var cached string
+	if prefix, rest := splitPrefix(ctx, user); prefix != "" && rest != "" {
+		if cached = o.cachedContent(ctx, model, system, prefix); cached != "" {
+			user = rest
+		}
+	}
+	if cached == "" {
+		cached = o.cachedContent(ctx, model, system, "")
+	}
+	if cached != "" {
+		config.CachedContent, config.SystemInstruction = cached, nil
 	}
 
-	result, err := o.client.Models.GenerateContent(
-		ctx,
-		cmp.Or(params.Model, o.model),
-		genai.Text(user),
-		config,
-	)
+	result, err := o.client.Models.GenerateContent(ctx, model, genai.Text(user), config)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate content: %w", err)
+		if config.CachedContent != "" {
+			o.forgetCache(config.CachedContent)
+		}
+		return Result{}, classifyGemini(err)
 	}
 
-	return result.Text(), nil
+	if fb := result.PromptFeedback; fb != nil && fb.BlockReason != "" {
+		return Result{}, classifyGemini(&GeminiFinishError{BlockReason: fb.BlockReason, Message: fb.BlockReasonMessage})
+	}
+	if len(result.Candidates) == 0 {
+		return Result{}, &Error{Kind: ErrEmptyCompletion, Provider: "gemini", Raw: "no candidates returned"}
+	}
+
+	content, thoughts := SplitThink(result.Text())
+	res := Result{
+		Content:   content,
+		Reasoning: joinReasoning(geminiThoughts(result.Candidates[0]), thoughts),
+		Model:     result.ModelVersion,
+		Usage:     usageFromGemini(result.UsageMetadata),
+	}
+	switch candidate := result.Candidates[0]; candidate.FinishReason {
+	case "", genai.FinishReasonStop, genai.FinishReasonUnspecified:
+	default:
+		return res, classifyGemini(&GeminiFinishError{FinishReason: candidate.FinishReason, Message: candidate.FinishMessage, Content: res.Content})
+	}
+	if res.Content == "" {
+		return res, &Error{Kind: ErrEmptyCompletion, Provider: "gemini", Raw: "empty candidate"}
+	}
+	return res, nil
 }
 
 // Edit mirrors Infer but allows the caller to provide editing-specific defaults.
-func (o *GeminiInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (string, error) {
+// Edits are prose, so the response is not forced into JSON.
+func (o *GeminiInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
 	if params == nil {
 		params = new(openai.ChatCompletionNewParams)
 	}
 	if params.MaxCompletionTokens.Value == 0 {
 		params.MaxCompletionTokens = openai.Int(int64(len(user) * 2))
 	}
-	return o.Infer(ctx, params, system, user)
+	return o.generate(ctx, params, system, user, false)
+}
+
+// Verify validates the result against schema and returns the violations found.
+func (o *GeminiInferencer) Verify(ctx context.Context, schema any, result string) ([]Violation, error) {
+	return Validate(schema, result)
+}
+
+// cachedContent returns the name of a cached content holding system and prefix for model,
+// creating it on the second call with the same content. It returns "" when caching is disabled
+// or the content is not cached yet, in which case the request is sent in full.
+func (o *GeminiInferencer) cachedContent(ctx context.Context, model, system, prefix string) string {
+	if o.ContextCacheTTL <= 0 || len(system)+len(prefix) < geminiMinCacheChars {
+		return ""
+	}
+	h := sha256.New()
+	for _, part := range []string{model, system, prefix} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	key := hex.EncodeToString(h.Sum(nil))
+
+	o.cacheMu.Lock()
+	now := time.Now()
+	c, seen := o.caches[key]
+	switch {
+	case seen && c.name != "" && c.expires.After(now.Add(time.Minute)):
+		o.cacheMu.Unlock()
+		return c.name
+	case seen && c.failed && c.expires.After(now):
+		o.cacheMu.Unlock()
+		return ""
+	}
+	if o.caches == nil {
+		o.caches = make(map[string]geminiCache)
+	}
+	for k, c := range o.caches {
+		if !c.expires.After(now) {
+			delete(o.caches, k)
+		}
+	}
+	if !seen {
+		o.caches[key] = geminiCache{expires: now.Add(o.ContextCacheTTL)}
+		o.cacheMu.Unlock()
+		return ""
+	}
+	o.cacheMu.Unlock()
+
+	// The lock is not held over the network call, so requests with other prefixes go ahead.
+	name, _, _ := o.creating.Do(key, func() (any, error) {
+		// A creation that finished after the lookup above is reused rather than repeated.
+		o.cacheMu.Lock()
+		c := o.caches[key]
+		o.cacheMu.Unlock()
+		if c.name != "" || c.failed {
+			return c.name, nil
+		}
+		config := &genai.CreateCachedContentConfig{
+			TTL:               o.ContextCacheTTL,
+			DisplayName:       "paige",
+			SystemInstruction: genai.NewContentFromText(system, genai.RoleUser),
+		}
+		if prefix != "" {
+			config.Contents = genai.Text(prefix)
+		}
+		entry := geminiCache{expires: time.Now().Add(o.ContextCacheTTL)}
+		// Callers waiting on this creation share it, so one of them giving up must not fail it.
+		if cached, err := o.client.Caches.Create(context.WithoutCancel(ctx), model, config); err == nil {
+			entry.name = cached.Name
+			if !cached.ExpireTime.IsZero() {
+				entry.expires = cached.ExpireTime
+			}
+		} else {
+			entry.failed = true
+		}
+		o.cacheMu.Lock()
+		o.caches[key] = entry
+		o.cacheMu.Unlock()
+		return entry.name, nil
+	})
+	return name.(string)
+}
+
+// forgetCache drops a cached content that failed a request, so the next call recreates it.
+func (o *GeminiInferencer) forgetCache(name string) {
+	o.cacheMu.Lock()
+	defer o.cacheMu.Unlock()
+	for k, c := range o.caches {
+		if c.name == name {
+			delete(o.caches, k)
+		}
+	}
 }
 
-// Verify checks that the result is non-empty or conforms to minimal expectations.
-// You could extend this with an OpenAI-based validation or JSON schema.
-func (o *GeminiInferencer) Verify(ctx context.Context, result string) (bool, error) {
-	if result == "" {
-		return false, errors.New("empty result")
+// usageFromGemini converts Gemini usage metadata. Thought tokens are billed as
+// output, so they are folded into CompletionTokens to match OpenAI semantics.
+func usageFromGemini(u *genai.GenerateContentResponseUsageMetadata) Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{
+		PromptTokens:     int64(u.PromptTokenCount),
+		CompletionTokens: int64(u.CandidatesTokenCount) + int64(u.ThoughtsTokenCount),
+		CachedTokens:     int64(u.CachedContentTokenCount),
+		ReasoningTokens:  int64(u.ThoughtsTokenCount),
+	}
+}
+
+// geminiThoughts returns the thought summaries of a candidate, which Text omits.
+func geminiThoughts(candidate *genai.Candidate) string {
+	if candidate == nil || candidate.Content == nil {
+		return ""
+	}
+	var thoughts []string
+	for _, part := range candidate.Content.Parts {
+		if part.Thought && part.Text != "" {
+			thoughts = append(thoughts, part.Text)
+		}
 	}
-	return true, nil
+	return joinReasoning(thoughts...)
 }
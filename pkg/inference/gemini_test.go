@@ -0,0 +1,42 @@
+package inference
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestClassifyGeminiFinish(t *testing.T) {
+	tests := []struct {
+		err       *GeminiFinishError
+		kind      error
+		retryable bool
+	}{
+		{&GeminiFinishError{BlockReason: genai.BlockedReasonSafety}, ErrContentRefused, false},
+		{&GeminiFinishError{FinishReason: genai.FinishReasonSafety}, ErrContentRefused, false},
+		{&GeminiFinishError{FinishReason: genai.FinishReasonProhibitedContent}, ErrContentRefused, false},
+		{&GeminiFinishError{FinishReason: genai.FinishReasonBlocklist}, ErrContentRefused, false},
+		{&GeminiFinishError{FinishReason: genai.FinishReasonSPII}, ErrContentRefused, false},
+		{&GeminiFinishError{FinishReason: genai.FinishReasonRecitation}, ErrContentRefused, false},
+		{&GeminiFinishError{FinishReason: genai.FinishReasonMaxTokens}, ErrTruncated, false},
+		{&GeminiFinishError{FinishReason: genai.FinishReasonOther}, nil, true},
+		{&GeminiFinishError{FinishReason: genai.FinishReasonMalformedFunctionCall}, nil, true},
+		{&GeminiFinishError{FinishReason: genai.FinishReasonLanguage}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			err := classifyGemini(tt.err)
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("err = %v, want *Error", err)
+			}
+			if tt.kind == nil && e.Kind != nil || tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Errorf("kind = %v, want %v", e.Kind, tt.kind)
+			}
+			if _, ok := retryable(err); ok != tt.retryable {
+				t.Errorf("retryable = %v, want %v", ok, tt.retryable)
+			}
+		})
+	}
+}
@@ -2,13 +2,105 @@ package inference
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/openai/openai-go/v3"
 )
 
 // Inferencer defines an interface for running model inference and verification.
 type Inferencer interface {
-	Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (string, error)
-	Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (string, error)
-	Verify(ctx context.Context, result string) (bool, error)
+	Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error)
+	Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error)
+	// Verify validates result against a reflected JSON Schema and returns the violations found.
+	Verify(ctx context.Context, schema any, result string) ([]Violation, error)
+}
+
+// Unwrapper is implemented by decorators that wrap another Inferencer.
+type Unwrapper interface {
+	Unwrap() Inferencer
+}
+
+// ModelOf returns the default model of inf, looking through decorators.
+func ModelOf(inf Inferencer) string {
+	for inf != nil {
+		if m, ok := inf.(interface{ Model() string }); ok {
+			return m.Model()
+		}
+		u, ok := inf.(Unwrapper)
+		if !ok {
+			return ""
+		}
+		inf = u.Unwrap()
+	}
+	return ""
+}
+
+// Result is the output of a single inference call.
+type Result struct {
+	Content string `json:"content"`
+	// Reasoning is the model's thinking trace, kept apart from Content.
+	Reasoning string `json:"reasoning,omitempty"`
+	// Model is the model that served the request as reported by the provider.
+	Model string `json:"model,omitempty"`
+	Usage Usage  `json:"usage"`
+	// Cached is set when the result was served from a response cache.
+	Cached bool `json:"cached,omitempty"`
+	// CacheKey identifies the cache entry so callers can Forget a result they reject.
+	CacheKey string `json:"-"`
+}
+
+// Usage is the token accounting reported by a provider for a single call.
+// CompletionTokens includes ReasoningTokens; CachedTokens (read from a prompt cache) and
+// CacheWriteTokens (written to one) are subsets of PromptTokens.
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	CachedTokens     int64 `json:"cached_tokens"`
+	CacheWriteTokens int64 `json:"cache_write_tokens"`
+	ReasoningTokens  int64 `json:"reasoning_tokens"`
+}
+
+// Add accumulates o into u.
+func (u *Usage) Add(o Usage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.CachedTokens += o.CachedTokens
+	u.CacheWriteTokens += o.CacheWriteTokens
+	u.ReasoningTokens += o.ReasoningTokens
+}
+
+// usageFromOpenAI converts the usage block of a chat completion.
+func usageFromOpenAI(u openai.CompletionUsage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		CachedTokens:     u.PromptTokensDetails.CachedTokens,
+		ReasoningTokens:  u.CompletionTokensDetails.ReasoningTokens,
+	}
+}
+
+// plainSchema converts a reflected JSON Schema into a plain map without the
+// meta keys ($schema, $id) that tool and response schemas do not accept.
+func plainSchema(schema any) any {
+	bin, err := json.Marshal(schema)
+	if err != nil {
+		return schema
+	}
+	var m map[string]any
+	if err := json.Unmarshal(bin, &m); err != nil {
+		return schema
+	}
+	delete(m, "$schema")
+	delete(m, "$id")
+	return m
+}
+
+// schemaInstruction describes a response schema in the system prompt for backends that cannot
+// enforce it; the output is then validated like any other.
+func schemaInstruction(schema any) string {
+	bin, err := json.Marshal(plainSchema(schema))
+	if err != nil {
+		return ""
+	}
+	return "\n\nRespond with a single JSON object that conforms to this JSON Schema:\n" + string(bin)
 }
@@ -0,0 +1,67 @@
+package inference
+
+import (
+	"context"
+	"sync"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// stubReply is one scripted answer of a stubInferencer.
+type stubReply struct {
+	res Result
+	err error
+}
+
+// stubInferencer answers calls with its replies in order, repeating the last one, and records
+// the contexts and prompts it was called with.
+type stubInferencer struct {
+	mu      sync.Mutex
+	replies []stubReply
+	calls   []stubCall
+	model   string
+}
+
+type stubCall struct {
+	ctx    context.Context
+	params *openai.ChatCompletionNewParams
+	system string
+	user   string
+}
+
+func newStub(replies ...stubReply) *stubInferencer {
+	return &stubInferencer{replies: replies, model: "stub-model"}
+}
+
+func (s *stubInferencer) answer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, stubCall{ctx, params, system, user})
+	if len(s.replies) == 0 {
+		return Result{Content: user}, nil
+	}
+	r := s.replies[min(len(s.calls), len(s.replies))-1]
+	return r.res, r.err
+}
+
+func (s *stubInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	return s.answer(ctx, params, system, user)
+}
+
+func (s *stubInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	return s.answer(ctx, params, system, user)
+}
+
+func (s *stubInferencer) Verify(ctx context.Context, schema any, result string) ([]Violation, error) {
+	return Validate(schema, result)
+}
+
+func (s *stubInferencer) Model() string {
+	return s.model
+}
+
+func (s *stubInferencer) numCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.calls)
+}
@@ -0,0 +1,216 @@
+package inference
+
+import (
+	"cmp"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// Price is the cost in USD per million tokens for a model.
+// CachedInput and CacheWrite fall back to Input when zero.
+type Price struct {
+	Input       float64 `json:"input"`
+	CachedInput float64 `json:"cached_input,omitempty"`
+	CacheWrite  float64 `json:"cache_write,omitempty"`
+	Output      float64 `json:"output"`
+}
+
+// PriceTable maps model names (or model name prefixes) to prices.
+// The "*" key is used for models without a more specific entry.
+type PriceTable map[string]Price
+
+// Lookup returns the price for model, preferring an exact match, then the longest prefix, then "*".
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	if p, ok := t[model]; ok {
+		return p, true
+	}
+	var best string
+	for prefix := range t {
+		if prefix != "*" && strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best != "" {
+		return t[best], true
+	}
+	p, ok := t["*"]
+	return p, ok
+}
+
+// Cost prices a single call.
+func (p Price) Cost(u Usage) float64 {
+	cachedRate := cmp.Or(p.CachedInput, p.Input)
+	writeRate := cmp.Or(p.CacheWrite, p.Input)
+	uncached := max(u.PromptTokens-u.CachedTokens-u.CacheWriteTokens, 0)
+	return (float64(uncached)*p.Input + float64(u.CachedTokens)*cachedRate + float64(u.CacheWriteTokens)*writeRate + float64(u.CompletionTokens)*p.Output) / 1e6
+}
+
+// Totals accumulates usage and cost for one accounting bucket.
+type Totals struct {
+	Calls int64   `json:"calls"`
+	Usage Usage   `json:"usage"`
+	Cost  float64 `json:"cost"`
+}
+
+func (t *Totals) add(u Usage, cost float64) {
+	t.Calls++
+	t.Usage.Add(u)
+	t.Cost += cost
+}
+
+// LedgerData is the persisted form of a Ledger.
+type LedgerData struct {
+	Stories   map[string]*Totals `json:"stories"`
+	Tasks     map[string]*Totals `json:"tasks"`
+	Days      map[string]*Totals `json:"days"`
+	Providers map[string]*Totals `json:"providers"`
+}
+
+// Ledger accumulates usage per story, task, UTC day and provider/model.
+type Ledger struct {
+	mu   sync.Mutex
+	data LedgerData
+	// records counts the calls recorded since the ledger was created.
+	records int64
+
+	Prices PriceTable
+	// DailyBudget is the maximum spend in USD per UTC day; zero disables it.
+	DailyBudget float64
+}
+
+// NewLedger creates a ledger, resuming from previously saved data when given.
+func NewLedger(data LedgerData, prices PriceTable, dailyBudget float64) *Ledger {
+	ensure := func(m *map[string]*Totals) {
+		if *m == nil {
+			*m = make(map[string]*Totals)
+		}
+	}
+	ensure(&data.Stories)
+	ensure(&data.Tasks)
+	ensure(&data.Days)
+	ensure(&data.Providers)
+	return &Ledger{data: data, Prices: prices, DailyBudget: dailyBudget}
+}
+
+func today() string {
+	return time.Now().UTC().Format(time.DateOnly)
+}
+
+// Record adds a call's usage to every bucket it belongs to and returns its cost.
+func (l *Ledger) Record(scope Scope, provider, model string, u Usage) float64 {
+	price, _ := l.Prices.Lookup(model)
+	cost := price.Cost(u)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket := func(m map[string]*Totals, key string) {
+		if key == "" {
+			return
+		}
+		t, ok := m[key]
+		if !ok {
+			t = new(Totals)
+			m[key] = t
+		}
+		t.add(u, cost)
+	}
+	bucket(l.data.Stories, scope.Story)
+	bucket(l.data.Tasks, scope.Task)
+	bucket(l.data.Days, today())
+	bucket(l.data.Providers, provider+"/"+model)
+	l.records++
+	return cost
+}
+
+// Records returns how many calls were recorded since the ledger was created, so callers can tell
+// whether it changed since they last saved it.
+func (l *Ledger) Records() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.records
+}
+
+// Spent returns the cost recorded for the current UTC day.
+func (l *Ledger) Spent() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t, ok := l.data.Days[today()]; ok {
+		return t.Cost
+	}
+	return 0
+}
+
+// OverBudget reports whether today's spend has reached DailyBudget.
+func (l *Ledger) OverBudget() bool {
+	return l.DailyBudget > 0 && l.Spent() >= l.DailyBudget
+}
+
+// Story returns the totals for a single story.
+func (l *Ledger) Story(id string) Totals {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t, ok := l.data.Stories[id]; ok {
+		return *t
+	}
+	return Totals{}
+}
+
+// Snapshot returns a deep copy of the ledger suitable for serialization.
+func (l *Ledger) Snapshot() LedgerData {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clone := func(m map[string]*Totals) map[string]*Totals {
+		out := make(map[string]*Totals, len(m))
+		for k, v := range m {
+			t := *v
+			out[k] = &t
+		}
+		return out
+	}
+	return LedgerData{
+		Stories:   clone(l.data.Stories),
+		Tasks:     clone(l.data.Tasks),
+		Days:      clone(l.data.Days),
+		Providers: clone(l.data.Providers),
+	}
+}
+
+// AccountingInferencer records the usage of every billed call in a Ledger,
+// including failed calls that still report usage such as truncated outputs.
+type AccountingInferencer struct {
+	Inferencer
+	Provider string
+	Ledger   *Ledger
+}
+
+// WithAccounting wraps inf so that its usage is recorded under provider in ledger.
+func WithAccounting(inf Inferencer, provider string, ledger *Ledger) *AccountingInferencer {
+	return &AccountingInferencer{Inferencer: inf, Provider: provider, Ledger: ledger}
+}
+
+// Infer calls the wrapped Infer and records its usage.
+func (a *AccountingInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	res, err := a.Inferencer.Infer(ctx, params, system, user)
+	if err == nil || res.Usage != (Usage{}) {
+		a.Ledger.Record(ScopeFrom(ctx), a.Provider, res.Model, res.Usage)
+	}
+	return res, err
+}
+
+// Edit calls the wrapped Edit and records its usage.
+func (a *AccountingInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	res, err := a.Inferencer.Edit(ctx, params, system, user)
+	if err == nil || res.Usage != (Usage{}) {
+		a.Ledger.Record(ScopeFrom(ctx), a.Provider, res.Model, res.Usage)
+	}
+	return res, err
+}
+
+// Unwrap returns the wrapped Inferencer.
+func (a *AccountingInferencer) Unwrap() Inferencer {
+	return a.Inferencer
+}
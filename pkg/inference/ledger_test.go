@@ -0,0 +1,114 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPriceCost(t *testing.T) {
+	price := Price{Input: 2, CachedInput: 0.5, CacheWrite: 2.5, Output: 10}
+	u := Usage{PromptTokens: 1_000_000, CachedTokens: 200_000, CacheWriteTokens: 100_000, CompletionTokens: 500_000}
+	// 700k uncached at 2, 200k cached at 0.5, 100k written at 2.5, 500k output at 10.
+	if got, want := price.Cost(u), 1.4+0.1+0.25+5; math.Abs(got-want) > 1e-9 {
+		t.Errorf("cost = %v, want %v", got, want)
+	}
+	if got := (Price{Input: 1}).Cost(Usage{PromptTokens: 1_000_000, CachedTokens: 1_000_000}); got != 1 {
+		t.Errorf("cached rate without a cached price = %v, want the input rate", got)
+	}
+}
+
+func TestPriceTableLookup(t *testing.T) {
+	table := PriceTable{"gpt-4o": {Input: 1}, "gpt-4o-mini": {Input: 2}, "*": {Input: 3}}
+	for model, want := range map[string]float64{"gpt-4o": 1, "gpt-4o-mini-2024": 2, "gpt-4o-2024": 1, "claude": 3} {
+		if p, ok := table.Lookup(model); !ok || p.Input != want {
+			t.Errorf("Lookup(%q) = %v, %v; want input %v", model, p, ok, want)
+		}
+	}
+}
+
+func TestLedgerRecord(t *testing.T) {
+	l := NewLedger(LedgerData{}, PriceTable{"m": {Input: 1, Output: 2}}, 0)
+	u := Usage{PromptTokens: 1_000_000, CompletionTokens: 500_000}
+	l.Record(Scope{Task: TaskSummarize, Story: "ao3:1"}, "openai", "m", u)
+	l.Record(Scope{Task: TaskNames, Story: "ao3:1"}, "openai", "m", u)
+	l.Record(Scope{Task: TaskNames}, "gemini", "m", u)
+
+	data := l.Snapshot()
+	if got := data.Stories["ao3:1"]; got == nil || got.Calls != 2 || got.Cost != 4 || got.Usage.PromptTokens != 2_000_000 {
+		t.Errorf("story totals = %+v", got)
+	}
+	if _, ok := data.Stories[""]; ok {
+		t.Error("a call without a story was recorded under an empty story")
+	}
+	if data.Tasks[TaskNames].Calls != 2 || data.Tasks[TaskSummarize].Calls != 1 {
+		t.Errorf("task totals = %v", data.Tasks)
+	}
+	if got := data.Days[time.Now().UTC().Format(time.DateOnly)]; got == nil || got.Calls != 3 || got.Cost != 6 {
+		t.Errorf("day totals = %+v", got)
+	}
+	if data.Providers["openai/m"].Calls != 2 || data.Providers["gemini/m"].Calls != 1 {
+		t.Errorf("provider totals = %v", data.Providers)
+	}
+	if l.Records() != 3 {
+		t.Errorf("records = %d, want 3", l.Records())
+	}
+
+	// The snapshot is a copy.
+	data.Stories["ao3:1"].Calls = 100
+	if l.Story("ao3:1").Calls != 2 {
+		t.Error("changing a snapshot changed the ledger")
+	}
+
+	// A resumed ledger keeps counting from the saved totals.
+	resumed := NewLedger(l.Snapshot(), l.Prices, 0)
+	resumed.Record(Scope{Story: "ao3:1"}, "openai", "m", u)
+	if got := resumed.Story("ao3:1").Calls; got != 3 {
+		t.Errorf("resumed story calls = %d, want 3", got)
+	}
+}
+
+func TestLedgerBudget(t *testing.T) {
+	l := NewLedger(LedgerData{}, PriceTable{"*": {Input: 1}}, 1.5)
+	if l.OverBudget() {
+		t.Fatal("empty ledger over budget")
+	}
+	l.Record(Scope{}, "p", "m", Usage{PromptTokens: 1_000_000})
+	if l.OverBudget() || l.Spent() != 1 {
+		t.Fatalf("spent %v, over budget %v", l.Spent(), l.OverBudget())
+	}
+	l.Record(Scope{}, "p", "m", Usage{PromptTokens: 500_000})
+	if !l.OverBudget() {
+		t.Errorf("spent %v of 1.5, want over budget", l.Spent())
+	}
+
+	// Spend from other days does not count against today.
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+	old := NewLedger(LedgerData{Days: map[string]*Totals{yesterday: {Cost: 10}}}, nil, 1)
+	if old.OverBudget() {
+		t.Error("yesterday's spend counted against today's budget")
+	}
+	if (&Ledger{}).OverBudget() {
+		t.Error("a ledger without a budget is over budget")
+	}
+}
+
+func TestAccountingInferencer(t *testing.T) {
+	stub := newStub(
+		stubReply{res: Result{Model: "m", Usage: Usage{PromptTokens: 10}}},
+		stubReply{res: Result{Model: "m", Usage: Usage{PromptTokens: 5, CompletionTokens: 5}}, err: ErrTruncated},
+		stubReply{err: errors.New("network")},
+	)
+	l := NewLedger(LedgerData{}, nil, 0)
+	inf := WithAccounting(stub, "openai", l)
+	ctx := WithScope(context.Background(), Scope{Task: TaskNames, Story: "s"})
+	for range 3 {
+		_, _ = inf.Infer(ctx, nil, "", "x")
+	}
+	got := l.Story("s")
+	if got.Calls != 2 || got.Usage.PromptTokens != 15 || got.Usage.CompletionTokens != 5 {
+		t.Errorf("story totals = %+v, want the successful and the truncated call", got)
+	}
+}
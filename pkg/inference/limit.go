@@ -0,0 +1,237 @@
+package inference
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+
+	"paige/pkg/utils"
+)
+
+// Limits are the per-minute budgets of a provider account. Zero disables a budget.
+type Limits struct {
+	RPM int `json:"rpm"`
+	TPM int `json:"tpm"`
+}
+
+// LimiterStats is a snapshot of a Limiter's sliding window.
+type LimiterStats struct {
+	Provider   string `json:"provider"`
+	Limits     Limits `json:"limits"`
+	Requests   int    `json:"requests"`
+	Tokens     int    `json:"tokens"`
+	Waiting    int    `json:"waiting"`
+	Delayed    int64  `json:"delayed"`
+	WaitedMS   int64  `json:"waited_ms"`
+	LastWaitMS int64  `json:"last_wait_ms"`
+}
+
+type limitEntry struct {
+	at     time.Time
+	tokens int
+}
+
+// Limiter delays calls so that a provider's requests and estimated tokens
+// stay within its per-minute budget over a sliding one-minute window.
+type Limiter struct {
+	provider string
+	limits   Limits
+
+	mu      sync.Mutex
+	window  []*limitEntry
+	waiting int
+	delayed int64
+	waited  time.Duration
+	last    time.Duration
+
+	// now and after are the clock, replaced in tests.
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+// NewLimiter creates a limiter for the named provider.
+func NewLimiter(provider string, limits Limits) *Limiter {
+	return &Limiter{provider: provider, limits: limits, now: time.Now, after: time.After}
+}
+
+// Reservation is a call counted in a Limiter's window.
+type Reservation struct {
+	l     *Limiter
+	entry *limitEntry
+}
+
+// Settle replaces the reserved token estimate with the tokens the call actually used.
+func (r *Reservation) Settle(tokens int) {
+	if r == nil {
+		return
+	}
+	r.l.mu.Lock()
+	defer r.l.mu.Unlock()
+	r.entry.tokens = tokens
+}
+
+// Release drops the reservation from the window, for calls that failed before using any tokens.
+func (r *Reservation) Release() {
+	if r == nil {
+		return
+	}
+	r.l.mu.Lock()
+	defer r.l.mu.Unlock()
+	r.l.window = slices.DeleteFunc(r.l.window, func(e *limitEntry) bool { return e == r.entry })
+}
+
+// Wait blocks until a request of the given token estimate fits in the budget and reserves it.
+// A single request larger than the whole TPM budget is let through once the window is empty.
+func (l *Limiter) Wait(ctx context.Context, tokens int) (*Reservation, error) {
+	start := l.now()
+	counted := false
+	for {
+		l.mu.Lock()
+		now := l.now()
+		l.prune(now)
+		if l.fits(tokens) {
+			entry := &limitEntry{at: now, tokens: tokens}
+			l.window = append(l.window, entry)
+			if counted {
+				l.waiting--
+				l.last = now.Sub(start)
+				l.waited += l.last
+			}
+			l.mu.Unlock()
+			return &Reservation{l: l, entry: entry}, nil
+		}
+		if !counted {
+			counted = true
+			l.waiting++
+			l.delayed++
+		}
+		wait := l.window[0].at.Add(time.Minute).Sub(now)
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.waiting--
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		case <-l.after(max(wait, 10*time.Millisecond)):
+		}
+	}
+}
+
+func (l *Limiter) fits(tokens int) bool {
+	if len(l.window) == 0 {
+		return true
+	}
+	if l.limits.RPM > 0 && len(l.window) >= l.limits.RPM {
+		return false
+	}
+	if l.limits.TPM > 0 && l.tokens()+tokens > l.limits.TPM {
+		return false
+	}
+	return true
+}
+
+func (l *Limiter) prune(now time.Time) {
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(l.window) && !l.window[i].at.After(cutoff) {
+		i++
+	}
+	l.window = l.window[i:]
+}
+
+func (l *Limiter) tokens() int {
+	var n int
+	for _, e := range l.window {
+		n += e.tokens
+	}
+	return n
+}
+
+// Stats returns the current window usage.
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(l.now())
+	return LimiterStats{
+		Provider:   l.provider,
+		Limits:     l.limits,
+		Requests:   len(l.window),
+		Tokens:     l.tokens(),
+		Waiting:    l.waiting,
+		Delayed:    l.delayed,
+		WaitedMS:   l.waited.Milliseconds(),
+		LastWaitMS: l.last.Milliseconds(),
+	}
+}
+
+// LimitedInferencer waits on a Limiter before every call to the wrapped Inferencer.
+type LimitedInferencer struct {
+	Inferencer
+	Limiter *Limiter
+	// Tokenizer estimates prompt tokens against the TPM budget.
+	Tokenizer utils.Tokenizer
+}
+
+// minOutputEstimate is the least output reserved for a call that requests any.
+const minOutputEstimate = 1024
+
+// estimate counts the prompt and the expected output, since providers count both against TPM.
+// The requested output budget is usually far above what a call produces, so the output is
+// expected to be at most half the prompt; Settle corrects the estimate once usage is known.
+func (l *LimitedInferencer) estimate(params *openai.ChatCompletionNewParams, system, user string) int {
+	tokens := l.Tokenizer.Count(system + user)
+	if params != nil {
+		if requested := int(cmp.Or(params.MaxCompletionTokens.Value, params.MaxTokens.Value)); requested > 0 {
+			tokens += min(requested, max(tokens/2, minOutputEstimate))
+		}
+	}
+	return tokens
+}
+
+// settle corrects the reservation from the usage the provider reported, and releases it when
+// the call failed without reporting any.
+func settle(r *Reservation, res Result, err error) {
+	if used := res.Usage.PromptTokens + res.Usage.CompletionTokens; used > 0 {
+		r.Settle(int(used))
+	} else if err != nil {
+		r.Release()
+	}
+}
+
+// WithLimit wraps inf with a limiter for the named provider.
+func WithLimit(inf Inferencer, provider string, limits Limits) *LimitedInferencer {
+	return &LimitedInferencer{Inferencer: inf, Limiter: NewLimiter(provider, limits), Tokenizer: utils.TokenizerForModel(ModelOf(inf))}
+}
+
+// Infer waits for budget, then calls the wrapped Infer.
+func (l *LimitedInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	r, err := l.Limiter.Wait(ctx, l.estimate(params, system, user))
+	if err != nil {
+		return Result{}, err
+	}
+	res, err := l.Inferencer.Infer(ctx, params, system, user)
+	settle(r, res, err)
+	return res, err
+}
+
+// Edit waits for budget, then calls the wrapped Edit.
+func (l *LimitedInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	r, err := l.Limiter.Wait(ctx, l.estimate(params, system, user))
+	if err != nil {
+		return Result{}, err
+	}
+	res, err := l.Inferencer.Edit(ctx, params, system, user)
+	settle(r, res, err)
+	return res, err
+}
+
+// Unwrap returns the wrapped Inferencer.
+func (l *LimitedInferencer) Unwrap() Inferencer {
+	return l.Inferencer
+}
@@ -0,0 +1,173 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+
+	"paige/pkg/utils"
+)
+
+// fakeClock is a clock whose timers fire at once by moving the time forward.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func newFakeLimiter(limits Limits) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := NewLimiter("test", limits)
+	l.now, l.after = clock.Now, clock.After
+	return l, clock
+}
+
+func TestLimiterRPM(t *testing.T) {
+	l, clock := newFakeLimiter(Limits{RPM: 2})
+	ctx := context.Background()
+	start := clock.Now()
+	for range 2 {
+		if _, err := l.Wait(ctx, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if clock.Now() != start {
+		t.Fatalf("requests within the budget waited %v", clock.Now().Sub(start))
+	}
+	if _, err := l.Wait(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	stats := l.Stats()
+	if waited := clock.Now().Sub(start); waited != time.Minute || stats.LastWaitMS != time.Minute.Milliseconds() {
+		t.Errorf("third request waited %v (stats %d ms), want a minute", waited, stats.LastWaitMS)
+	}
+	if stats.Delayed != 1 || stats.Waiting != 0 || stats.Requests != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestLimiterSettleAndRelease(t *testing.T) {
+	l, clock := newFakeLimiter(Limits{TPM: 1000})
+	ctx := context.Background()
+	start := clock.Now()
+
+	r, err := l.Wait(ctx, 800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The call used far less than estimated, which frees the rest of the budget.
+	r.Settle(100)
+	r, err = l.Wait(ctx, 800)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clock.Now() != start {
+		t.Fatal("a request fitting the settled budget waited")
+	}
+	// A failed call gives its whole reservation back.
+	r.Release()
+	if _, err := l.Wait(ctx, 900); err != nil {
+		t.Fatal(err)
+	}
+	if clock.Now() != start {
+		t.Fatal("a request fitting the released budget waited")
+	}
+	if got := l.Stats().Tokens; got != 1000 {
+		t.Errorf("window tokens = %d, want 1000", got)
+	}
+
+	if _, err := l.Wait(ctx, 500); err != nil {
+		t.Fatal(err)
+	}
+	if clock.Now().Sub(start) != time.Minute {
+		t.Errorf("over-budget request waited %v, want a minute", clock.Now().Sub(start))
+	}
+}
+
+func TestLimiterOversizedRequest(t *testing.T) {
+	l, clock := newFakeLimiter(Limits{TPM: 100})
+	start := clock.Now()
+	if _, err := l.Wait(context.Background(), 5000); err != nil {
+		t.Fatal(err)
+	}
+	if clock.Now() != start {
+		t.Error("a request larger than the budget waited on an empty window")
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	l, _ := newFakeLimiter(Limits{RPM: 1})
+	l.after = func(time.Duration) <-chan time.Time { return nil }
+	if _, err := l.Wait(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Wait(ctx, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if stats := l.Stats(); stats.Waiting != 0 || stats.Requests != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestLimitedInferencerEstimate(t *testing.T) {
+	inf := WithLimit(newStub(), "test", Limits{TPM: 1 << 20})
+	inf.Tokenizer = utils.HeuristicTokenizer{CharsPerToken: 1}
+	prompt := strings.Repeat("a", 10000)
+	tests := []struct {
+		name   string
+		params *openai.ChatCompletionNewParams
+		want   int
+	}{
+		{"no params", nil, 10000},
+		{"output at half the prompt", &openai.ChatCompletionNewParams{MaxCompletionTokens: openai.Int(65536)}, 15000},
+		{"output within the request", &openai.ChatCompletionNewParams{MaxTokens: openai.Int(2000)}, 12000},
+	}
+	for _, tt := range tests {
+		if got := inf.estimate(tt.params, "", prompt); got != tt.want {
+			t.Errorf("%s: estimate = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := inf.estimate(&openai.ChatCompletionNewParams{MaxCompletionTokens: openai.Int(65536)}, "", "short"); got != 5+minOutputEstimate {
+		t.Errorf("short prompt estimate = %d, want %d", got, 5+minOutputEstimate)
+	}
+}
+
+func TestLimitedInferencerReleasesFailedCalls(t *testing.T) {
+	stub := newStub(stubReply{err: errors.New("boom")})
+	inf := WithLimit(stub, "test", Limits{TPM: 1 << 20})
+	if _, err := inf.Infer(context.Background(), nil, "", "hello"); err == nil {
+		t.Fatal("want the wrapped error")
+	}
+	if stats := inf.Limiter.Stats(); stats.Requests != 0 || stats.Tokens != 0 {
+		t.Errorf("stats = %+v, want the failed call released", stats)
+	}
+
+	stub.replies = []stubReply{{res: Result{Usage: Usage{PromptTokens: 7, CompletionTokens: 3}}}}
+	if _, err := inf.Infer(context.Background(), nil, "", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := inf.Limiter.Stats(); stats.Tokens != 10 {
+		t.Errorf("tokens = %d, want the reported usage", stats.Tokens)
+	}
+}
@@ -3,39 +3,51 @@ package inference
 import (
 	"cmp"
 	"context"
-	"errors"
-	"fmt"
 
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 	"github.com/openai/openai-go/v3/packages/param"
+	"github.com/openai/openai-go/v3/shared"
 )
 
-// OpenAIInferencer implements Inferencer using OpenAI's official Go SDK.
+// OpenAIInferencer implements Inferencer for any OpenAI-compatible chat completions API
+// using OpenAI's official Go SDK. Backend differences are described by its Preset.
 type OpenAIInferencer struct {
 	client *openai.Client
 	apiKey string
 	model  string
+	preset Preset
+
+	// Reasoning sets reasoning_effort per task.
+	Reasoning ReasoningPolicy
 }
 
 // NewOpenAIInferencer creates a new inferencer instance using OpenAI client.
 func NewOpenAIInferencer(apiKey string, model string) *OpenAIInferencer {
-	client := openai.NewClient(option.WithAPIKey(apiKey))
-	if model == "" {
-		model = "gpt-5-nano-2025-08-07"
-	}
-	return &OpenAIInferencer{
-		client: &client,
+	return NewPresetInferencer(Presets["openai"], apiKey, model)
+}
+
+// NewPresetInferencer creates an inferencer for an OpenAI-compatible backend.
+func NewPresetInferencer(preset Preset, apiKey string, model string) *OpenAIInferencer {
+	o := &OpenAIInferencer{
 		apiKey: apiKey,
-		model:  model,
+		model:  cmp.Or(model, preset.Model),
+		preset: preset,
 	}
+	o.ChangeBaseURL(preset.BaseURL)
+	return o
 }
 
 func (o *OpenAIInferencer) ChangeBaseURL(baseURL string) {
-	client := openai.NewClient(
+	opts := []option.RequestOption{
 		option.WithAPIKey(o.apiKey),
 		option.WithBaseURL(baseURL),
-	)
+		option.WithMaxRetries(0),
+	}
+	for k, v := range o.preset.Headers {
+		opts = append(opts, option.WithHeader(k, v))
+	}
+	client := openai.NewClient(opts...)
 	o.client = &client
 }
 
@@ -43,15 +55,42 @@ func (o *OpenAIInferencer) SetModel(model string) {
 	o.model = model
 }
 
-// Infer sends text to the OpenAI chat completion endpoint and returns the output.
-func (o *OpenAIInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (string, error) {
-	if params == nil {
-		params = new(openai.ChatCompletionNewParams)
-	} else {
-		params = &(*params)
+// Model returns the default model used when params do not set one.
+func (o *OpenAIInferencer) Model() string {
+	return o.model
+}
+
+// ReasoningFor returns the reasoning settings applied to task.
+func (o *OpenAIInferencer) ReasoningFor(task string) Reasoning {
+	return o.Reasoning.For(task)
+}
+
+// Preset returns the backend description this inferencer was created with.
+func (o *OpenAIInferencer) Preset() Preset {
+	return o.preset
+}
+
+// Infer sends text to the chat completion endpoint and returns the output.
+func (o *OpenAIInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	resp, err := o.client.Chat.Completions.New(ctx, o.request(ctx, params, system, user))
+	if err != nil {
+		return Result{}, classifyOpenAI(o.preset.Name, err)
+	}
+	return o.result(resp)
+}
+
+// request builds the chat completion body for a call, applying the task's reasoning policy and
+// the preset's quirks.
+func (o *OpenAIInferencer) request(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) openai.ChatCompletionNewParams {
+	var p openai.ChatCompletionNewParams
+	if params != nil {
+		p = *params
+	}
+	p.Model = cmp.Or(p.Model, o.model)
+	if js := p.ResponseFormat.OfJSONSchema; js != nil && !o.preset.JSONSchema {
+		system += schemaInstruction(js.JSONSchema.Schema)
 	}
-	params.Model = cmp.Or(params.Model, o.model)
-	params.Messages = []openai.ChatCompletionMessageParamUnion{
+	p.Messages = []openai.ChatCompletionMessageParamUnion{
 		{
 			OfSystem: &openai.ChatCompletionSystemMessageParam{
 				Role: "system",
@@ -69,27 +108,66 @@ func (o *OpenAIInferencer) Infer(ctx context.Context, params *openai.ChatComplet
 		},
 	}
 
-	params.MaxCompletionTokens = openai.Int(cmp.Or(params.MaxCompletionTokens.Value, 4096*4))
-	params.Temperature = openai.Float(cmp.Or(params.Temperature.Value, 0.3))
-	params.TopP = openai.Float(cmp.Or(params.TopP.Value, 1.0))
-
-	resp, err := o.client.Chat.Completions.New(ctx, *params)
-	if err != nil {
-		return "", fmt.Errorf("openai inference error: %w", err)
+	if effort := o.Reasoning.For(ScopeFrom(ctx).Task).Effort; effort != "" && p.ReasoningEffort == "" {
+		p.ReasoningEffort = shared.ReasoningEffort(effort)
 	}
+	// Reasoning models reject sampling parameters, so defaults are only filled in without an effort.
+	if p.ReasoningEffort == "" {
+		p.Temperature = openai.Float(cmp.Or(p.Temperature.Value, 0.3))
+		p.TopP = openai.Float(cmp.Or(p.TopP.Value, 1.0))
+	}
+	if key := promptCacheKey(ScopeFrom(ctx)); o.preset.PromptCacheKey && key != "" && !p.PromptCacheKey.Valid() {
+		p.PromptCacheKey = openai.String(key)
+	}
+	o.applyQuirks(&p)
+	return p
+}
+
+// result converts a chat completion into a Result, classifying refusals and abnormal finishes.
+func (o *OpenAIInferencer) result(resp *openai.ChatCompletion) (Result, error) {
 	if len(resp.Choices) == 0 {
-		return "", errors.New("no choices returned")
+		return Result{}, &Error{Kind: ErrEmptyCompletion, Provider: o.preset.Name, Raw: "no choices returned"}
 	}
-	if resp.Choices[0].Message.Content == "" {
-		return "", errors.New("empty completion content")
+
+	choice := resp.Choices[0]
+	content, thoughts := SplitThink(choice.Message.Content)
+	res := Result{
+		Content:   content,
+		Reasoning: joinReasoning(messageReasoning(choice.Message), thoughts),
+		Model:     resp.Model,
+		Usage:     usageFromOpenAI(resp.Usage),
+	}
+	if choice.Message.Refusal != "" {
+		return res, &Error{Kind: ErrContentRefused, Provider: o.preset.Name, Raw: choice.Message.Refusal}
+	}
+	return res, finishError(o.preset.Name, choice.FinishReason, res.Content)
+}
+
+// applyQuirks adapts params to what the preset's backend accepts.
+func (o *OpenAIInferencer) applyQuirks(p *openai.ChatCompletionNewParams) {
+	maxTokens := cmp.Or(p.MaxCompletionTokens.Value, p.MaxTokens.Value, o.preset.MaxTokens, 4096)
+	if o.preset.LegacyMaxTokens {
+		p.MaxTokens = openai.Int(maxTokens)
+		p.MaxCompletionTokens = param.Opt[int64]{}
+	} else {
+		p.MaxCompletionTokens = openai.Int(maxTokens)
+		p.MaxTokens = param.Opt[int64]{}
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	if p.ResponseFormat.OfJSONSchema != nil && !o.preset.JSONSchema {
+		if o.preset.JSONObject {
+			p.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+				OfJSONObject: &openai.ResponseFormatJSONObjectParam{},
+			}
+		} else {
+			p.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{}
+		}
+	}
 }
 
 // Edit runs the model with editing defaults (lower temperature / max tokens) while
 // ensuring only the story content is sent as the user message.
-func (o *OpenAIInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (string, error) {
+func (o *OpenAIInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
 	if params == nil {
 		params = new(openai.ChatCompletionNewParams)
 	}
@@ -102,11 +180,7 @@ func (o *OpenAIInferencer) Edit(ctx context.Context, params *openai.ChatCompleti
 	return o.Infer(ctx, params, system, user)
 }
 
-// Verify checks that the result is non-empty or conforms to minimal expectations.
-// You could extend this with an OpenAI-based validation or JSON schema.
-func (o *OpenAIInferencer) Verify(ctx context.Context, result string) (bool, error) {
-	if result == "" {
-		return false, errors.New("empty result")
-	}
-	return true, nil
+// Verify validates the result against schema and returns the violations found.
+func (o *OpenAIInferencer) Verify(ctx context.Context, schema any, result string) ([]Violation, error) {
+	return Validate(schema, result)
 }
@@ -0,0 +1,208 @@
+package inference
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+
+	"paige/pkg/schema"
+)
+
+func TestApplyQuirks(t *testing.T) {
+	tests := []struct {
+		preset                string
+		requested             int64
+		maxTokens, completion int64
+		format                string
+	}{
+		{preset: "openai", completion: 4096 * 4, format: "json_schema"},
+		{preset: "openai", requested: 500, completion: 500, format: "json_schema"},
+		{preset: "openrouter", maxTokens: 4096 * 4, format: "json_schema"},
+		{preset: "openrouter", requested: 500, maxTokens: 500, format: "json_schema"},
+		{preset: "kimi", completion: 4096, format: "json_object"},
+		{preset: "lmstudio", requested: 500, maxTokens: 500, format: "json_schema"},
+		{preset: "ollama", maxTokens: 4096, format: "json_schema"},
+		{preset: "unsupported", completion: 4096, format: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			preset, ok := Presets[tt.preset]
+			if !ok {
+				preset = Preset{Name: tt.preset}
+			}
+			o := NewPresetInferencer(preset, "", "model")
+			p := openai.ChatCompletionNewParams{ResponseFormat: schema.ResponseFormatFor[schema.Summary]()}
+			if tt.requested > 0 {
+				p.MaxCompletionTokens = openai.Int(tt.requested)
+			}
+			o.applyQuirks(&p)
+
+			if p.MaxTokens.Value != tt.maxTokens || p.MaxCompletionTokens.Value != tt.completion {
+				t.Errorf("max_tokens = %d, max_completion_tokens = %d, want %d and %d",
+					p.MaxTokens.Value, p.MaxCompletionTokens.Value, tt.maxTokens, tt.completion)
+			}
+			var format string
+			switch {
+			case p.ResponseFormat.OfJSONSchema != nil:
+				format = "json_schema"
+			case p.ResponseFormat.OfJSONObject != nil:
+				format = "json_object"
+			}
+			if format != tt.format {
+				t.Errorf("response format = %q, want %q", format, tt.format)
+			}
+		})
+	}
+}
+
+func TestApplyQuirksLegacyMaxTokens(t *testing.T) {
+	o := NewPresetInferencer(Presets["vllm"], "", "model")
+	p := openai.ChatCompletionNewParams{MaxTokens: openai.Int(300)}
+	o.applyQuirks(&p)
+	if p.MaxTokens.Value != 300 || p.MaxCompletionTokens.Valid() {
+		t.Errorf("max_tokens = %d, max_completion_tokens set = %v, want 300 and unset", p.MaxTokens.Value, p.MaxCompletionTokens.Valid())
+	}
+}
+
+// openAIServer serves reply with status from a chat completions endpoint.
+func openAIServer(t *testing.T, status int, header http.Header, reply string) *OpenAIInferencer {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			t.Errorf("path = %q, want /chat/completions", r.URL.Path)
+		}
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, reply)
+	}))
+	t.Cleanup(srv.Close)
+	inf := NewPresetInferencer(Presets["openai"], "key", "gpt-test")
+	inf.ChangeBaseURL(srv.URL)
+	return inf
+}
+
+func TestOpenAIErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		header    http.Header
+		body      string
+		kind      error
+		retryable bool
+		wait      time.Duration
+	}{
+		{
+			name:      "rate limited",
+			status:    http.StatusTooManyRequests,
+			header:    http.Header{"Retry-After-Ms": {"1500"}},
+			body:      `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`,
+			kind:      ErrRateLimited,
+			retryable: true,
+			wait:      1500 * time.Millisecond,
+		},
+		{
+			name:      "server error",
+			status:    http.StatusInternalServerError,
+			body:      `{"error":{"message":"The server had an error","type":"server_error"}}`,
+			retryable: true,
+		},
+		{
+			name:      "unavailable",
+			status:    http.StatusServiceUnavailable,
+			header:    http.Header{"Retry-After": {"3"}},
+			body:      `{"error":{"message":"Overloaded","type":"server_error"}}`,
+			retryable: true,
+			wait:      3 * time.Second,
+		},
+		{
+			name:   "context overflow",
+			status: http.StatusBadRequest,
+			body:   `{"error":{"message":"This model's maximum context length is 8192 tokens.","type":"invalid_request_error","code":"context_length_exceeded"}}`,
+			kind:   ErrContextTooLong,
+		},
+		{
+			name:   "content policy",
+			status: http.StatusBadRequest,
+			body:   `{"error":{"message":"Your request was rejected as a result of our safety system.","type":"invalid_request_error","code":"content_policy_violation"}}`,
+			kind:   ErrContentRefused,
+		},
+		{
+			name:   "moderated",
+			status: http.StatusForbidden,
+			body:   `{"error":{"message":"Input flagged by moderation","code":403}}`,
+			kind:   ErrContentRefused,
+		},
+		{
+			name:   "authentication",
+			status: http.StatusUnauthorized,
+			body:   `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`,
+			kind:   ErrAuth,
+		},
+		{
+			name:   "invalid request",
+			status: http.StatusBadRequest,
+			body:   `{"error":{"message":"Unsupported parameter: temperature","type":"invalid_request_error"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inf := openAIServer(t, tt.status, tt.header, tt.body)
+			_, err := inf.Infer(context.Background(), nil, "", "hi")
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("err = %v, want *Error", err)
+			}
+			if e.Provider != "openai" || e.StatusCode != tt.status || e.Raw == "" {
+				t.Errorf("provider, status, raw = %q, %d, %q", e.Provider, e.StatusCode, e.Raw)
+			}
+			if tt.kind == nil && e.Kind != nil || tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Errorf("kind = %v, want %v", e.Kind, tt.kind)
+			}
+			wait, ok := retryable(err)
+			if ok != tt.retryable || wait != tt.wait {
+				t.Errorf("retryable = %v, %v; want %v, %v", wait, ok, tt.wait, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestOpenAIFinishReason(t *testing.T) {
+	tests := []struct {
+		name, reason, content, refusal string
+		want                           error
+	}{
+		{"stop", "stop", "done", "", nil},
+		{"length", "length", "partial", "", ErrTruncated},
+		{"content filter", "content_filter", "", "", ErrContentRefused},
+		{"refusal message", "stop", "", "I can't help with that.", ErrContentRefused},
+		{"empty", "stop", " ", "", ErrEmptyCompletion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, _ := json.Marshal(map[string]any{
+				"id":      "chatcmpl-1",
+				"object":  "chat.completion",
+				"model":   "gpt-test",
+				"choices": []map[string]any{{"index": 0, "finish_reason": tt.reason, "message": map[string]any{"role": "assistant", "content": tt.content, "refusal": tt.refusal}}},
+			})
+			inf := openAIServer(t, http.StatusOK, nil, string(reply))
+			_, err := inf.Infer(context.Background(), nil, "", "hi")
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if _, ok := retryable(err); ok {
+				t.Errorf("retryable(%v) = true, want false", err)
+			}
+		})
+	}
+}
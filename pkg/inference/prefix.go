@@ -0,0 +1,40 @@
+package inference
+
+import (
+	"context"
+	"strings"
+)
+
+// Requests are laid out as a stable prefix followed by a variable suffix so providers can reuse
+// the prefix across calls: the system prompt and schema first, then the story context that
+// several chunks share, and only then the chunk itself.
+
+type prefixKey struct{}
+
+// WithCachePrefix marks prefix as the stable start of the user message of calls made with ctx.
+// Providers with explicit caching cache it together with the system prompt; others rely on
+// automatic prefix caching, which the layout alone enables.
+func WithCachePrefix(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, prefixKey{}, prefix)
+}
+
+// splitPrefix returns the cache prefix recorded in ctx and the rest of user. The prefix is empty
+// when none was recorded or user does not start with it.
+func splitPrefix(ctx context.Context, user string) (prefix, rest string) {
+	prefix, _ = ctx.Value(prefixKey{}).(string)
+	if prefix == "" || !strings.HasPrefix(user, prefix) {
+		return "", user
+	}
+	return prefix, user[len(prefix):]
+}
+
+// promptCacheKey groups calls that share a prefix, for providers that route on a cache key.
+func promptCacheKey(scope Scope) string {
+	if scope.Task == "" {
+		return ""
+	}
+	if scope.Story == "" {
+		return scope.Task
+	}
+	return scope.Task + ":" + scope.Story
+}
@@ -0,0 +1,112 @@
+package inference
+
+// Preset describes an OpenAI-compatible backend and the quirks of its chat completions API.
+type Preset struct {
+	Name    string
+	BaseURL string
+	// Model is the default model; empty lets the server pick its loaded model.
+	Model string
+	// MaxTokens is the default completion budget when params do not set one.
+	MaxTokens int64
+	// ContextWindow is the model's context size in tokens; zero looks the model up in ContextWindows.
+	ContextWindow int
+	// LegacyMaxTokens sends max_tokens instead of max_completion_tokens.
+	LegacyMaxTokens bool
+	// JSONSchema reports support for response_format json_schema. When false the
+	// schema is downgraded to json_object if JSONObject is set, or dropped otherwise, and
+	// described in the system prompt instead.
+	JSONSchema bool
+	JSONObject bool
+	// PromptCacheKey sends prompt_cache_key so calls of the same task and story are routed to
+	// the same prefix cache.
+	PromptCacheKey bool
+	// Batch reports support for the asynchronous /batches API with JSONL file uploads.
+	Batch bool
+	// Headers are sent with every request.
+	Headers map[string]string
+}
+
+// Presets are the known OpenAI-compatible backends keyed by provider name.
+// Adding a backend only requires adding an entry here.
+var Presets = map[string]Preset{
+	"openai": {
+		Name:           "openai",
+		BaseURL:        "https://api.openai.com/v1",
+		Model:          "gpt-5-nano-2025-08-07",
+		MaxTokens:      4096 * 4,
+		JSONSchema:     true,
+		JSONObject:     true,
+		PromptCacheKey: true,
+		Batch:          true,
+	},
+	"grok": {
+		Name:       "grok",
+		BaseURL:    "https://api.x.ai/v1",
+		Model:      "grok-4-1-fast-non-reasoning",
+		MaxTokens:  4096,
+		JSONSchema: true,
+		JSONObject: true,
+	},
+	"kimi": {
+		Name:       "kimi",
+		BaseURL:    "https://api.kimi.com/coding/v1",
+		Model:      "kimi-for-coding",
+		MaxTokens:  4096,
+		JSONObject: true,
+	},
+	"moonshot": {
+		Name:       "moonshot",
+		BaseURL:    "https://api.moonshot.ai/v1",
+		Model:      "kimi-k2-5",
+		MaxTokens:  4096,
+		JSONObject: true,
+	},
+	"openrouter": {
+		Name:            "openrouter",
+		BaseURL:         "https://openrouter.ai/api/v1",
+		Model:           "openai/gpt-5-nano",
+		MaxTokens:       4096 * 4,
+		LegacyMaxTokens: true,
+		JSONSchema:      true,
+		JSONObject:      true,
+		Headers: map[string]string{
+			"HTTP-Referer": "https://github.com/ellypaws/paige",
+			"X-Title":      "Paige",
+		},
+	},
+	"vllm": {
+		Name:            "vllm",
+		BaseURL:         "http://localhost:8000/v1",
+		MaxTokens:       4096,
+		ContextWindow:   8192,
+		LegacyMaxTokens: true,
+		JSONSchema:      true,
+		JSONObject:      true,
+	},
+	"llamacpp": {
+		Name:            "llamacpp",
+		BaseURL:         "http://localhost:8081/v1",
+		MaxTokens:       4096,
+		ContextWindow:   8192,
+		LegacyMaxTokens: true,
+		JSONSchema:      true,
+		JSONObject:      true,
+	},
+	"ollama": {
+		Name:            "ollama",
+		BaseURL:         "http://localhost:11434/v1",
+		MaxTokens:       4096,
+		ContextWindow:   8192,
+		LegacyMaxTokens: true,
+		JSONSchema:      true,
+		JSONObject:      true,
+	},
+	"lmstudio": {
+		Name:            "lmstudio",
+		BaseURL:         "http://localhost:1234/v1",
+		MaxTokens:       4096 * 4,
+		ContextWindow:   8192,
+		LegacyMaxTokens: true,
+		JSONSchema:      true,
+	},
+}
@@ -0,0 +1,113 @@
+package inference
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// Reasoning controls how much a reasoning model thinks before answering.
+type Reasoning struct {
+	// Effort is sent as reasoning_effort to OpenAI-compatible backends: minimal, low, medium or high.
+	Effort string `json:"effort,omitempty"`
+	// Budget is the thinking token budget for Gemini and Anthropic. Zero keeps the provider
+	// default; -1 lets Gemini size it dynamically.
+	Budget int64 `json:"budget,omitempty"`
+}
+
+// ReasoningPolicy selects the reasoning settings for each task.
+type ReasoningPolicy struct {
+	Default Reasoning
+	// Tasks overrides Default per task name.
+	Tasks map[string]Reasoning
+}
+
+// For returns the reasoning settings for task.
+func (p ReasoningPolicy) For(task string) Reasoning {
+	if r, ok := p.Tasks[task]; ok {
+		return r
+	}
+	return p.Default
+}
+
+// ReasoningOf returns the reasoning settings the innermost inferencer applies to task, looking
+// through decorators.
+func ReasoningOf(inf Inferencer, task string) Reasoning {
+	for inf != nil {
+		if r, ok := inf.(interface{ ReasoningFor(string) Reasoning }); ok {
+			return r.ReasoningFor(task)
+		}
+		u, ok := inf.(Unwrapper)
+		if !ok {
+			break
+		}
+		inf = u.Unwrap()
+	}
+	return Reasoning{}
+}
+
+const (
+	thinkOpen  = "<think>"
+	thinkClose = "</think>"
+)
+
+// SplitThink separates inline <think> blocks from the answer. A closing tag without an opening
+// one marks everything before it as reasoning, and an unterminated block runs to the end.
+// Content without think tags is returned unchanged.
+func SplitThink(content string) (answer, reasoning string) {
+	if !strings.Contains(content, thinkOpen) && !strings.Contains(content, thinkClose) {
+		return content, ""
+	}
+	var out, thoughts []string
+	rest := content
+	for rest != "" {
+		open, end := strings.Index(rest, thinkOpen), strings.Index(rest, thinkClose)
+		switch {
+		case end != -1 && (open == -1 || end < open):
+			thoughts = append(thoughts, rest[:end])
+			rest = rest[end+len(thinkClose):]
+		case open == -1:
+			out = append(out, rest)
+			rest = ""
+		default:
+			out = append(out, rest[:open])
+			rest = rest[open+len(thinkOpen):]
+			if end = strings.Index(rest, thinkClose); end == -1 {
+				thoughts = append(thoughts, rest)
+				rest = ""
+				continue
+			}
+			thoughts = append(thoughts, rest[:end])
+			rest = rest[end+len(thinkClose):]
+		}
+	}
+	return strings.TrimSpace(strings.Join(out, "")), joinReasoning(thoughts...)
+}
+
+// joinReasoning joins the non-empty reasoning traces of a response.
+func joinReasoning(parts ...string) string {
+	var kept []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, "\n\n")
+}
+
+// messageReasoning returns the reasoning trace that OpenAI-compatible backends such as
+// DeepSeek, vLLM and OpenRouter return beside the message content.
+func messageReasoning(m openai.ChatCompletionMessage) string {
+	for _, key := range []string{"reasoning_content", "reasoning"} {
+		field, ok := m.JSON.ExtraFields[key]
+		if !ok || !field.Valid() {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal([]byte(field.Raw()), &s); err == nil && s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package inference
+
+import "testing"
+
+func TestSplitThink(t *testing.T) {
+	tests := []struct {
+		name, content     string
+		answer, reasoning string
+	}{
+		{"no tags", "  {\"a\":1}  ", "  {\"a\":1}  ", ""},
+		{"leading block", "<think>plan it</think>\n{\"a\":1}", `{"a":1}`, "plan it"},
+		{"several blocks", "<think>one</think>A<think> two </think>B", "AB", "one\n\ntwo"},
+		{"missing open tag", "reasoning first</think>\nanswer", "answer", "reasoning first"},
+		{"unterminated block", "answer <think>still thinking", "answer", "still thinking"},
+		{"empty block", "<think></think>answer", "answer", ""},
+		{"only reasoning", "<think>all thought</think>", "", "all thought"},
+		{"close before open", "pre</think>mid<think>post</think>end", "midend", "pre\n\npost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, reasoning := SplitThink(tt.content)
+			if answer != tt.answer || reasoning != tt.reasoning {
+				t.Errorf("SplitThink(%q) = %q, %q; want %q, %q", tt.content, answer, reasoning, tt.answer, tt.reasoning)
+			}
+		})
+	}
+}
+
+func TestReasoningPolicy(t *testing.T) {
+	p := ReasoningPolicy{
+		Default: Reasoning{Effort: "low"},
+		Tasks:   map[string]Reasoning{TaskSummarize: {Effort: "high", Budget: 2048}, TaskNames: {}},
+	}
+	tests := []struct {
+		task string
+		want Reasoning
+	}{
+		{TaskSummarize, Reasoning{Effort: "high", Budget: 2048}},
+		{TaskNames, Reasoning{}},
+		{TaskConsolidate, Reasoning{Effort: "low"}},
+		{"", Reasoning{Effort: "low"}},
+	}
+	for _, tt := range tests {
+		if got := p.For(tt.task); got != tt.want {
+			t.Errorf("For(%q) = %+v, want %+v", tt.task, got, tt.want)
+		}
+	}
+}
+
+func TestJoinReasoning(t *testing.T) {
+	if got := joinReasoning(" a ", "", "\n", "b"); got != "a\n\nb" {
+		t.Errorf("joinReasoning = %q, want the non-empty parts", got)
+	}
+}
@@ -0,0 +1,209 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// RetryPolicy controls how transient inference failures (429 and 5xx) are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of calls made, including the first one.
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt; it doubles on every retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the computed backoff. A provider's Retry-After is honoured even when longer.
+	MaxDelay time.Duration
+	// TaskAttempts overrides MaxAttempts per Scope.Task.
+	TaskAttempts map[string]int
+}
+
+// DefaultRetryPolicy returns the policy used when nothing is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+	}
+}
+
+func (p RetryPolicy) attempts(task string) int {
+	if n, ok := p.TaskAttempts[task]; ok {
+		return max(n, 1)
+	}
+	return max(p.MaxAttempts, 1)
+}
+
+// backoff returns the jittered exponential delay before the given retry (1-based).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.BaseDelay << min(retry-1, 16)
+	if p.MaxDelay > 0 && (d > p.MaxDelay || d <= 0) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// RetryEvent describes a retry that is about to be made after a failed call.
+type RetryEvent struct {
+	Task        string `json:"task,omitempty"`
+	Chunk       int    `json:"chunk,omitempty"`
+	Attempt     int    `json:"attempt"`
+	MaxAttempts int    `json:"max_attempts"`
+	DelayMS     int64  `json:"delay_ms"`
+	Error       string `json:"error"`
+}
+
+type retryNotifyKey struct{}
+
+// WithRetryNotify registers fn to be called before every retry made with ctx.
+func WithRetryNotify(ctx context.Context, fn func(RetryEvent)) context.Context {
+	return context.WithValue(ctx, retryNotifyKey{}, fn)
+}
+
+// RetryInferencer wraps an Inferencer and retries transient failures according to Policy.
+type RetryInferencer struct {
+	Inferencer
+	Policy RetryPolicy
+
+	// after is the clock the backoff waits on, replaced in tests.
+	after func(time.Duration) <-chan time.Time
+}
+
+// WithRetry wraps inf with the given retry policy.
+func WithRetry(inf Inferencer, policy RetryPolicy) *RetryInferencer {
+	return &RetryInferencer{Inferencer: inf, Policy: policy, after: time.After}
+}
+
+// Infer calls the wrapped Infer, retrying rate limits and server errors.
+func (r *RetryInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	return r.do(ctx, func() (Result, error) {
+		return r.Inferencer.Infer(ctx, params, system, user)
+	})
+}
+
+// Edit calls the wrapped Edit, retrying rate limits and server errors.
+func (r *RetryInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	return r.do(ctx, func() (Result, error) {
+		return r.Inferencer.Edit(ctx, params, system, user)
+	})
+}
+
+func (r *RetryInferencer) do(ctx context.Context, call func() (Result, error)) (Result, error) {
+	scope := ScopeFrom(ctx)
+	attempts := r.Policy.attempts(scope.Task)
+	notify, _ := ctx.Value(retryNotifyKey{}).(func(RetryEvent))
+
+	for attempt := 1; ; attempt++ {
+		out, err := call()
+		if err == nil {
+			return out, nil
+		}
+		after, ok := retryable(err)
+		if !ok || attempt >= attempts {
+			return out, err
+		}
+
+		delay := max(r.Policy.backoff(attempt), after)
+		if notify != nil {
+			notify(RetryEvent{
+				Task:        scope.Task,
+				Chunk:       scope.Chunk,
+				Attempt:     attempt + 1,
+				MaxAttempts: attempts,
+				DelayMS:     delay.Milliseconds(),
+				Error:       err.Error(),
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		case <-r.after(delay):
+		}
+	}
+}
+
+// retryable reports whether err is worth retrying and how long the provider asked to wait.
+// Rate limits, unclassified 5xx/408 responses and unclassified finishes are retried; every other
+// classified kind is final.
+func retryable(err error) (time.Duration, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		if errors.Is(e.Kind, ErrRateLimited) {
+			return e.RetryAfter, true
+		}
+		// An unclassified finish, such as Gemini's OTHER or MALFORMED_FUNCTION_CALL, is transient.
+		var finishErr *GeminiFinishError
+		if e.Kind == nil && errors.As(e.Err, &finishErr) {
+			return 0, true
+		}
+		if e.Kind != nil || e.StatusCode == 0 {
+			var netErr net.Error
+			return 0, errors.As(e.Err, &netErr) && netErr.Timeout()
+		}
+		// 529 is Anthropic's "overloaded" status and is covered by the 5xx range.
+		return e.RetryAfter, retryableStatus(e.StatusCode)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return 0, true
+	}
+	return 0, false
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= http.StatusInternalServerError
+}
+
+// retryAfter parses the Retry-After family of headers into a delay.
+func retryAfter(h http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(h.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// geminiRetryDelay extracts google.rpc.RetryInfo.retryDelay from an API error's details.
+func geminiRetryDelay(details []map[string]any) time.Duration {
+	for _, detail := range details {
+		if detail["@type"] != "type.googleapis.com/google.rpc.RetryInfo" {
+			continue
+		}
+		if s, ok := detail["retryDelay"].(string); ok {
+			if d, err := time.ParseDuration(s); err == nil {
+				return d
+			}
+		}
+	}
+	return 0
+}
+
+// Unwrap returns the wrapped Inferencer.
+func (r *RetryInferencer) Unwrap() Inferencer {
+	return r.Inferencer
+}
@@ -0,0 +1,159 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// recordedSleeps replaces r's clock with one that fires at once and records every delay.
+func recordedSleeps(r *RetryInferencer) *[]time.Duration {
+	var delays []time.Duration
+	r.after = func(d time.Duration) <-chan time.Time {
+		delays = append(delays, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Time{}
+		return ch
+	}
+	return &delays
+}
+
+func TestRetry(t *testing.T) {
+	limited := &Error{Kind: ErrRateLimited, Provider: "stub", StatusCode: http.StatusTooManyRequests, RetryAfter: 30 * time.Second}
+	unavailable := &Error{Provider: "stub", StatusCode: http.StatusServiceUnavailable}
+	refused := &Error{Kind: ErrContentRefused, Provider: "stub", StatusCode: http.StatusBadRequest}
+	badRequest := &Error{Provider: "stub", StatusCode: http.StatusBadRequest}
+	boom := errors.New("boom")
+	ok := stubReply{res: Result{Content: "ok"}}
+
+	tests := []struct {
+		name    string
+		replies []stubReply
+		calls   int
+		err     error
+	}{
+		{"success", []stubReply{ok}, 1, nil},
+		{"server error then success", []stubReply{{err: unavailable}, {err: unavailable}, ok}, 3, nil},
+		{"attempt cap", []stubReply{{err: unavailable}}, 3, unavailable},
+		{"refusal is final", []stubReply{{err: refused}, ok}, 1, ErrContentRefused},
+		{"unclassified 400 is final", []stubReply{{err: badRequest}, ok}, 1, badRequest},
+		{"plain error is final", []stubReply{{err: boom}, ok}, 1, boom},
+		{"rate limit then success", []stubReply{{err: limited}, ok}, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStub(tt.replies...)
+			r := WithRetry(stub, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second})
+			recordedSleeps(r)
+
+			res, err := r.Infer(context.Background(), nil, "", "")
+			if got := stub.numCalls(); got != tt.calls {
+				t.Errorf("calls = %d, want %d", got, tt.calls)
+			}
+			switch {
+			case tt.err == nil && (err != nil || res.Content != "ok"):
+				t.Errorf("Infer = %q, %v; want ok", res.Content, err)
+			case tt.err != nil && !errors.Is(err, tt.err):
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	stub := newStub(stubReply{err: &Error{Provider: "stub", StatusCode: http.StatusInternalServerError}})
+	r := WithRetry(stub, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 3 * time.Second})
+	delays := recordedSleeps(r)
+
+	if _, err := r.Infer(context.Background(), nil, "", ""); err == nil {
+		t.Fatal("err = nil, want the last server error")
+	}
+	// Each delay is jittered within [d/2, d] of the doubled base, capped at MaxDelay.
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	if len(*delays) != len(want) {
+		t.Fatalf("delays = %v, want %d", *delays, len(want))
+	}
+	for i, d := range *delays {
+		if d < want[i]/2 || d > want[i] {
+			t.Errorf("delay %d = %v, want within [%v, %v]", i+1, d, want[i]/2, want[i])
+		}
+	}
+}
+
+func TestRetryAfterOverridesBackoff(t *testing.T) {
+	stub := newStub(
+		stubReply{err: &Error{Kind: ErrRateLimited, Provider: "stub", StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}},
+		stubReply{res: Result{Content: "ok"}},
+	)
+	r := WithRetry(stub, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 2 * time.Second})
+	delays := recordedSleeps(r)
+	var events []RetryEvent
+	ctx := WithRetryNotify(WithScope(context.Background(), Scope{Task: TaskSummarize, Chunk: 2}), func(e RetryEvent) {
+		events = append(events, e)
+	})
+
+	if _, err := r.Infer(ctx, nil, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(*delays) != 1 || (*delays)[0] != time.Minute {
+		t.Errorf("delays = %v, want Retry-After above MaxDelay honoured", *delays)
+	}
+	if len(events) != 1 || events[0].Attempt != 2 || events[0].MaxAttempts != 3 || events[0].DelayMS != 60000 || events[0].Task != TaskSummarize || events[0].Chunk != 2 {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestRetryTaskAttempts(t *testing.T) {
+	stub := newStub(stubReply{err: &Error{Provider: "stub", StatusCode: http.StatusBadGateway}})
+	r := WithRetry(stub, RetryPolicy{MaxAttempts: 4, TaskAttempts: map[string]int{TaskNames: 1}})
+	recordedSleeps(r)
+
+	if _, err := r.Infer(WithScope(context.Background(), Scope{Task: TaskNames}), nil, "", ""); err == nil {
+		t.Fatal("err = nil, want the server error")
+	}
+	if got := stub.numCalls(); got != 1 {
+		t.Errorf("calls = %d, want 1 for a task without retries", got)
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	stub := newStub(stubReply{err: &Error{Provider: "stub", StatusCode: http.StatusServiceUnavailable}})
+	r := WithRetry(stub, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	r.after = func(time.Duration) <-chan time.Time {
+		cancel()
+		return nil
+	}
+
+	if _, err := r.Infer(ctx, nil, "", ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if got := stub.numCalls(); got != 1 {
+		t.Errorf("calls = %d, want 1", got)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		want   time.Duration
+	}{
+		{http.Header{}, 0},
+		{http.Header{"Retry-After": {"7"}}, 7 * time.Second},
+		{http.Header{"Retry-After": {"1.5"}}, 1500 * time.Millisecond},
+		{http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"7"}}, 250 * time.Millisecond},
+		{http.Header{"Retry-After": {"Wed, 21 Oct 2015 07:28:00 GMT"}}, 0},
+		{http.Header{"Retry-After": {"soon"}}, 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header); got != tt.want {
+			t.Errorf("retryAfter(%v) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+	future := http.Header{"Retry-After": {time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}}
+	if got := retryAfter(future); got <= 50*time.Second || got > time.Minute {
+		t.Errorf("retryAfter(HTTP date) = %v, want about a minute", got)
+	}
+}
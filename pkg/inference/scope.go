@@ -0,0 +1,39 @@
+package inference
+
+import "context"
+
+// Task names used to scope inference calls made by the server handlers.
+const (
+	TaskSummarize = "summarize"
+	TaskNames     = "names"
+	TaskEdit      = "edit"
+	TaskPortrait  = "portrait"
+	// TaskConsolidate is the pass that dedupes and trims an accumulated summary.
+	TaskConsolidate = "consolidate"
+)
+
+// Tasks lists every task name, for per-task configuration.
+var Tasks = []string{TaskSummarize, TaskNames, TaskEdit, TaskPortrait, TaskConsolidate}
+
+// Scope describes the unit of work an inference call belongs to.
+// Decorators use it to apply per-task policies and attribute results.
+type Scope struct {
+	Task  string
+	Story string
+	Chunk int
+	// Repair is the attempt number of a call asking the model to fix its previous output, or zero.
+	Repair int
+}
+
+type scopeKey struct{}
+
+// WithScope attaches the scope to the context for downstream inferencers.
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// ScopeFrom returns the scope attached to ctx, or the zero Scope.
+func ScopeFrom(ctx context.Context) Scope {
+	scope, _ := ctx.Value(scopeKey{}).(Scope)
+	return scope
+}
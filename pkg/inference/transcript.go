@@ -0,0 +1,277 @@
+package inference
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/v3"
+)
+
+// Transcript is the record of a single inference call.
+type Transcript struct {
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Task     string    `json:"task,omitempty"`
+	Story    string    `json:"story,omitempty"`
+	Chunk    int       `json:"chunk"`
+	Repair   int       `json:"repair,omitempty"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model,omitempty"`
+	// Params are the request parameters without the messages.
+	Params     json.RawMessage `json:"params,omitempty"`
+	System     string          `json:"system"`
+	User       string          `json:"user"`
+	Response   string          `json:"response"`
+	Reasoning  string          `json:"reasoning,omitempty"`
+	Usage      Usage           `json:"usage"`
+	Cached     bool            `json:"cached,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMS int64           `json:"duration_ms"`
+}
+
+// Redaction modes for transcripts.
+const (
+	// RedactNone keeps prompts and responses verbatim.
+	RedactNone = "none"
+	// RedactExplicit masks explicit terms in the prompts, responses and errors.
+	RedactExplicit = "explicit"
+	// RedactAll replaces the prompts, responses and errors with their length in bytes.
+	RedactAll = "all"
+)
+
+// explicitTerms matches the sexual vocabulary masked by RedactExplicit.
+var explicitTerms = regexp.MustCompile(`(?i)\b(?:sex(?:ual(?:ly)?)?|penis(?:es)?|cocks?|dicks?|pussy|pussies|vagina(?:l|s)?|clit(?:oris)?|anus|anal|ass(?:hole)?s?|butt(?:hole)?s?|balls|testicles?|scrotum|foreskin|knot(?:ted|ting|s)?|cum(?:s|ming|med)?|semen|sperm|orgasm(?:s|ed|ing)?|erect(?:ion|ions)?|nipples?|breasts?|tits?|boobs?|naked|nude|nudity|masturbat\w*|blowjobs?|handjobs?|fuck\w*|horny|arous\w*|thrust(?:s|ed|ing)?|moan(?:s|ed|ing)?|lick(?:s|ed|ing)?|suck(?:s|ed|ing)?|stroke(?:s|d)?|stroking|genital\w*|pubic|rape\w*|cub)\b`)
+
+// Redact masks text according to mode.
+func Redact(mode, text string) string {
+	switch mode {
+	case RedactExplicit:
+		return explicitTerms.ReplaceAllString(text, "[redacted]")
+	case RedactAll:
+		if text == "" {
+			return ""
+		}
+		return fmt.Sprintf("[redacted %d bytes]", len(text))
+	}
+	return text
+}
+
+// TranscriptLog appends transcripts as JSON lines to a file in Dir and rotates it by size.
+type TranscriptLog struct {
+	Dir string
+	// MaxBytes rotates the current file once it grows past this size; zero never rotates.
+	MaxBytes int64
+	// MaxFiles bounds how many rotated files are kept; zero keeps them all.
+	MaxFiles int
+	// Redact is one of the Redact* modes applied to prompts, responses, reasoning and errors.
+	// System prompts carry per-story rules and instructions, and provider errors can echo the
+	// prompt, so neither is kept verbatim.
+	Redact string
+
+	mu sync.Mutex
+}
+
+const transcriptFile = "transcripts.jsonl"
+
+// NewTranscriptLog returns a log writing to dir.
+func NewTranscriptLog(dir string, maxBytes int64, maxFiles int, redact string) *TranscriptLog {
+	return &TranscriptLog{Dir: dir, MaxBytes: maxBytes, MaxFiles: maxFiles, Redact: redact}
+}
+
+// Append redacts t and writes it to the current file.
+func (l *TranscriptLog) Append(t Transcript) error {
+	t.System = Redact(l.Redact, t.System)
+	t.User = Redact(l.Redact, t.User)
+	t.Response = Redact(l.Redact, t.Response)
+	t.Reasoning = Redact(l.Redact, t.Reasoning)
+	t.Error = Redact(l.Redact, t.Error)
+	bin, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(l.Dir, 0o755); err != nil {
+		return err
+	}
+	if err := l.rotate(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(l.Dir, transcriptFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(bin, '\n'))
+	return err
+}
+
+// rotate renames the current file once it exceeds MaxBytes and prunes the oldest rotated files.
+func (l *TranscriptLog) rotate() error {
+	current := filepath.Join(l.Dir, transcriptFile)
+	info, err := os.Stat(current)
+	if err != nil || l.MaxBytes <= 0 || info.Size() < l.MaxBytes {
+		return nil
+	}
+	rotated := filepath.Join(l.Dir, fmt.Sprintf("transcripts-%s.jsonl", time.Now().UTC().Format("20060102T150405.000000000")))
+	if err := os.Rename(current, rotated); err != nil {
+		return err
+	}
+	if l.MaxFiles <= 0 {
+		return nil
+	}
+	files := l.rotated()
+	for len(files) > l.MaxFiles {
+		_ = os.Remove(files[0])
+		files = files[1:]
+	}
+	return nil
+}
+
+// rotated lists the rotated files from oldest to newest.
+func (l *TranscriptLog) rotated() []string {
+	files, _ := filepath.Glob(filepath.Join(l.Dir, "transcripts-*.jsonl"))
+	slices.Sort(files)
+	return files
+}
+
+// TranscriptFilter selects transcripts to read; empty fields match everything.
+type TranscriptFilter struct {
+	Story string
+	Task  string
+	// Limit keeps only the newest entries; zero keeps all.
+	Limit int
+}
+
+// Read returns the transcripts matching filter from oldest to newest, across rotated files.
+func (l *TranscriptLog) Read(filter TranscriptFilter) ([]Transcript, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var out []Transcript
+	for _, path := range append(l.rotated(), filepath.Join(l.Dir, transcriptFile)) {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 0, 64*1024), 64<<20)
+		for sc.Scan() {
+			var t Transcript
+			if json.Unmarshal(sc.Bytes(), &t) != nil {
+				continue
+			}
+			if filter.Story != "" && t.Story != filter.Story {
+				continue
+			}
+			if filter.Task != "" && t.Task != filter.Task {
+				continue
+			}
+			out = append(out, t)
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if filter.Limit > 0 && len(out) > filter.Limit {
+		out = out[len(out)-filter.Limit:]
+	}
+	return out, nil
+}
+
+// TranscriptInferencer writes a Transcript of every call to a TranscriptLog.
+type TranscriptInferencer struct {
+	Inferencer
+	Provider string
+	Log      *TranscriptLog
+	// OnError is called when a transcript cannot be written; nil ignores the failure.
+	OnError func(error)
+}
+
+// WithTranscripts wraps inf so that its calls are logged under provider.
+func WithTranscripts(inf Inferencer, provider string, log *TranscriptLog) *TranscriptInferencer {
+	return &TranscriptInferencer{Inferencer: inf, Provider: provider, Log: log}
+}
+
+// Infer calls the wrapped Infer and logs the exchange.
+func (t *TranscriptInferencer) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	return t.do(ctx, "infer", params, system, user, t.Inferencer.Infer)
+}
+
+// Edit calls the wrapped Edit and logs the exchange.
+func (t *TranscriptInferencer) Edit(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (Result, error) {
+	return t.do(ctx, "edit", params, system, user, t.Inferencer.Edit)
+}
+
+func (t *TranscriptInferencer) do(ctx context.Context, op string, params *openai.ChatCompletionNewParams, system, user string, call inferFunc) (Result, error) {
+	start := time.Now()
+	res, err := call(ctx, params, system, user)
+
+	scope := ScopeFrom(ctx)
+	entry := Transcript{
+		Time:       start.UTC(),
+		Op:         op,
+		Task:       scope.Task,
+		Story:      scope.Story,
+		Chunk:      scope.Chunk,
+		Repair:     scope.Repair,
+		Provider:   t.Provider,
+		Model:      res.Model,
+		System:     system,
+		User:       user,
+		Response:   res.Content,
+		Reasoning:  res.Reasoning,
+		Usage:      res.Usage,
+		Cached:     res.Cached,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if params != nil {
+		p := *params
+		p.Messages = nil
+		if bin, merr := json.Marshal(p); merr == nil {
+			entry.Params = bin
+		}
+		if entry.Model == "" {
+			entry.Model = params.Model
+		}
+	}
+	if entry.Model == "" {
+		entry.Model = ModelOf(t.Inferencer)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if werr := t.Log.Append(entry); werr != nil && t.OnError != nil {
+		t.OnError(werr)
+	}
+	return res, err
+}
+
+// Unwrap returns the wrapped Inferencer.
+func (t *TranscriptInferencer) Unwrap() Inferencer {
+	return t.Inferencer
+}
+
+// ParseRedact normalizes a redaction mode name, defaulting to RedactNone.
+func ParseRedact(mode string) string {
+	switch mode = strings.ToLower(strings.TrimSpace(mode)); mode {
+	case RedactExplicit, RedactAll:
+		return mode
+	}
+	return RedactNone
+}
@@ -0,0 +1,161 @@
+package inference
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		mode, text, want string
+	}{
+		{RedactNone, "Her naked shoulders.", "Her naked shoulders."},
+		{RedactExplicit, "Her Naked shoulders.", "Her [redacted] shoulders."},
+		{RedactExplicit, "Sussex and classic passages.", "Sussex and classic passages."},
+		{RedactAll, "Ünïcode", "[redacted 9 bytes]"},
+		{RedactAll, "", ""},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.mode, tt.text); got != tt.want {
+			t.Errorf("Redact(%q, %q) = %q, want %q", tt.mode, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTranscriptRedactsEveryText(t *testing.T) {
+	l := NewTranscriptLog(t.TempDir(), 0, 0, RedactAll)
+	entry := Transcript{System: "rules for story", User: "story", Response: "answer", Reasoning: "thoughts", Error: "400: echoed story"}
+	if err := l.Append(entry); err != nil {
+		t.Fatal(err)
+	}
+	got, err := l.Read(TranscriptFilter{})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("Read = %v, %v", got, err)
+	}
+	for name, text := range map[string]string{"system": got[0].System, "user": got[0].User, "response": got[0].Response, "reasoning": got[0].Reasoning, "error": got[0].Error} {
+		if text == "" || text[0] != '[' {
+			t.Errorf("%s = %q, want redacted", name, text)
+		}
+	}
+}
+
+func TestTranscriptRotation(t *testing.T) {
+	dir := t.TempDir()
+	l := NewTranscriptLog(dir, 1, 2, RedactNone)
+	for i := range 5 {
+		if err := l.Append(Transcript{Task: TaskSummarize, Chunk: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every append past the first rotates; only the two newest rotated files survive pruning.
+	if files := l.rotated(); len(files) != 2 {
+		t.Fatalf("rotated files = %v, want 2", files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, transcriptFile)); err != nil {
+		t.Fatalf("current file: %v", err)
+	}
+	got, err := l.Read(TranscriptFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []int
+	for _, tr := range got {
+		chunks = append(chunks, tr.Chunk)
+	}
+	if len(chunks) != 3 || chunks[0] != 2 || chunks[1] != 3 || chunks[2] != 4 {
+		t.Errorf("chunks = %v, want [2 3 4] oldest first", chunks)
+	}
+}
+
+func TestTranscriptRead(t *testing.T) {
+	dir := t.TempDir()
+	l := NewTranscriptLog(dir, 0, 0, RedactNone)
+	for i, e := range []Transcript{
+		{Story: "a", Task: TaskNames},
+		{Story: "a", Task: TaskSummarize},
+		{Story: "b", Task: TaskSummarize},
+		{Story: "a", Task: TaskSummarize},
+	} {
+		e.Chunk = i
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A corrupt line, e.g. from a crash mid-write, is skipped.
+	f, err := os.OpenFile(filepath.Join(dir, transcriptFile), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("{\"story\":\"a\"\n")
+	f.Close()
+
+	tests := []struct {
+		filter TranscriptFilter
+		want   []int
+	}{
+		{TranscriptFilter{}, []int{0, 1, 2, 3}},
+		{TranscriptFilter{Story: "a"}, []int{0, 1, 3}},
+		{TranscriptFilter{Task: TaskSummarize}, []int{1, 2, 3}},
+		{TranscriptFilter{Story: "a", Task: TaskSummarize}, []int{1, 3}},
+		{TranscriptFilter{Story: "a", Limit: 2}, []int{1, 3}},
+		{TranscriptFilter{Story: "c"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := l.Read(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var chunks []int
+		for _, tr := range got {
+			chunks = append(chunks, tr.Chunk)
+		}
+		if len(chunks) != len(tt.want) {
+			t.Errorf("Read(%+v) = %v, want %v", tt.filter, chunks, tt.want)
+			continue
+		}
+		for i := range chunks {
+			if chunks[i] != tt.want[i] {
+				t.Errorf("Read(%+v) = %v, want %v", tt.filter, chunks, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestTranscriptInferencer(t *testing.T) {
+	l := NewTranscriptLog(t.TempDir(), 0, 0, RedactNone)
+	stub := newStub(stubReply{res: Result{Content: "ok", Model: "m1"}}, stubReply{err: errors.New("upstream failed")})
+	inf := WithTranscripts(stub, "stub", l)
+
+	ctx := WithScope(context.Background(), Scope{Task: TaskSummarize, Story: "s", Chunk: 3})
+	if _, err := inf.Infer(ctx, nil, "system", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inf.Edit(ctx, nil, "system", "user"); err == nil {
+		t.Fatal("err = nil, want the upstream error")
+	}
+
+	got, err := l.Read(TranscriptFilter{Story: "s"})
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Read = %v, %v", got, err)
+	}
+	if tr := got[0]; tr.Op != "infer" || tr.Task != TaskSummarize || tr.Chunk != 3 || tr.Provider != "stub" || tr.Model != "m1" || tr.System != "system" || tr.User != "user" || tr.Response != "ok" {
+		t.Errorf("infer transcript = %+v", tr)
+	}
+	if tr := got[1]; tr.Op != "edit" || tr.Error != "upstream failed" || tr.Model != "stub-model" {
+		t.Errorf("edit transcript = %+v", tr)
+	}
+}
+
+func TestParseRedact(t *testing.T) {
+	for in, want := range map[string]string{"": RedactNone, " ALL ": RedactAll, "explicit": RedactExplicit, "bogus": RedactNone} {
+		if got := ParseRedact(in); got != want {
+			t.Errorf("ParseRedact(%s) = %q, want %q", strconv.Quote(in), got, want)
+		}
+	}
+}
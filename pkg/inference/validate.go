@@ -0,0 +1,228 @@
+package inference
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Violation is a single place where a result does not conform to the expected schema.
+type Violation struct {
+	// Path locates the offending value, e.g. $.characters[2].kind.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return v.Path + ": " + v.Message
+}
+
+// ValidationError reports a result that still violates its schema after repair.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Violations) == 0 {
+		return "output failed validation"
+	}
+	msg := fmt.Sprintf("output failed validation: %s", e.Violations[0])
+	if n := len(e.Violations) - 1; n > 0 {
+		msg += fmt.Sprintf(" (and %d more)", n)
+	}
+	return msg
+}
+
+// maxViolations bounds the list returned by Validate so a badly broken result
+// does not produce a repair prompt larger than the result itself.
+const maxViolations = 50
+
+// Validate checks result against a reflected JSON Schema and returns every violation found.
+// It supports the keywords generated for the schema types: type, properties, required,
+// additionalProperties, items, enum, minimum and maximum. A null value is accepted for
+// properties that are not required, since they decode to the zero value.
+// An error is returned only when the schema itself cannot be read.
+func Validate(schema any, result string) ([]Violation, error) {
+	root, ok := plainSchema(schema).(map[string]any)
+	if !ok {
+		return nil, errors.New("schema is not a JSON object")
+	}
+	if strings.TrimSpace(result) == "" {
+		return []Violation{{Path: "$", Message: "empty result"}}, nil
+	}
+	var value any
+	if err := json.Unmarshal([]byte(result), &value); err != nil {
+		return []Violation{{Path: "$", Message: "invalid JSON: " + err.Error()}}, nil
+	}
+
+	var out []Violation
+	validateValue(root, value, "$", &out)
+	if len(out) > maxViolations {
+		out = out[:maxViolations]
+	}
+	return out, nil
+}
+
+func validateValue(schema map[string]any, value any, path string, out *[]Violation) {
+	if len(*out) > maxViolations {
+		return
+	}
+	add := func(format string, args ...any) {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		add("expected %s, got %s", typeNames(t), jsonType(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return equalJSON(e, value) }) {
+		add("must be one of %s, got %s", enumNames(enum), compact(value))
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if lo, ok := schema["minimum"].(float64); ok && v < lo {
+			add("must be at least %v, got %v", lo, v)
+		}
+		if hi, ok := schema["maximum"].(float64); ok && v > hi {
+			add("must be at most %v, got %v", hi, v)
+		}
+	case []any:
+		items, _ := schema["items"].(map[string]any)
+		if items == nil {
+			return
+		}
+		for i, item := range v {
+			validateValue(items, item, fmt.Sprintf("%s[%d]", path, i), out)
+		}
+	case map[string]any:
+		validateObject(schema, v, path, out)
+	}
+}
+
+func validateObject(schema map[string]any, obj map[string]any, path string, out *[]Violation) {
+	props, _ := schema["properties"].(map[string]any)
+
+	required := make(map[string]bool)
+	if list, ok := schema["required"].([]any); ok {
+		for _, name := range list {
+			if name, ok := name.(string); ok {
+				required[name] = true
+				if _, present := obj[name]; !present {
+					*out = append(*out, Violation{Path: path + "." + name, Message: "required property is missing"})
+				}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		child := path + "." + k
+		if sub, ok := props[k].(map[string]any); ok {
+			if obj[k] == nil && !required[k] {
+				continue
+			}
+			validateValue(sub, obj[k], child, out)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				*out = append(*out, Violation{Path: child, Message: "property is not allowed"})
+			}
+		case map[string]any:
+			validateValue(extra, obj[k], child, out)
+		}
+	}
+}
+
+func matchesType(t any, value any) bool {
+	switch t := t.(type) {
+	case string:
+		return matchesTypeName(t, value)
+	case []any:
+		for _, name := range t {
+			if name, ok := name.(string); ok && matchesTypeName(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesTypeName(name string, value any) bool {
+	switch name {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return name == jsonType(value)
+	}
+}
+
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func typeNames(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func enumNames(enum []any) string {
+	names := make([]string, 0, len(enum))
+	for _, e := range enum {
+		names = append(names, compact(e))
+	}
+	return strings.Join(names, ", ")
+}
+
+func compact(value any) string {
+	bin, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	if len(bin) > 80 {
+		return string(bin[:77]) + "..."
+	}
+	return string(bin)
+}
+
+func equalJSON(a, b any) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
@@ -0,0 +1,113 @@
+package inference
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var testSchema = map[string]any{
+	"type":                 "object",
+	"additionalProperties": false,
+	"required":             []any{"characters", "count"},
+	"properties": map[string]any{
+		"count": map[string]any{"type": "integer", "minimum": 0, "maximum": 10},
+		"note":  map[string]any{"type": "string"},
+		"characters": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []any{"name", "kind"},
+				"properties": map[string]any{
+					"name":    map[string]any{"type": "string"},
+					"kind":    map[string]any{"type": "string", "enum": []any{"main", "minor"}},
+					"aliases": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+				},
+			},
+		},
+	},
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		want   []string
+	}{
+		{"valid", `{"count":2,"characters":[{"name":"Ada","kind":"main","aliases":["Countess"]}]}`, nil},
+		{"optional null accepted", `{"count":0,"note":null,"characters":[]}`, nil},
+		{"empty result", "  ", []string{"$: empty result"}},
+		{"invalid JSON", `{"count":`, []string{"$: invalid JSON"}},
+		{"root type", `[]`, []string{"$: expected object, got array"}},
+		{"required missing", `{"characters":[]}`, []string{"$.count: required property is missing"}},
+		{"required null", `{"count":null,"characters":[]}`, []string{"$.count: expected integer, got null"}},
+		{"integer type", `{"count":1.5,"characters":[]}`, []string{"$.count: expected integer, got number"}},
+		{"minimum", `{"count":-1,"characters":[]}`, []string{"$.count: must be at least 0, got -1"}},
+		{"maximum", `{"count":11,"characters":[]}`, []string{"$.count: must be at most 10, got 11"}},
+		{"additional property", `{"count":1,"characters":[],"extra":true}`, []string{"$.extra: property is not allowed"}},
+		{"enum in nested array", `{"count":1,"characters":[{"name":"Ada","kind":"main"},{"name":"Tom","kind":"hero"}]}`,
+			[]string{`$.characters[1].kind: must be one of "main", "minor", got "hero"`}},
+		{"required in nested array", `{"count":1,"characters":[{"kind":"main"}]}`, []string{"$.characters[0].name: required property is missing"}},
+		{"type in doubly nested array", `{"count":1,"characters":[{"name":"Ada","kind":"main","aliases":["x",3]}]}`,
+			[]string{"$.characters[0].aliases[1]: expected string, got number"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := Validate(testSchema, tt.result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(violations))
+			for i, v := range violations {
+				got[i] = v.String()
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("violations = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tt.want[i]) {
+					t.Errorf("violation %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateBoundsViolations(t *testing.T) {
+	items := make([]string, 2*maxViolations)
+	for i := range items {
+		items[i] = fmt.Sprintf(`{"name":%d,"kind":"main"}`, i)
+	}
+	violations, err := Validate(testSchema, `{"count":1,"characters":[`+strings.Join(items, ",")+`]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != maxViolations {
+		t.Errorf("violations = %d, want %d", len(violations), maxViolations)
+	}
+}
+
+func TestValidateBadSchema(t *testing.T) {
+	if _, err := Validate([]string{"not", "a", "schema"}, `{}`); err == nil {
+		t.Error("want an error for a schema that is not an object")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	for _, inf := range []Inferencer{NewAnthropicInferencer("key", ""), NewPresetInferencer(Presets["openai"], "key", "")} {
+		violations, err := inf.Verify(ctx, testSchema, `{"characters":[{"name":"Ada","kind":"villain"}]}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := make([]string, len(violations))
+		for i, v := range violations {
+			paths[i] = v.Path
+		}
+		if !slices.Equal(paths, []string{"$.count", "$.characters[0].kind"}) {
+			t.Errorf("%T violations = %v", inf, violations)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package inference
+
+import "strings"
+
+// DefaultContextWindow is assumed for models missing from ContextWindows.
+const DefaultContextWindow = 32768
+
+// ContextWindows maps model name prefixes to their context size in tokens.
+// The longest matching prefix wins; router prefixes such as "openai/" are ignored.
+var ContextWindows = map[string]int{
+	"gpt-5":         400000,
+	"gpt-4.1":       1047576,
+	"gpt-4o":        128000,
+	"gpt-4":         8192,
+	"gpt-4-32k":     32768,
+	"gpt-4-turbo":   128000,
+	"gpt-oss":       131072,
+	"o1":            200000,
+	"o1-mini":       128000,
+	"o1-preview":    128000,
+	"o3":            200000,
+	"o4":            200000,
+	"gemini":        1048576,
+	"claude":        200000,
+	"grok-4":        256000,
+	"grok-4-fast":   2000000,
+	"grok-4-1-fast": 2000000,
+	"kimi":          262144,
+	"deepseek":      131072,
+	"llama-3":       131072,
+	"qwen3":         32768,
+	"mistral":       32768,
+}
+
+// MaxOutputTokens maps model name prefixes to the largest completion budget the provider
+// accepts, for APIs that reject a max_tokens above it. The longest matching prefix wins.
+var MaxOutputTokens = map[string]int{
+	"claude":            64000,
+	"claude-3":          4096,
+	"claude-3-5":        8192,
+	"claude-3-7":        64000,
+	"claude-opus-4":     32000,
+	"claude-opus-4-5":   64000,
+	"claude-sonnet-4":   64000,
+	"claude-haiku-4":    64000,
+	"claude-3-haiku":    4096,
+	"claude-3-5-haiku":  8192,
+	"claude-3-5-sonnet": 8192,
+}
+
+// ContextWindower is implemented by inferencers that know their model's context size.
+type ContextWindower interface {
+	ContextWindow() int
+}
+
+// ContextWindowFor returns the context size of model from ContextWindows.
+func ContextWindowFor(model string) int {
+	if w, ok := lookupModel(ContextWindows, model); ok {
+		return w
+	}
+	return DefaultContextWindow
+}
+
+// MaxOutputTokensFor returns the completion cap of model from MaxOutputTokens, or 0 when unknown.
+func MaxOutputTokensFor(model string) int {
+	n, _ := lookupModel(MaxOutputTokens, model)
+	return n
+}
+
+// lookupModel returns the value of the longest prefix in table that model starts with.
+func lookupModel(table map[string]int, model string) (int, bool) {
+	model = strings.ToLower(model)
+	if i := strings.LastIndexByte(model, '/'); i != -1 {
+		model = model[i+1:]
+	}
+	var best string
+	for prefix := range table {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return 0, false
+	}
+	return table[best], true
+}
+
+// ContextWindowOf returns the context size of the innermost inferencer's model.
+func ContextWindowOf(inf Inferencer) int {
+	for inf != nil {
+		if w, ok := inf.(ContextWindower); ok {
+			return w.ContextWindow()
+		}
+		u, ok := inf.(Unwrapper)
+		if !ok {
+			break
+		}
+		inf = u.Unwrap()
+	}
+	return ContextWindowFor(ModelOf(inf))
+}
+
+// ContextWindow returns the preset's context size, or the model's when the preset does not set one.
+func (o *OpenAIInferencer) ContextWindow() int {
+	if o.preset.ContextWindow > 0 {
+		return o.preset.ContextWindow
+	}
+	return ContextWindowFor(o.model)
+}
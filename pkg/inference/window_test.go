@@ -0,0 +1,63 @@
+package inference
+
+import "testing"
+
+func TestContextWindowFor(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"gpt-4", 8192},
+		{"gpt-4-0613", 8192},
+		{"gpt-4-32k-0613", 32768},
+		{"gpt-4-turbo-2024-04-09", 128000},
+		{"gpt-4o-mini", 128000},
+		{"gpt-4.1-nano", 1047576},
+		{"gpt-5-nano-2025-08-07", 400000},
+		{"o1", 200000},
+		{"o1-2024-12-17", 200000},
+		{"o1-mini", 128000},
+		{"o3-mini", 200000},
+		{"openai/gpt-4-turbo", 128000},
+		{"GROK-4-1-FAST-non-reasoning", 2000000},
+		{"grok-4-0709", 256000},
+		{"claude-sonnet-4-5", 200000},
+		{"unknown-model", DefaultContextWindow},
+		{"", DefaultContextWindow},
+	}
+	for _, tt := range tests {
+		if got := ContextWindowFor(tt.model); got != tt.want {
+			t.Errorf("ContextWindowFor(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestMaxOutputTokensFor(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"claude-3-haiku-20240307", 4096},
+		{"claude-3-5-haiku-latest", 8192},
+		{"claude-3-7-sonnet-latest", 64000},
+		{"claude-opus-4-1", 32000},
+		{"claude-opus-4-5", 64000},
+		{"claude-sonnet-4-5", 64000},
+		{"anthropic/claude-haiku-4-5", 64000},
+		{"gpt-5", 0},
+	}
+	for _, tt := range tests {
+		if got := MaxOutputTokensFor(tt.model); got != tt.want {
+			t.Errorf("MaxOutputTokensFor(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestContextWindowPreset(t *testing.T) {
+	if got := NewPresetInferencer(Presets["ollama"], "", "llama-3.1-8b").ContextWindow(); got != 8192 {
+		t.Errorf("ollama context window = %d, want the preset's 8192", got)
+	}
+	if got := NewPresetInferencer(Presets["openai"], "", "gpt-4-turbo").ContextWindow(); got != 128000 {
+		t.Errorf("openai context window = %d, want the model's 128000", got)
+	}
+}
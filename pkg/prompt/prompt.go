@@ -0,0 +1,231 @@
+// Package prompt renders the system prompts sent to the model from text/template files.
+// The built-in prompts are embedded as defaults and can be overridden, per source or per story,
+// by templates in a directory that is re-read whenever a file changes.
+package prompt
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Names of the built-in prompts.
+const (
+	Summarize   = "summarize"
+	Names       = "names"
+	Edit        = "edit"
+	Portrait    = "portrait"
+	Scene       = "scene"
+	Consolidate = "consolidate"
+	// FixSchema is appended to a task's user message when its output is sent back with schema violations.
+	FixSchema = "fix_schema"
+)
+
+const ext = ".tmpl"
+
+//go:embed templates
+var embedded embed.FS
+
+// Data is passed to every template. Fields a prompt does not use are ignored.
+type Data struct {
+	Source string
+	Story  string
+	// Example is a few-shot example, such as a main character as JSON.
+	Example string
+	// Rules and Instructions are the custom rules and user prompt of an edit.
+	Rules        string
+	Instructions string
+}
+
+// Variant selects the most specific template for a source and story.
+type Variant struct {
+	Source string `json:"source,omitempty"`
+	Story  string `json:"story,omitempty"`
+}
+
+// VariantOf returns the variant for a story ID, which the server prefixes with its source
+// (e.g. "ao3:123"). An empty source is taken from the prefix.
+func VariantOf(source, id string) Variant {
+	if source == "" {
+		if s, story, ok := strings.Cut(id, ":"); ok {
+			return Variant{Source: s, Story: story}
+		}
+		return Variant{Story: id}
+	}
+	return Variant{Source: source, Story: strings.TrimPrefix(id, source+":")}
+}
+
+// paths returns the template paths for name from most to least specific:
+// <source>/<story>/<name>.tmpl, <source>/<name>.tmpl and <name>.tmpl.
+func (v Variant) paths(name string) []string {
+	var out []string
+	source, story := safeName(v.Source), safeName(v.Story)
+	if source != "" && story != "" {
+		out = append(out, path.Join(source, story, name+ext))
+	}
+	if source != "" {
+		out = append(out, path.Join(source, name+ext))
+	}
+	return append(out, name+ext)
+}
+
+// safeName makes an ID usable as a directory name on every platform.
+func safeName(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "." || s == ".." {
+		return ""
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Template describes one template file available to the registry.
+type Template struct {
+	Name    string  `json:"name"`
+	Variant Variant `json:"variant"`
+	Path    string  `json:"path"`
+	// Origin is "dir" for an override file and "embedded" for a built-in default.
+	Origin string `json:"origin"`
+}
+
+type parsed struct {
+	mod  time.Time
+	tmpl *template.Template
+}
+
+// Registry looks up and renders prompt templates.
+type Registry struct {
+	// Dir holds override templates; empty uses the embedded defaults only.
+	Dir string
+
+	mu    sync.Mutex
+	cache map[string]parsed
+}
+
+// NewRegistry returns a registry that prefers templates in dir over the embedded defaults.
+func NewRegistry(dir string) *Registry {
+	return &Registry{Dir: dir, cache: make(map[string]parsed)}
+}
+
+// Render executes the most specific template for name and variant. At each level an override in
+// Dir is tried before an embedded default. Templates that fail to parse or execute are skipped
+// and reported in the returned error alongside the prompt that did render, so a broken override
+// falls back to the default instead of failing the request.
+func (r *Registry) Render(name string, variant Variant, data Data) (string, Template, error) {
+	var errs []error
+	for _, p := range variant.paths(name) {
+		for _, origin := range []string{"dir", "embedded"} {
+			tmpl, ok, err := r.load(origin, p)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if !ok {
+				continue
+			}
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, data); err != nil {
+				errs = append(errs, fmt.Errorf("prompt %s (%s): %w", p, origin, err))
+				continue
+			}
+			t := Template{Name: name, Variant: variantOf(p), Path: p, Origin: origin}
+			return strings.TrimSpace(b.String()), t, errors.Join(errs...)
+		}
+	}
+	errs = append(errs, fmt.Errorf("prompt %q not found", name))
+	return "", Template{}, errors.Join(errs...)
+}
+
+func (r *Registry) load(origin, p string) (*template.Template, bool, error) {
+	var (
+		read func() ([]byte, error)
+		mod  time.Time
+	)
+	switch origin {
+	case "dir":
+		if r.Dir == "" {
+			return nil, false, nil
+		}
+		file := filepath.Join(r.Dir, filepath.FromSlash(p))
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, false, nil
+		}
+		mod = info.ModTime()
+		read = func() ([]byte, error) { return os.ReadFile(file) }
+	default:
+		if _, err := fs.Stat(embedded, "templates/"+p); err != nil {
+			return nil, false, nil
+		}
+		read = func() ([]byte, error) { return embedded.ReadFile("templates/" + p) }
+	}
+
+	key := origin + ":" + p
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cache == nil {
+		r.cache = make(map[string]parsed)
+	}
+	if c, ok := r.cache[key]; ok && c.mod.Equal(mod) {
+		return c.tmpl, true, nil
+	}
+	src, err := read()
+	if err != nil {
+		return nil, false, fmt.Errorf("prompt %s (%s): %w", p, origin, err)
+	}
+	tmpl, err := template.New(p).Parse(string(src))
+	if err != nil {
+		return nil, false, fmt.Errorf("prompt %s (%s): %w", p, origin, err)
+	}
+	r.cache[key] = parsed{mod: mod, tmpl: tmpl}
+	return tmpl, true, nil
+}
+
+// List returns every template available in Dir and the embedded defaults.
+func (r *Registry) List() []Template {
+	var out []Template
+	collect := func(fsys fs.FS, origin string) {
+		_ = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(p, ext) {
+				return nil
+			}
+			out = append(out, Template{Name: strings.TrimSuffix(path.Base(p), ext), Variant: variantOf(p), Path: p, Origin: origin})
+			return nil
+		})
+	}
+	if r.Dir != "" {
+		collect(os.DirFS(r.Dir), "dir")
+	}
+	if sub, err := fs.Sub(embedded, "templates"); err == nil {
+		collect(sub, "embedded")
+	}
+	slices.SortFunc(out, func(a, b Template) int {
+		return strings.Compare(a.Name+"/"+a.Path+"/"+a.Origin, b.Name+"/"+b.Path+"/"+b.Origin)
+	})
+	return out
+}
+
+// variantOf recovers the variant from a template path.
+func variantOf(p string) Variant {
+	dirs := strings.Split(path.Dir(p), "/")
+	switch {
+	case len(dirs) >= 2:
+		return Variant{Source: dirs[0], Story: dirs[1]}
+	case dirs[0] != ".":
+		return Variant{Source: dirs[0]}
+	}
+	return Variant{}
+}
@@ -0,0 +1,186 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTemplate writes src to rel under dir.
+func writeTemplate(t *testing.T, dir, rel, src string) {
+	t.Helper()
+	file := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderFallback(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "names.tmpl", "default {{.Story}}")
+	writeTemplate(t, dir, "ao3/names.tmpl", "source {{.Source}}")
+	writeTemplate(t, dir, "ao3/123/names.tmpl", "story {{.Source}}:{{.Story}}")
+	r := NewRegistry(dir)
+
+	tests := []struct {
+		name    string
+		variant Variant
+		want    string
+		path    string
+	}{
+		{"story override", Variant{Source: "ao3", Story: "123"}, "story ao3:123", "ao3/123/names.tmpl"},
+		{"source override", Variant{Source: "ao3", Story: "456"}, "source ao3", "ao3/names.tmpl"},
+		{"default override", Variant{Source: "wattpad", Story: "123"}, "default 123", "names.tmpl"},
+		{"story without source", Variant{Story: "123"}, "default 123", "names.tmpl"},
+		{"no variant", Variant{}, "default", "names.tmpl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, tmpl, err := r.Render(Names, tt.variant, Data{Source: tt.variant.Source, Story: tt.variant.Story})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || tmpl.Path != tt.path || tmpl.Origin != "dir" || tmpl.Name != Names {
+				t.Errorf("Render = %q from %+v, want %q from %s", got, tmpl, tt.want, tt.path)
+			}
+		})
+	}
+}
+
+func TestRenderEmbedded(t *testing.T) {
+	for _, name := range []string{Summarize, Names, Edit, Portrait, Scene, Consolidate, FixSchema} {
+		got, tmpl, err := NewRegistry("").Render(name, Variant{Source: "ao3", Story: "1"}, Data{Example: "{}"})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got == "" || tmpl.Origin != "embedded" || tmpl.Path != name+ext || tmpl.Variant != (Variant{}) {
+			t.Errorf("%s: rendered %d bytes from %+v, want the embedded default", name, len(got), tmpl)
+		}
+	}
+}
+
+func TestRenderBrokenOverride(t *testing.T) {
+	tests := []struct {
+		name, src, problem string
+	}{
+		{"parse error", "story {{.Story", "ao3/1/names.tmpl (dir)"},
+		{"execute error", "story {{.Missing}}", "ao3/1/names.tmpl (dir)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTemplate(t, dir, "ao3/names.tmpl", "source")
+			writeTemplate(t, dir, "ao3/1/names.tmpl", tt.src)
+
+			got, tmpl, err := NewRegistry(dir).Render(Names, Variant{Source: "ao3", Story: "1"}, Data{})
+			if got != "source" || tmpl.Path != "ao3/names.tmpl" {
+				t.Errorf("Render = %q from %+v, want the source template", got, tmpl)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("err = %v, want it to name %s", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestRenderBrokenDefaultOverride(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "names.tmpl", "{{if}}")
+
+	got, tmpl, err := NewRegistry(dir).Render(Names, Variant{}, Data{})
+	if got == "" || tmpl.Origin != "embedded" {
+		t.Errorf("Render = %q from %+v, want the embedded default", got, tmpl)
+	}
+	if err == nil {
+		t.Error("err = nil, want the broken override reported")
+	}
+}
+
+func TestRenderNotFound(t *testing.T) {
+	got, _, err := NewRegistry(t.TempDir()).Render("missing", Variant{Source: "ao3"}, Data{})
+	if got != "" || err == nil || !strings.Contains(err.Error(), `prompt "missing" not found`) {
+		t.Errorf("Render = %q, %v; want not found", got, err)
+	}
+}
+
+func TestRenderReloadsChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "names.tmpl", "first")
+	r := NewRegistry(dir)
+	if got, _, _ := r.Render(Names, Variant{}, Data{}); got != "first" {
+		t.Fatalf("Render = %q, want first", got)
+	}
+
+	writeTemplate(t, dir, "names.tmpl", "second")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "names.tmpl"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := r.Render(Names, Variant{}, Data{}); got != "second" {
+		t.Errorf("Render = %q, want the changed file", got)
+	}
+}
+
+func TestVariantOf(t *testing.T) {
+	tests := []struct {
+		source, id string
+		want       Variant
+	}{
+		{"", "ao3:123", Variant{Source: "ao3", Story: "123"}},
+		{"", "123", Variant{Story: "123"}},
+		{"ao3", "ao3:123", Variant{Source: "ao3", Story: "123"}},
+		{"ao3", "123", Variant{Source: "ao3", Story: "123"}},
+	}
+	for _, tt := range tests {
+		if got := VariantOf(tt.source, tt.id); got != tt.want {
+			t.Errorf("VariantOf(%q, %q) = %+v, want %+v", tt.source, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestVariantPaths(t *testing.T) {
+	tests := []struct {
+		variant Variant
+		want    []string
+	}{
+		{Variant{Source: "ao3", Story: "1"}, []string{"ao3/1/names.tmpl", "ao3/names.tmpl", "names.tmpl"}},
+		{Variant{Source: "ao3"}, []string{"ao3/names.tmpl", "names.tmpl"}},
+		{Variant{Story: "1"}, []string{"names.tmpl"}},
+		{Variant{Source: "..", Story: "1"}, []string{"names.tmpl"}},
+		{Variant{Source: "a/b", Story: `c\d`}, []string{"a_b/c_d/names.tmpl", "a_b/names.tmpl", "names.tmpl"}},
+	}
+	for _, tt := range tests {
+		got := tt.variant.paths(Names)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("paths(%+v) = %v, want %v", tt.variant, got, tt.want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir, "ao3/1/names.tmpl", "story")
+	writeTemplate(t, dir, "notes.txt", "ignored")
+
+	var found, embedded bool
+	for _, tmpl := range NewRegistry(dir).List() {
+		if tmpl.Path == "notes.txt" {
+			t.Errorf("listed %s", tmpl.Path)
+		}
+		if tmpl.Origin == "dir" && tmpl.Path == "ao3/1/names.tmpl" && tmpl.Name == Names && tmpl.Variant == (Variant{Source: "ao3", Story: "1"}) {
+			found = true
+		}
+		if tmpl.Origin == "embedded" && tmpl.Path == "summarize.tmpl" {
+			embedded = true
+		}
+	}
+	if !found || !embedded {
+		t.Errorf("List found override = %v, embedded default = %v; want both", found, embedded)
+	}
+}
@@ -0,0 +1,98 @@
+// Package fake provides a queue.Queue that answers every generation with fixture images,
+// so portraits can be generated without a network.
+package fake
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"paige/pkg/schema"
+)
+
+// Queue returns fixture PNGs in turn for every request and records the requests it receives.
+type Queue struct {
+	mu       sync.Mutex
+	images   [][]byte
+	next     int
+	requests []*schema.NovelAIRequest
+
+	// Err fails every request when set.
+	Err error
+}
+
+// New returns a queue answering with images, or with a generated placeholder when none are given.
+func New(images ...[]byte) *Queue {
+	if len(images) == 0 {
+		images = [][]byte{Placeholder(64, 64)}
+	}
+	return &Queue{images: images}
+}
+
+// FromDir returns a queue answering with the PNG files in dir, in name order.
+func FromDir(dir string) (*Queue, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var images [][]byte
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".png") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, data)
+	}
+	return New(images...), nil
+}
+
+// Placeholder encodes a plain grey PNG of the given size.
+func Placeholder(width, height int) []byte {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = color.Gray{Y: 0x80}.Y
+	}
+	var b bytes.Buffer
+	_ = png.Encode(&b, img)
+	return b.Bytes()
+}
+
+func (q *Queue) Start() {}
+
+func (q *Queue) Stop() {}
+
+// Add answers req immediately with the next fixture image, or with Err.
+func (q *Queue) Add(req *schema.NovelAIRequest) (chan []io.Reader, chan error, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.requests = append(q.requests, req)
+
+	respCh := make(chan []io.Reader, 1)
+	errCh := make(chan error, 1)
+	// Only one channel is ever written, so a select over both cannot pick a closed one.
+	if q.Err != nil {
+		errCh <- q.Err
+		return respCh, errCh, nil
+	}
+	data := q.images[q.next%len(q.images)]
+	q.next++
+	respCh <- []io.Reader{bytes.NewReader(data)}
+	return respCh, errCh, nil
+}
+
+// Requests returns a copy of the requests received so far.
+func (q *Queue) Requests() []*schema.NovelAIRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return slices.Clone(q.requests)
+}
@@ -4,11 +4,11 @@ type Summary struct {
 	Characters []Character `json:"characters" jsonschema_description:"List of extracted characters with their attributes and actions"`
 	Timeline   []Timeline  `json:"timeline" jsonschema_description:"Chronological sequence of dated events extracted from the story"`
 
-	Chapters map[string]bool    `json:"chapters" jsonschema_description:"List of chapter IDs visited (filled by backend)"`
-	Heat     map[string]float64 `json:"heat,omitempty" jsonschema_description:"Mapping of paragraph (starting from 1) with a level of 0-3 based on sexual activity intensity"`
+	Chapters map[string]bool `json:"chapters" jsonschema:"-" jsonschema_description:"List of chapter IDs visited (filled by backend)"`
+	Heat     Heat            `json:"heat,omitempty" jsonschema_description:"Level of 0-3 based on sexual activity intensity for each paragraph (starting from 1)"`
 
-	StoredHeat map[string]map[string]float64 `json:"stored_heat,omitempty" jsonschema_description:"Backend storage of heat maps per chapter ID"`
-	Edits      map[string][]EditHistoryEntry `json:"edits,omitempty" jsonschema_description:"Manual edit history keyed by chapter ID"`
+	StoredHeat map[string]map[string]float64 `json:"stored_heat,omitempty" jsonschema:"-" jsonschema_description:"Backend storage of heat maps per chapter ID"`
+	Edits      map[string][]EditHistoryEntry `json:"edits,omitempty" jsonschema:"-" jsonschema_description:"Manual edit history keyed by chapter ID"`
 }
 
 type EditHistoryEntry struct {
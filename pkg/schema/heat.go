@@ -0,0 +1,50 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/invopop/jsonschema"
+)
+
+// Heat maps paragraph numbers, starting from 1, to a level of 0-3. It is stored and served as a
+// map, but models write it as a list of paragraph levels, since strict structured outputs do not
+// allow maps.
+type Heat map[string]float64
+
+// HeatLevel is the heat of one paragraph as written by the model.
+type HeatLevel struct {
+	Paragraph int     `json:"paragraph" jsonschema_description:"Paragraph number, starting from 1"`
+	Level     float64 `json:"level" jsonschema_description:"Level of 0-3 in 0.5 increments"`
+}
+
+// JSONSchema describes heat in the list form models write.
+func (Heat) JSONSchema() *jsonschema.Schema {
+	r := jsonschema.Reflector{AllowAdditionalProperties: false, DoNotReference: true}
+	s := r.Reflect([]HeatLevel{})
+	s.Version = ""
+	return s
+}
+
+// UnmarshalJSON accepts the list form written by models as well as the stored map.
+func (h *Heat) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("[")) {
+		var m map[string]float64
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*h = m
+		return nil
+	}
+	var levels []HeatLevel
+	if err := json.Unmarshal(data, &levels); err != nil {
+		return err
+	}
+	*h = make(Heat, len(levels))
+	for _, l := range levels {
+		(*h)[strconv.Itoa(l.Paragraph)] = l.Level
+	}
+	return nil
+}
@@ -1,6 +1,13 @@
 package schema
 
 import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
 	"github.com/invopop/jsonschema"
 	"github.com/openai/openai-go/v3"
 )
@@ -14,16 +21,119 @@ func generateSchema[T any]() any {
 	return r.Reflect(v)
 }
 
-var StorySummarySchema = generateSchema[Summary]()
+var schemas sync.Map // reflect.Type -> any
+
+// SchemaFor returns the reflected JSON Schema for T, generating it once per type.
+func SchemaFor[T any]() any {
+	t := reflect.TypeFor[T]()
+	if s, ok := schemas.Load(t); ok {
+		return s
+	}
+	s, _ := schemas.LoadOrStore(t, generateSchema[T]())
+	return s
+}
+
+// Named is implemented by output types to name and describe their response format.
+type Named interface {
+	SchemaName() string
+	SchemaDescription() string
+}
+
+func (Summary) SchemaName() string { return "story_summary" }
+func (Summary) SchemaDescription() string {
+	return "Characters and timeline extracted from a fictional story"
+}
 
-func StructuredOutputsResponseFormat() openai.ChatCompletionNewParamsResponseFormatUnion {
+// ResponseFormatFor returns a json_schema response format constraining output to T. It is named
+// after T in snake case unless T implements Named. Strict mode is requested when every object in
+// the schema is closed; optional properties are then sent as required but nullable, as strict
+// mode demands. Otherwise the schema guides the model and the output is validated after decoding.
+func ResponseFormatFor[T any]() openai.ChatCompletionNewParamsResponseFormatUnion {
+	s := SchemaFor[T]()
 	p := openai.ResponseFormatJSONSchemaJSONSchemaParam{
-		Name:        "story_summary",
-		Description: openai.String("Characters and timeline extracted from a fictional story"),
-		Schema:      StorySummarySchema,
-		Strict:      openai.Bool(true),
+		Name:   snakeCase(reflect.TypeFor[T]().Name()),
+		Schema: s,
+		Strict: openai.Bool(false),
+	}
+	if strict, ok := strictSchema(s); ok {
+		p.Schema, p.Strict = strict, openai.Bool(true)
+	}
+	var v T
+	if named, ok := any(v).(Named); ok {
+		p.Name = named.SchemaName()
+		p.Description = openai.String(named.SchemaDescription())
 	}
 	return openai.ChatCompletionNewParamsResponseFormatUnion{
 		OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{JSONSchema: p},
 	}
 }
+
+var wordBoundary = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+func snakeCase(name string) string {
+	return strings.ToLower(wordBoundary.ReplaceAllString(name, "${1}_${2}"))
+}
+
+// strictSchema returns a copy of s for strict structured outputs, which reject open objects such
+// as maps and properties that are not required. Optional properties are made required and
+// nullable, since null decodes to the same zero value as an omitted property. It reports false
+// when s has an open object.
+func strictSchema(s any) (map[string]any, bool) {
+	bin, err := json.Marshal(s)
+	if err != nil {
+		return nil, false
+	}
+	var m map[string]any
+	if err := json.Unmarshal(bin, &m); err != nil {
+		return nil, false
+	}
+	return m, strictNode(m)
+}
+
+func strictNode(node map[string]any) bool {
+	if items, ok := node["items"].(map[string]any); ok && !strictNode(items) {
+		return false
+	}
+	props, hasProps := node["properties"].(map[string]any)
+	if !hasProps {
+		_, open := node["additionalProperties"].(map[string]any)
+		return !open
+	}
+	if closed, ok := node["additionalProperties"].(bool); !ok || closed {
+		return false
+	}
+	list, _ := node["required"].([]any)
+	required := make(map[string]bool)
+	for _, name := range list {
+		if name, ok := name.(string); ok {
+			required[name] = true
+		}
+	}
+	var optional []string
+	for name, prop := range props {
+		sub, ok := prop.(map[string]any)
+		if !ok || !strictNode(sub) {
+			return false
+		}
+		if !required[name] {
+			nullable(sub)
+			optional = append(optional, name)
+		}
+	}
+	slices.Sort(optional)
+	for _, name := range optional {
+		list = append(list, name)
+	}
+	node["required"] = list
+	return true
+}
+
+// nullable lets node also be null.
+func nullable(node map[string]any) {
+	if t, ok := node["type"].(string); ok {
+		node["type"] = []any{t, "null"}
+	}
+	if enum, ok := node["enum"].([]any); ok {
+		node["enum"] = append(enum, nil)
+	}
+}
@@ -0,0 +1,64 @@
+package schema
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestSummaryResponseFormatIsStrict(t *testing.T) {
+	p := ResponseFormatFor[Summary]().OfJSONSchema.JSONSchema
+	if p.Name != "story_summary" || !p.Strict.Value {
+		t.Fatalf("name, strict = %q, %v; want story_summary, true", p.Name, p.Strict.Value)
+	}
+	root := p.Schema.(map[string]any)
+	summary := root["properties"].(map[string]any)
+	heat := summary["heat"].(map[string]any)
+	if !slices.Equal(heat["type"].([]any), []any{"array", "null"}) {
+		t.Errorf("heat type = %v, want a nullable array", heat["type"])
+	}
+	if !slices.Contains(root["required"].([]any), any("heat")) {
+		t.Errorf("required = %v, want heat listed", root["required"])
+	}
+	// Validation runs against the reflected schema, which keeps heat optional.
+	bin, _ := json.Marshal(SchemaFor[Summary]())
+	var reflected struct {
+		Required []string `json:"required"`
+	}
+	_ = json.Unmarshal(bin, &reflected)
+	if slices.Contains(reflected.Required, "heat") {
+		t.Error("the strict copy modified the reflected schema")
+	}
+}
+
+func TestHeatUnmarshal(t *testing.T) {
+	for _, in := range []string{
+		`{"heat":[{"paragraph":1,"level":0},{"paragraph":2,"level":2.5}]}`,
+		`{"heat":{"1":0,"2":2.5}}`,
+	} {
+		var s Summary
+		if err := json.Unmarshal([]byte(in), &s); err != nil {
+			t.Fatalf("%s: %v", in, err)
+		}
+		if len(s.Heat) != 2 || s.Heat["2"] != 2.5 {
+			t.Errorf("%s: heat = %v", in, s.Heat)
+		}
+		out, _ := json.Marshal(s.Heat)
+		if string(out) != `{"1":0,"2":2.5}` {
+			t.Errorf("%s: marshaled heat = %s, want the stored map", in, out)
+		}
+	}
+	var s Summary
+	if err := json.Unmarshal([]byte(`{"heat":null}`), &s); err != nil || s.Heat != nil {
+		t.Errorf("null heat = %v, %v", s.Heat, err)
+	}
+}
+
+func TestResponseFormatOpenMap(t *testing.T) {
+	type tags struct {
+		Tags map[string]string `json:"tags"`
+	}
+	if p := ResponseFormatFor[tags]().OfJSONSchema.JSONSchema; p.Strict.Value {
+		t.Error("a map property must not be sent strict")
+	}
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/labstack/echo/v4"
+
+	"paige/pkg/inference"
+	"paige/pkg/schema"
+)
+
+// BatchJob is a bulk summarization submitted to the provider's batch API. Jobs are saved to
+// Batches.json so polling resumes after a restart.
+type BatchJob struct {
+	ID string `json:"id"`
+	// Provider is the provider the batch was submitted to, for usage accounting.
+	Provider string                `json:"provider,omitempty"`
+	Created  time.Time             `json:"created"`
+	Updated  time.Time             `json:"updated"`
+	Status   inference.BatchStatus `json:"status"`
+	// Merged is set once the results were merged into the stored summaries.
+	Merged  bool         `json:"merged"`
+	Stories []BatchStory `json:"stories"`
+	Chunks  []BatchChunk `json:"chunks"`
+	// Errors maps the custom ID of each failed chunk to its error.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// BatchStory is one story of a batch job.
+type BatchStory struct {
+	ID      string `json:"id"`
+	Source  string `json:"source,omitempty"`
+	Chapter string `json:"chapter,omitempty"`
+	Chunks  int    `json:"chunks"`
+	// Characters is the number of characters in the summary after merging.
+	Characters int `json:"characters,omitempty"`
+	// Seed is the context sent with a story that had no stored summary, which its chunks were
+	// extracted against and are merged into.
+	Seed *schema.Summary `json:"seed,omitempty"`
+}
+
+// BatchChunk maps a batch request back to its story and chunk.
+type BatchChunk struct {
+	CustomID string `json:"custom_id"`
+	Story    int    `json:"story"`
+	Index    int    `json:"index"`
+	Part     string `json:"part,omitempty"`
+	// Text is the chunk's user message, kept until merging to record refusals.
+	Text string `json:"text,omitempty"`
+}
+
+type batchSummarizeReq struct {
+	Stories []summarizeReq `json:"stories"`
+}
+
+// POST /api/batch/summarize
+func (s *Server) handlePostBatchSummarize(c echo.Context) error {
+	if s.Batcher == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the configured provider does not support batches")
+	}
+	var req batchSummarizeReq
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid json")
+	}
+	if len(req.Stories) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "no stories to summarize")
+	}
+	if s.Ledger != nil && s.Ledger.OverBudget() {
+		return echo.NewHTTPError(http.StatusTooManyRequests, "daily usage budget exceeded")
+	}
+
+	job := &BatchJob{Provider: s.batchProvider(), Created: time.Now().UTC()}
+	var requests []inference.BatchRequest
+	for n, story := range req.Stories {
+		story.Text = strings.TrimSpace(story.Text)
+		if story.Source != "" && story.ID != "" {
+			story.ID = story.Source + ":" + story.ID
+		}
+		summary := s.baseSummary(story)
+		systemPrompt, _, chunks := s.planSummary(story, summary)
+		entry := BatchStory{ID: story.ID, Source: story.Source, Chapter: story.Chapter, Chunks: len(chunks)}
+		if _, ok := s.storedSummary(story.ID); !ok && (len(story.Characters) > 0 || len(story.Timeline) > 0) {
+			entry.Seed = &schema.Summary{Characters: story.Characters, Timeline: story.Timeline}
+		}
+		job.Stories = append(job.Stories, entry)
+
+		for _, part := range chunks {
+			chunk := part.String()
+			id := fmt.Sprintf("%s:%s chapter:%s chunk:%d%s", story.Source, story.ID, story.Chapter, part.Index, part.Part)
+			if s.similarForbid(id, chunk) {
+				continue
+			}
+			var summaryJSON []byte
+			if len(summary.Characters) > 0 || len(summary.Timeline) > 0 {
+				bin, err := summaryContext(summary, chunk, part.Depth)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "failed preparing summarization context")
+				}
+				summaryJSON = bin
+			}
+			user := contextPrefix(summaryJSON) + chunk
+			customID := fmt.Sprintf("s%d-c%d%s", n, part.Index, part.Part)
+			job.Chunks = append(job.Chunks, BatchChunk{CustomID: customID, Story: n, Index: part.Index, Part: part.Part, Text: user})
+			requests = append(requests, inference.BatchRequest{
+				CustomID: customID,
+				Scope:    inference.Scope{Task: inference.TaskSummarize, Story: story.ID, Chunk: part.Index},
+				Params:   s.summarizeParams(systemPrompt, user),
+				System:   systemPrompt,
+				User:     user,
+			})
+		}
+	}
+	if len(requests) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "no text to summarize")
+	}
+
+	status, err := s.Batcher.SubmitBatch(c.Request().Context(), requests)
+	if err != nil {
+		log.Error("failed submitting summarization batch", "requests", len(requests), "error", err)
+		return echo.NewHTTPError(inferenceStatus(err), "failed submitting batch: "+err.Error())
+	}
+	job.ID, job.Status, job.Updated = status.ID, status, time.Now().UTC()
+	s.saveBatch(job)
+	log.Info("submitted summarization batch", "batch", job.ID, "stories", len(job.Stories), "requests", len(requests))
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// GET /api/batch
+func (s *Server) handleGetBatches(c echo.Context) error {
+	s.batchesMu.Lock()
+	defer s.batchesMu.Unlock()
+	jobs := make([]*BatchJob, 0, len(s.Batches))
+	for _, job := range s.Batches {
+		jobs = append(jobs, job)
+	}
+	slices.SortFunc(jobs, func(a, b *BatchJob) int { return b.Created.Compare(a.Created) })
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// GET /api/batch/:id
+func (s *Server) handleGetBatch(c echo.Context) error {
+	s.batchesMu.Lock()
+	defer s.batchesMu.Unlock()
+	job, ok := s.Batches[c.Param("id")]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "batch not found")
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// batchProvider names the provider behind Batcher. Only OpenAI-compatible presets have a batch
+// API, so it is the preset name.
+func (s *Server) batchProvider() string {
+	if p, ok := s.Batcher.(interface{ Preset() inference.Preset }); ok {
+		return p.Preset().Name
+	}
+	return "batch"
+}
+
+// saveBatch stores job and persists every job to Batches.json.
+func (s *Server) saveBatch(job *BatchJob) {
+	s.batchesMu.Lock()
+	defer s.batchesMu.Unlock()
+	if s.Batches == nil {
+		s.Batches = make(map[string]*BatchJob)
+	}
+	s.Batches[job.ID] = job
+	if err := s.save("Batches.json", s.Batches); err != nil {
+		log.Warn("failed saving batch state", "error", err)
+	}
+}
+
+// RunBatches polls unfinished batch jobs every interval until ctx is done and merges the results
+// of completed ones into the stored summaries.
+func (s *Server) RunBatches(ctx context.Context, interval time.Duration) {
+	if s.Batcher == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.pollBatches(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) pollBatches(ctx context.Context) {
+	s.batchesMu.Lock()
+	var pending []*BatchJob
+	for _, job := range s.Batches {
+		if !job.Merged && job.Status.State != inference.BatchFailed {
+			pending = append(pending, job)
+		}
+	}
+	s.batchesMu.Unlock()
+	slices.SortFunc(pending, func(a, b *BatchJob) int { return a.Created.Compare(b.Created) })
+
+	for _, job := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		status, err := s.Batcher.BatchStatus(ctx, job.ID)
+		if err != nil {
+			log.Warn("failed polling batch", "batch", job.ID, "error", err)
+			continue
+		}
+		updated := *job
+		updated.Stories, updated.Chunks = slices.Clone(job.Stories), slices.Clone(job.Chunks)
+		updated.Status, updated.Updated = status, time.Now().UTC()
+		switch status.State {
+		case inference.BatchFailed:
+			log.Error("summarization batch failed", "batch", job.ID, "status", status.Provider, "error", status.Error)
+		case inference.BatchCompleted:
+			if err := s.mergeBatch(ctx, &updated); err != nil {
+				log.Warn("failed merging batch results, will retry", "batch", job.ID, "error", err)
+				continue
+			}
+		}
+		s.saveBatch(&updated)
+	}
+}
+
+// mergeBatch downloads a completed batch and merges every story's chunks into its summary. The
+// results arrive in any order, so they are merged in chunk order by their custom IDs.
+func (s *Server) mergeBatch(ctx context.Context, job *BatchJob) error {
+	results, err := s.Batcher.BatchResults(ctx, job.Status)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]inference.BatchResult, len(results))
+	for _, r := range results {
+		byID[r.CustomID] = r
+	}
+	job.Errors = make(map[string]string)
+
+	// Chapters of one story are merged in submission order and the story is consolidated once.
+	var stories []string
+	chunks := make(map[string]int)
+	summaries := make(map[string]schema.Summary)
+	for n := range job.Stories {
+		story := &job.Stories[n]
+		summary, ok := summaries[story.ID]
+		if !ok {
+			base := summarizeReq{ID: story.ID}
+			if story.Seed != nil {
+				base.Characters, base.Timeline = story.Seed.Characters, story.Seed.Timeline
+			}
+			summary = s.baseSummary(base)
+		}
+		summary.Heat = summary.StoredHeat[story.Chapter]
+		merged := 0
+		for _, chunk := range job.Chunks {
+			if chunk.Story != n {
+				continue
+			}
+			r, ok := byID[chunk.CustomID]
+			if !ok {
+				job.Errors[chunk.CustomID] = "no result returned"
+				continue
+			}
+			parsed, err := s.batchChunk(ctx, cmp.Or(job.Provider, s.batchProvider()), story, chunk, r)
+			if err != nil {
+				job.Errors[chunk.CustomID] = err.Error()
+				continue
+			}
+			mergeSummary(&summary, parsed, story.Chapter)
+			merged++
+		}
+		if merged == 0 {
+			continue
+		}
+		if story.Chapter != "" {
+			if summary.Chapters == nil {
+				summary.Chapters = make(map[string]bool)
+			}
+			summary.Chapters[story.Chapter] = true
+		}
+		summaries[story.ID] = summary
+		if _, ok := chunks[story.ID]; !ok {
+			stories = append(stories, story.ID)
+		}
+		chunks[story.ID] += merged
+	}
+
+	// Chunks extracted independently always need reconciling, as in parallel mode.
+	for _, id := range stories {
+		summary := summaries[id]
+		if chunks[id] >= 2 || needsConsolidation(summary) {
+			consolidated, _, err := s.consolidateSummary(ctx, id, summary)
+			if err != nil {
+				log.Warn("consolidation failed, keeping merged summary", "id", id, "error", err)
+			} else {
+				summary = consolidated
+			}
+		}
+		summaries[id] = summary
+		if err := s.storeSummary(id, summary); err != nil {
+			log.Warn("failed saving summary data", "error", err)
+		}
+	}
+	for n := range job.Stories {
+		if summary, ok := summaries[job.Stories[n].ID]; ok {
+			job.Stories[n].Characters = len(summary.Characters)
+		}
+	}
+
+	for i := range job.Chunks {
+		job.Chunks[i].Text = ""
+	}
+	job.Merged = true
+	log.Info("merged summarization batch", "batch", job.ID, "stories", len(job.Stories), "results", len(results), "errors", len(job.Errors))
+	return nil
+}
+
+// batchChunk accounts for and decodes one batch result. Invalid output is repaired locally
+// only, since the batch holds no live request to send back to the model.
+func (s *Server) batchChunk(ctx context.Context, provider string, story *BatchStory, chunk BatchChunk, r inference.BatchResult) (schema.Summary, error) {
+	scope := inference.Scope{Task: inference.TaskSummarize, Story: story.ID, Chunk: chunk.Index}
+	if s.Ledger != nil && r.Result.Usage != (inference.Usage{}) {
+		s.Ledger.Record(scope, provider, r.Result.Model, r.Result.Usage)
+	}
+	err := r.Err
+	if errors.Is(err, inference.ErrTruncated) && r.Result.Content != "" {
+		err = nil
+	}
+	if errors.Is(err, inference.ErrContentRefused) {
+		id := fmt.Sprintf("%s:%s chapter:%s chunk:%d%s", story.Source, story.ID, story.Chapter, chunk.Index, chunk.Part)
+		s.recordForbid(id, schema.Forbids{Reason: "summarization forbidden", Text: chunk.Text, Raw: inference.RawError(err)})
+	}
+	if err != nil {
+		return schema.Summary{}, err
+	}
+	hasCharacters := func(v schema.Summary) []inference.Violation { return requireItems("$.characters", len(v.Characters)) }
+	return decodeRepaired(inference.WithScope(ctx, scope), s, inference.TaskSummarize, r.Result, hasCharacters, nil)
+}
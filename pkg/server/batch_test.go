@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"paige/pkg/inference"
+	"paige/pkg/inference/fake"
+	"paige/pkg/schema"
+)
+
+// newBatchServer returns a test server whose batches go to a fake batch API answered by inf.
+func newBatchServer(t *testing.T, inf *fake.Inferencer) *Server {
+	t.Helper()
+	api := httptest.NewServer(fake.NewBatchServer(inf))
+	t.Cleanup(api.Close)
+	batcher := inference.NewPresetInferencer(inference.Presets["openai"], "key", "fake-model")
+	batcher.ChangeBaseURL(api.URL + "/v1")
+
+	s := newTestServer(t, inf)
+	s.Batcher = batcher
+	s.Ledger = inference.NewLedger(inference.LedgerData{}, nil, 0)
+	return s
+}
+
+func TestBatchSummarize(t *testing.T) {
+	usage := inference.Usage{PromptTokens: 100, CompletionTokens: 20}
+	inf := fake.New(
+		fake.Response{Match: "Forbidden passage", Kind: "content_refused", Error: "policy"},
+		fake.Response{Match: "Ada", Content: summaryJSON, Usage: usage},
+	)
+	s := newBatchServer(t, inf)
+
+	rec := post(t, s, "/api/batch/summarize", map[string]any{"stories": []map[string]any{
+		{
+			"id":         "1",
+			"source":     "ao3",
+			"chapter":    "c1",
+			"paragraphs": map[string]string{"1": "Ada met Babbage at a party."},
+			"characters": []schema.Character{{Name: "Babbage", Kind: "major"}},
+		},
+		{"id": "2", "paragraphs": map[string]string{"1": "Forbidden passage."}},
+	}})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var job BatchJob
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Provider != "openai" || len(job.Chunks) != 2 {
+		t.Fatalf("job = %+v", job)
+	}
+	if seed := job.Stories[0].Seed; seed == nil || seed.Characters[0].Name != "Babbage" {
+		t.Errorf("seed = %+v, want the characters sent with the new story", seed)
+	}
+
+	// The first poll sees the batch in progress; the second merges it while a summarize request
+	// runs, which the race detector checks against the stored summaries.
+	ctx := context.Background()
+	s.pollBatches(ctx)
+	var wg sync.WaitGroup
+	wg.Go(func() { s.pollBatches(ctx) })
+	wg.Go(func() {
+		post(t, s, "/api/summarize", map[string]any{"id": "3", "paragraphs": map[string]string{"1": "Ada again."}})
+	})
+	wg.Wait()
+
+	s.batchesMu.Lock()
+	merged := s.Batches[job.ID]
+	s.batchesMu.Unlock()
+	if merged == nil || !merged.Merged {
+		t.Fatalf("batch not merged: %+v", merged)
+	}
+	summary, ok := s.storedSummary("ao3:1")
+	if !ok {
+		t.Fatal("no summary stored for the batched story")
+	}
+	var names []string
+	for _, c := range summary.Characters {
+		names = append(names, c.Name)
+	}
+	if !slices.Contains(names, "Ada") || !slices.Contains(names, "Babbage") {
+		t.Errorf("characters = %v, want the extracted Ada merged with the seeded Babbage", names)
+	}
+	if !summary.Chapters["c1"] || merged.Stories[0].Characters != len(summary.Characters) {
+		t.Errorf("chapters = %v, counted %d characters", summary.Chapters, merged.Stories[0].Characters)
+	}
+	if _, ok := s.storedSummary("3"); !ok {
+		t.Error("the concurrent summarize request was lost")
+	}
+
+	if len(merged.Errors) != 1 {
+		t.Errorf("errors = %v, want the refused chunk", merged.Errors)
+	}
+	var forbidden bool
+	for id, f := range s.Forbids {
+		forbidden = forbidden || strings.Contains(id, ":2 chapter:") && strings.Contains(f.Text, "Forbidden passage")
+	}
+	if !forbidden {
+		t.Errorf("forbids = %+v, want the refused chunk recorded", s.Forbids)
+	}
+
+	providers := s.Ledger.Snapshot().Providers
+	if got := providers["openai/fake-model"]; got == nil || got.Usage != usage {
+		t.Errorf("ledger providers = %v, want the batch usage under openai", providers)
+	}
+	if _, ok := providers["batch/fake-model"]; ok {
+		t.Error("batch usage recorded under a placeholder provider")
+	}
+}
+
+func TestBatchSummarizeUnsupported(t *testing.T) {
+	s := newTestServer(t, fake.New())
+	s.Batcher = nil
+	rec := post(t, s, "/api/batch/summarize", map[string]any{"stories": []map[string]any{{"id": "1", "text": "Ada."}}})
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want 501", rec.Code)
+	}
+}
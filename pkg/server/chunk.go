@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"paige/pkg/schema"
+	"paige/pkg/utils"
+)
+
+// minChunkRunes is the smallest chunk the summarize loop will split a failing chunk into.
+const minChunkRunes = 2048
+
+// Chunk budgets in tokens. The upper bound keeps long-context models from summarizing
+// too much story at once, which loses detail.
+const (
+	minChunkTokens = 512
+	maxChunkTokens = 8192
+)
+
+// chunkBudget returns how many story tokens fit in one summarize call. The model echoes the
+// summary context back in its output, so the context is counted twice, and the remaining
+// room is shared evenly between the chunk and the details extracted from it.
+func (s *Server) chunkBudget(systemTokens, contextTokens int) int {
+	return chunkBudgetFor(s.ContextWindow, systemTokens, contextTokens)
+}
+
+// chunkBudgetFor is chunkBudget for a model with the given context window.
+func chunkBudgetFor(window, systemTokens, contextTokens int) int {
+	free := window - systemTokens - 2*contextTokens
+	return min(max(free/2, minChunkTokens), maxChunkTokens)
+}
+
+// summaryChunk is a slice of the story sent in a single summarize call.
+// Chunks produced by splitting keep the Index of their origin and record the split in Part.
+type summaryChunk struct {
+	Index int
+	// Part is empty for original chunks and ".1", ".2.1", ... for halves.
+	Part string
+	// Depth is the number of times the chunk was split; it also selects how compact the appended context is.
+	Depth int
+
+	Paragraphs []utils.Paragraph
+	Text       string
+}
+
+// chunkRequest splits the request into chunks of at most limit tokens.
+func chunkRequest(req summarizeReq, limit int, tok utils.Tokenizer) []summaryChunk {
+	var chunks []summaryChunk
+	if len(req.Paragraphs) > 0 {
+		for i, paragraphs := range utils.ChunkParagraphFunc(req.Paragraphs, limit, tok.Count) {
+			if len(paragraphs) == 0 {
+				break
+			}
+			chunks = append(chunks, summaryChunk{Index: i, Paragraphs: paragraphs})
+		}
+	} else if len(req.Text) > 0 {
+		for i, text := range utils.ChunkTokens(req.Text, limit, tok) {
+			chunks = append(chunks, summaryChunk{Index: i, Text: text})
+		}
+	}
+	return chunks
+}
+
+// String renders the chunk as sent to the model: a JSON object of paragraph index to text, or plain text.
+func (c summaryChunk) String() string {
+	if len(c.Paragraphs) == 0 {
+		return c.Text
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for j, paragraph := range c.Paragraphs {
+		if j > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.Quote(strconv.Itoa(paragraph.Index)))
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Quote(paragraph.Text))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func (c summaryChunk) runes() int {
+	if len(c.Paragraphs) == 0 {
+		return utf8.RuneCountInString(c.Text)
+	}
+	var n int
+	for _, paragraph := range c.Paragraphs {
+		n += utf8.RuneCountInString(paragraph.Text)
+	}
+	return n
+}
+
+// split halves the chunk, reporting false once the halves would fall below minRunes.
+// Paragraph chunks split between paragraphs; a single oversized paragraph is split by text and keeps its index.
+func (c summaryChunk) split(minRunes int) ([]summaryChunk, bool) {
+	size := c.runes()
+	if size/2 < minRunes {
+		return nil, false
+	}
+
+	var parts []summaryChunk
+	switch {
+	case len(c.Paragraphs) > 1:
+		half := len(c.Paragraphs) / 2
+		parts = []summaryChunk{{Paragraphs: c.Paragraphs[:half]}, {Paragraphs: c.Paragraphs[half:]}}
+	case len(c.Paragraphs) == 1:
+		p := c.Paragraphs[0]
+		for _, text := range utils.ChunkText(p.Text, size/2+1) {
+			parts = append(parts, summaryChunk{Paragraphs: []utils.Paragraph{{Index: p.Index, Text: text}}})
+		}
+	default:
+		for _, text := range utils.ChunkText(c.Text, size/2+1) {
+			parts = append(parts, summaryChunk{Text: text})
+		}
+	}
+	if len(parts) < 2 {
+		return nil, false
+	}
+
+	for j := range parts {
+		parts[j].Index = c.Index
+		parts[j].Part = c.Part + "." + strconv.Itoa(j+1)
+		parts[j].Depth = c.Depth + 1
+	}
+	return parts, true
+}
+
+// Limits on the summary context appended to each chunk.
+const (
+	contextDates       = 5
+	contextCompactDate = 3
+	contextActions     = 3
+)
+
+// summaryContext renders the part of the accumulated summary relevant to chunk: main
+// characters, characters whose name or aliases appear in the chunk, and the most recent
+// timeline dates. Results are merged back into the full summary, so omitted entries are kept.
+// Once a chunk has been split, notable actions are trimmed and fewer dates are sent.
+func summaryContext(summary schema.Summary, chunk string, depth int) ([]byte, error) {
+	lower := strings.ToLower(chunk)
+	context := schema.Summary{Characters: []schema.Character{}, Timeline: recentTimeline(summary.Timeline, contextDates)}
+	for _, char := range summary.Characters {
+		if !strings.EqualFold(char.Kind, "main") && !mentioned(lower, char) {
+			continue
+		}
+		if depth > 0 && len(char.NotableActions) > contextActions {
+			char.NotableActions = char.NotableActions[len(char.NotableActions)-contextActions:]
+		}
+		context.Characters = append(context.Characters, char)
+	}
+	if depth > 0 {
+		context.Timeline = recentTimeline(context.Timeline, contextCompactDate)
+	}
+	return json.Marshal(context)
+}
+
+// mentioned reports whether the character's name, first name or an alias appears as a whole word in lower.
+func mentioned(lower string, char schema.Character) bool {
+	names := append([]string{char.Name}, char.Aliases...)
+	if first := firstName(char.Name); first != "" {
+		names = append(names, first)
+	}
+	for _, name := range names {
+		if name = strings.ToLower(strings.TrimSpace(name)); len(name) >= 2 && containsWord(lower, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// titles are leading words that do not identify a character on their own.
+var titles = map[string]bool{
+	"the": true, "a": true, "an": true, "old": true, "young": true, "little": true,
+	"mr": true, "mrs": true, "ms": true, "miss": true, "mx": true, "sir": true, "dame": true, "madam": true,
+	"dr": true, "doctor": true, "prof": true, "professor": true, "lord": true, "lady": true,
+	"king": true, "queen": true, "prince": true, "princess": true, "captain": true, "capt": true,
+	"st": true, "saint": true, "father": true, "mother": true, "sister": true, "brother": true,
+	"aunt": true, "uncle": true,
+}
+
+// firstName returns the first word of a multi-word name after any articles and honorifics, so
+// "Dr. Jane Foster" gives "Jane" and "Mr. Smith" gives "Smith", but "The Doctor" gives nothing.
+func firstName(name string) string {
+	words := strings.Fields(name)
+	for i, w := range words {
+		if !titles[strings.ToLower(strings.TrimRight(w, "."))] {
+			if i == 0 && len(words) == 1 {
+				return ""
+			}
+			return w
+		}
+	}
+	return ""
+}
+
+func containsWord(text, word string) bool {
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], word)
+		if i == -1 {
+			return false
+		}
+		start, end := offset+i, offset+i+len(word)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (start == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after)) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// recentTimeline returns the latest n dates of timeline in chronological order.
+// Dates that do not parse as "January 2, 2006" keep their position relative to each other and sort first.
+func recentTimeline(timeline []schema.Timeline, n int) []schema.Timeline {
+	if len(timeline) <= n {
+		return timeline
+	}
+	sorted := slices.Clone(timeline)
+	slices.SortStableFunc(sorted, func(a, b schema.Timeline) int {
+		return timelineDate(a.Date).Compare(timelineDate(b.Date))
+	})
+	return sorted[len(sorted)-n:]
+}
+
+func timelineDate(date string) time.Time {
+	t, _ := time.Parse("January 2, 2006", strings.TrimSpace(date))
+	return t
+}
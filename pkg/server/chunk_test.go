@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"paige/pkg/schema"
+)
+
+func TestMentioned(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       bool
+	}{
+		{"Jane Foster", "Jane waved.", true},
+		{"Dr. Jane Foster", "Jane waved.", true},
+		{"Mr. Smith", "Smith nodded.", true},
+		{"Mr. Smith", "Mr. Jones nodded.", false},
+		{"The Doctor", "The ship landed.", false},
+		{"The Doctor", "The Doctor landed.", true},
+		{"Zoë Ångström", "Zoë smiled.", true},
+		{"Zoë Ångström", "Zoëlle smiled.", false},
+		{"Ana", "Banana bread.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.text, func(t *testing.T) {
+			if got := mentioned(strings.ToLower(tt.text), schema.Character{Name: tt.name}); got != tt.want {
+				t.Errorf("mentioned = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummaryContextCompactsOnSplit(t *testing.T) {
+	summary := schema.Summary{
+		Characters: []schema.Character{
+			{Name: "Jane Foster", Kind: "main", NotableActions: []string{"a", "b", "c", "d", "e"}},
+			{Name: "Thor", Kind: "minor"},
+			{Name: "Loki", Kind: "minor"},
+		},
+	}
+	for _, date := range []string{"June 1, 2009", "June 2, 2009", "June 3, 2009", "June 4, 2009", "June 5, 2009", "June 6, 2009"} {
+		summary.Timeline = append(summary.Timeline, schema.Timeline{Date: date})
+	}
+
+	tests := []struct {
+		depth          int
+		actions, dates int
+		firstAction    string
+		firstDate      string
+	}{
+		{depth: 0, actions: 5, dates: contextDates, firstAction: "a", firstDate: "June 2, 2009"},
+		{depth: 1, actions: contextActions, dates: contextCompactDate, firstAction: "c", firstDate: "June 4, 2009"},
+		{depth: 2, actions: contextActions, dates: contextCompactDate, firstAction: "c", firstDate: "June 4, 2009"},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.depth), func(t *testing.T) {
+			bin, err := summaryContext(summary, "Thor arrived.", tt.depth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got schema.Summary
+			if err := json.Unmarshal(bin, &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Characters) != 2 || got.Characters[0].Name != "Jane Foster" || got.Characters[1].Name != "Thor" {
+				t.Fatalf("characters = %+v, want Jane Foster and Thor", got.Characters)
+			}
+			if actions := got.Characters[0].NotableActions; len(actions) != tt.actions || actions[0] != tt.firstAction {
+				t.Errorf("notable actions = %v, want %d from %q", actions, tt.actions, tt.firstAction)
+			}
+			if len(got.Timeline) != tt.dates || got.Timeline[0].Date != tt.firstDate {
+				t.Errorf("timeline = %+v, want %d dates from %s", got.Timeline, tt.dates, tt.firstDate)
+			}
+		})
+	}
+	if len(summary.Characters[0].NotableActions) != 5 {
+		t.Error("summaryContext modified the summary")
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/labstack/echo/v4"
+
+	"paige/pkg/diff"
+	"paige/pkg/inference"
+	"paige/pkg/prompt"
+	"paige/pkg/schema"
+	"paige/pkg/utils"
+)
+
+// ComparedModel is a model offered to /api/compare.
+type ComparedModel struct {
+	// Name labels the model in results, e.g. "openai:gpt-4o-mini".
+	Name       string
+	Inferencer inference.Inferencer
+	// Model overrides the inferencer's default model; empty keeps it.
+	Model string
+}
+
+// contextWindow returns the context size of the model m runs.
+func (m ComparedModel) contextWindow() int {
+	if m.Model != "" {
+		return inference.ContextWindowFor(m.Model)
+	}
+	return inference.ContextWindowOf(m.Inferencer)
+}
+
+func (m ComparedModel) tokenizer() utils.Tokenizer {
+	return utils.TokenizerForModel(cmp.Or(m.Model, inference.ModelOf(m.Inferencer)))
+}
+
+// CompareRequest is a chapter summarized by every compared model against the same context.
+type CompareRequest struct {
+	Text       string             `json:"text"`
+	ID         string             `json:"id,omitempty"`
+	Source     string             `json:"source,omitempty"`
+	Chapter    string             `json:"chapter,omitempty"`
+	Characters []schema.Character `json:"characters"`
+	Timeline   []schema.Timeline  `json:"timeline"`
+	// Models selects compared models by name; empty compares all of them.
+	Models []string `json:"models,omitempty"`
+}
+
+// CompareRun is one model's summary of a CompareRequest.
+type CompareRun struct {
+	Name string `json:"name"`
+	// Model is the model that answered, as reported by the provider.
+	Model   string          `json:"model,omitempty"`
+	Summary *schema.Summary `json:"summary,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	// LatencyMS is the wall time of the whole run in milliseconds.
+	LatencyMS int64 `json:"latency_ms"`
+	Chunks    int   `json:"chunks"`
+	// Calls counts every call including repairs, and Cached those served from the response cache.
+	Calls  int             `json:"calls"`
+	Cached int             `json:"cached"`
+	Usage  inference.Usage `json:"usage"`
+	Cost   float64         `json:"cost"`
+}
+
+// CompareDiff is the difference between the baseline run and another run.
+type CompareDiff struct {
+	Base              string           `json:"base"`
+	Other             string           `json:"other"`
+	ChangedCharacters int              `json:"changed_characters"`
+	ChangedEvents     int              `json:"changed_events"`
+	Diff              diff.SummaryDiff `json:"diff"`
+}
+
+// CompareResponse holds every run, in the order the models were configured, and the diffs of
+// the first successful run against every other successful run.
+type CompareResponse struct {
+	Runs  []CompareRun  `json:"runs"`
+	Diffs []CompareDiff `json:"diffs"`
+}
+
+// ErrCompareModels is returned when fewer than two models are selected.
+var ErrCompareModels = errors.New("compare needs at least two configured models")
+
+// POST /api/compare
+func (s *Server) handlePostCompare(c echo.Context) error {
+	var req CompareRequest
+	if err := c.Bind(&req); err != nil {
+		log.Warn("invalid JSON in /api/compare", "error", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid json")
+	}
+	if s.Ledger != nil && s.Ledger.OverBudget() {
+		return echo.NewHTTPError(http.StatusTooManyRequests, "daily usage budget exceeded")
+	}
+	resp, err := s.Compare(c.Request().Context(), req)
+	switch {
+	case errors.Is(err, ErrCompareModels):
+		return echo.NewHTTPError(http.StatusNotImplemented, err.Error())
+	case err != nil:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Compare summarizes req with every selected model concurrently. Each model runs the chunks
+// of the chapter in order, carrying its own summary from chunk to chunk as sequential
+// summarization does, starting from the stored summary or the context sent with the request.
+// Every model gets the same chunks, planned against the smallest context window among them.
+func (s *Server) Compare(ctx context.Context, req CompareRequest) (CompareResponse, error) {
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" {
+		return CompareResponse{}, errors.New("no text to compare")
+	}
+	if req.Source != "" && req.ID != "" {
+		req.ID = req.Source + ":" + req.ID
+	}
+	var models []ComparedModel
+	for _, m := range s.CompareModels {
+		if len(req.Models) == 0 || slices.Contains(req.Models, m.Name) {
+			models = append(models, m)
+		}
+	}
+	if len(models) < 2 {
+		return CompareResponse{}, ErrCompareModels
+	}
+
+	story := summarizeReq{Text: req.Text, ID: req.ID, Source: req.Source, Chapter: req.Chapter, Characters: req.Characters, Timeline: req.Timeline}
+	smallest := slices.MinFunc(models, func(a, b ComparedModel) int { return cmp.Compare(a.contextWindow(), b.contextWindow()) })
+	base := s.baseSummary(story)
+	systemPrompt, _, chunks := s.planSummaryFor(story, base, smallest.contextWindow(), smallest.tokenizer())
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return CompareResponse{}, err
+	}
+	log.Info("comparing models", "models", len(models), "chunks", len(chunks), "context_window", smallest.contextWindow())
+
+	resp := CompareResponse{Runs: make([]CompareRun, len(models))}
+	var wg sync.WaitGroup
+	for i, m := range models {
+		wg.Go(func() {
+			// Runs merge into their own copy, since merging modifies the summary in place.
+			var summary schema.Summary
+			_ = json.Unmarshal(baseJSON, &summary)
+			resp.Runs[i] = s.compareRun(ctx, m, story, systemPrompt, chunks, summary)
+		})
+	}
+	wg.Wait()
+
+	var first *CompareRun
+	for i, run := range resp.Runs {
+		if run.Summary == nil {
+			continue
+		}
+		if first == nil {
+			first = &resp.Runs[i]
+			continue
+		}
+		d := diff.Summaries(*first.Summary, *run.Summary)
+		chars, events := diffChanges(d)
+		resp.Diffs = append(resp.Diffs, CompareDiff{Base: first.Name, Other: run.Name, ChangedCharacters: chars, ChangedEvents: events, Diff: d})
+	}
+	return resp, nil
+}
+
+// compareRun summarizes the chunks with one model. A failed chunk ends the run with its error.
+func (s *Server) compareRun(ctx context.Context, m ComparedModel, req summarizeReq, systemPrompt string, chunks []summaryChunk, summary schema.Summary) (run CompareRun) {
+	run = CompareRun{Name: m.Name, Chunks: len(chunks)}
+	account := func(res inference.Result) {
+		if res.Model == "" && res.Usage == (inference.Usage{}) {
+			return
+		}
+		run.Calls++
+		if res.Cached {
+			run.Cached++
+		}
+		run.Model = res.Model
+		run.Usage.Add(res.Usage)
+		if s.Ledger != nil && !res.Cached {
+			price, _ := s.Ledger.Prices.Lookup(res.Model)
+			run.Cost += price.Cost(res.Usage)
+		}
+	}
+
+	start := time.Now()
+	defer func() { run.LatencyMS = time.Since(start).Milliseconds() }()
+	for _, part := range chunks {
+		chunk := part.String()
+		var summaryJSON []byte
+		if len(summary.Characters) > 0 || len(summary.Timeline) > 0 {
+			bin, err := summaryContext(summary, chunk, part.Depth)
+			if err != nil {
+				run.Error = "failed preparing summarization context: " + err.Error()
+				return run
+			}
+			summaryJSON = bin
+		}
+		prefix := contextPrefix(summaryJSON)
+		user := prefix + chunk
+		params := summarizeParamsFor(m.contextWindow(), m.tokenizer(), systemPrompt, user)
+		params.Model = m.Model
+
+		chunkCtx := inference.WithScope(ctx, inference.Scope{Task: inference.TaskSummarize, Story: req.ID, Chunk: part.Index})
+		chunkCtx = inference.WithCachePrefix(chunkCtx, prefix)
+		res, err := m.Inferencer.Infer(chunkCtx, params, systemPrompt, user)
+		account(res)
+		if errors.Is(err, inference.ErrTruncated) && res.Content != "" {
+			err = nil
+		}
+		if err != nil {
+			log.Warn("compared model failed", "model", m.Name, "chunk", part.Index+1, "error", err)
+			run.Error = err.Error()
+			return run
+		}
+		hasCharacters := func(v schema.Summary) []inference.Violation { return requireItems("$.characters", len(v.Characters)) }
+		parsed, err := decodeRepaired(chunkCtx, s, inference.TaskSummarize, res, hasCharacters, func(ctx context.Context, out string, violations []inference.Violation) (inference.Result, error) {
+			fixed, err := m.Inferencer.Infer(ctx, params, systemPrompt, user+"\n\n"+s.repairRequest(prompt.VariantOf(req.Source, req.ID), out, violations))
+			account(fixed)
+			return fixed, err
+		})
+		if err != nil {
+			run.Error = err.Error()
+			return run
+		}
+		mergeSummary(&summary, parsed, req.Chapter)
+	}
+	run.Summary = &summary
+	return run
+}
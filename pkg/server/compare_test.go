@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"paige/pkg/inference"
+	"paige/pkg/inference/fake"
+)
+
+// windowed reports a fixed context window for a fake inferencer.
+type windowed struct {
+	*fake.Inferencer
+	window int
+}
+
+func (w windowed) ContextWindow() int {
+	return w.window
+}
+
+func (w windowed) Unwrap() inference.Inferencer {
+	return w.Inferencer
+}
+
+func TestCompare(t *testing.T) {
+	large := fake.New(fake.Response{Task: inference.TaskSummarize, Content: summaryJSON})
+	small := fake.New(fake.Response{Task: inference.TaskSummarize, Content: summaryJSON})
+	s := newTestServer(t, large)
+	s.CompareModels = []ComparedModel{
+		{Name: "large", Inferencer: windowed{large, 1 << 20}},
+		{Name: "small", Inferencer: windowed{small, 6000}},
+	}
+
+	text := strings.Repeat("Ada walked through the garden and talked with Babbage about the engine. ", 300)
+	rec := post(t, s, "/api/compare", map[string]any{"text": text, "id": "1"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp CompareResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Runs) != 2 || len(resp.Diffs) != 1 {
+		t.Fatalf("runs, diffs = %+v, %+v", resp.Runs, resp.Diffs)
+	}
+	// Both models get the chunks that fit the smaller window, so the runs stay comparable.
+	largeRun, smallRun := resp.Runs[0], resp.Runs[1]
+	if largeRun.Error != "" || smallRun.Error != "" || largeRun.Summary == nil || smallRun.Summary == nil {
+		t.Fatalf("runs = %+v", resp.Runs)
+	}
+	if largeRun.Chunks < 2 || largeRun.Chunks != smallRun.Chunks {
+		t.Errorf("chunks = %d and %d, want the same split for both models", largeRun.Chunks, smallRun.Chunks)
+	}
+	if n := len(large.Calls()); n != largeRun.Chunks {
+		t.Errorf("large model calls = %d, want one per chunk", n)
+	}
+	for _, call := range small.Calls() {
+		if tokens := s.Tokenizer.Count(call.System + call.User); tokens > 6000 {
+			t.Errorf("small model got a %d token prompt, above its window", tokens)
+		}
+	}
+	if _, ok := s.storedSummary("1"); ok {
+		t.Error("compare stored a summary")
+	}
+}
+
+func TestCompareNeedsTwoModels(t *testing.T) {
+	s := newTestServer(t, fake.New())
+	s.CompareModels = []ComparedModel{{Name: "only", Inferencer: fake.New()}}
+	if rec := post(t, s, "/api/compare", map[string]any{"text": "Ada."}); rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want 501", rec.Code)
+	}
+}
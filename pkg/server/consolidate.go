@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/labstack/echo/v4"
+	"github.com/openai/openai-go/v3"
+
+	"paige/pkg/diff"
+	"paige/pkg/inference"
+	"paige/pkg/prompt"
+	"paige/pkg/schema"
+	"paige/pkg/utils"
+)
+
+// maxNotableActions matches the 3-5 actions the summarize prompt asks for.
+const maxNotableActions = 5
+
+type consolidateReq struct {
+	ID     string `json:"id"`
+	Source string `json:"source,omitempty"`
+	// DryRun returns the consolidated summary and diff without saving it.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+type consolidateResp struct {
+	Summary schema.Summary   `json:"summary"`
+	Diff    diff.SummaryDiff `json:"diff"`
+	Saved   bool             `json:"saved"`
+}
+
+// POST /api/consolidate
+func (s *Server) handlePostConsolidate(c echo.Context) error {
+	var req consolidateReq
+	if err := c.Bind(&req); err != nil {
+		log.Warn("invalid JSON in /api/consolidate", "error", err)
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid json")
+	}
+	if req.Source != "" && req.ID != "" {
+		req.ID = req.Source + ":" + req.ID
+	}
+	summary, ok := s.storedSummary(req.ID)
+	if !ok || len(summary.Characters) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "no summary for id")
+	}
+
+	consolidated, d, err := s.consolidateSummary(c.Request().Context(), req.ID, summary)
+	if err != nil {
+		log.Warn("consolidation failed", "id", req.ID, "error", err)
+		var invalid *summaryError
+		if errors.As(err, &invalid) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+		return echo.NewHTTPError(inferenceStatus(err), "consolidation failed: "+err.Error())
+	}
+
+	if !req.DryRun {
+		if err := s.replaceSummary(req.ID, summary, consolidated); errors.Is(err, errSummaryChanged) {
+			return echo.NewHTTPError(http.StatusConflict, "summary changed during consolidation, retry")
+		} else if err != nil {
+			log.Warn("failed saving summary data after consolidation", "error", err)
+		}
+	}
+	return c.JSON(http.StatusOK, consolidateResp{Summary: consolidated, Diff: d, Saved: !req.DryRun})
+}
+
+// needsConsolidation reports whether summary shows the duplication that accumulates over many chunks:
+// characters with more notable actions than the prompt allows, or near-duplicate character names.
+func needsConsolidation(summary schema.Summary) bool {
+	for i, a := range summary.Characters {
+		if len(a.NotableActions) > maxNotableActions {
+			return true
+		}
+		for _, b := range summary.Characters[i+1:] {
+			if utils.Similarity(a.Name, b.Name) >= 0.8 || sharesName(a, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sharesName reports whether either character lists the other's name as an alias.
+func sharesName(a, b schema.Character) bool {
+	for _, alias := range a.Aliases {
+		if strings.EqualFold(strings.TrimSpace(alias), b.Name) {
+			return true
+		}
+	}
+	for _, alias := range b.Aliases {
+		if strings.EqualFold(strings.TrimSpace(alias), a.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// consolidateSummary asks the model to canonicalize characters, rank and cap notable actions and
+// merge duplicate events. The result is validated and diffed against summary; fields the model
+// does not see, such as heat and edits, are carried over.
+func (s *Server) consolidateSummary(ctx context.Context, id string, summary schema.Summary) (schema.Summary, diff.SummaryDiff, error) {
+	bin, err := json.Marshal(schema.Summary{Characters: summary.Characters, Timeline: summary.Timeline})
+	if err != nil {
+		return summary, diff.SummaryDiff{}, fmt.Errorf("failed preparing consolidation input: %w", err)
+	}
+
+	ctx = inference.WithScope(ctx, inference.Scope{Task: inference.TaskConsolidate, Story: id})
+	variant := prompt.VariantOf("", id)
+	system := s.renderPrompt(prompt.Consolidate, variant, prompt.Data{Source: variant.Source, Story: variant.Story})
+	tokenCount := s.Tokenizer.Count(system + string(bin))
+	params := &openai.ChatCompletionNewParams{
+		// Like summarizeParams, the output budget is capped by what is left of the context window.
+		MaxCompletionTokens: openai.Int(min(max(int64(s.Tokenizer.Count(string(bin)))*2, 4096), max(int64(s.ContextWindow-tokenCount), 1024))),
+		ResponseFormat:      schema.ResponseFormatFor[schema.Summary](),
+	}
+	res, err := s.Inferencer.Infer(ctx, params, system, string(bin))
+	if err != nil {
+		return summary, diff.SummaryDiff{}, err
+	}
+
+	parsed, err := decodeRepaired[schema.Summary](ctx, s, inference.TaskConsolidate, res, nil, func(ctx context.Context, out string, violations []inference.Violation) (inference.Result, error) {
+		return s.Inferencer.Infer(ctx, params, system, string(bin)+"\n\n"+s.repairRequest(variant, out, violations))
+	})
+	if err != nil {
+		return summary, diff.SummaryDiff{}, &summaryError{Problems: []string{"invalid JSON: " + err.Error()}}
+	}
+
+	consolidated := summary
+	consolidated.Characters = dedupeByName(parsed.Characters)
+	for i := range consolidated.Characters {
+		if actions := consolidated.Characters[i].NotableActions; len(actions) > maxNotableActions {
+			// The prompt asks for actions ranked by significance, so the first ones are kept.
+			consolidated.Characters[i].NotableActions = actions[:maxNotableActions]
+		}
+	}
+	consolidated.Timeline = mergeTimelines(nil, parsed.Timeline)
+
+	if err := validateConsolidation(summary, consolidated); err != nil {
+		s.forget(res)
+		return summary, diff.SummaryDiff{}, err
+	}
+
+	d := diff.Summaries(summary, consolidated)
+	var buf bytes.Buffer
+	d.Print(&buf)
+	log.Debug("consolidated summary", "id", id, "chars_before", len(summary.Characters), "chars_after", len(consolidated.Characters), "diff", "\n"+buf.String())
+	return consolidated, d, nil
+}
+
+// diffChanges counts the characters and events that d reports as added, removed or modified.
+func diffChanges(d diff.SummaryDiff) (chars, events int) {
+	for _, c := range d.Characters {
+		if c.State != diff.Unchanged {
+			chars++
+		}
+	}
+	for _, e := range d.Events {
+		if e.State != diff.Unchanged {
+			events++
+		}
+	}
+	return chars, events
+}
+
+// summaryError lists the problems that made a model-produced summary unusable.
+type summaryError struct {
+	Problems []string
+}
+
+func (e *summaryError) Error() string {
+	return "invalid summary: " + strings.Join(e.Problems, "; ")
+}
+
+// validateConsolidation checks the consolidated summary against the schema's constraints and
+// makes sure no character was dropped: every previous name must survive as a name or alias.
+func validateConsolidation(before, after schema.Summary) error {
+	var problems []string
+	if len(after.Characters) == 0 {
+		problems = append(problems, "no characters")
+	}
+
+	known := make(map[string]bool)
+	for i, char := range after.Characters {
+		if strings.TrimSpace(char.Name) == "" {
+			problems = append(problems, fmt.Sprintf("characters[%d] has no name", i))
+			continue
+		}
+		switch strings.ToLower(char.Kind) {
+		case "main", "major", "minor", "":
+		default:
+			problems = append(problems, fmt.Sprintf("%s has invalid kind %q", char.Name, char.Kind))
+		}
+		known[strings.ToLower(strings.TrimSpace(char.Name))] = true
+		for _, alias := range char.Aliases {
+			known[strings.ToLower(strings.TrimSpace(alias))] = true
+		}
+	}
+	for _, char := range before.Characters {
+		if name := strings.ToLower(strings.TrimSpace(char.Name)); name != "" && !known[name] {
+			problems = append(problems, fmt.Sprintf("character %s was dropped", char.Name))
+		}
+	}
+
+	for _, t := range after.Timeline {
+		if strings.TrimSpace(t.Date) == "" {
+			problems = append(problems, "timeline entry without date")
+		}
+		for _, e := range t.Events {
+			if strings.TrimSpace(e.Description) == "" {
+				problems = append(problems, fmt.Sprintf("event on %s without description", t.Date))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &summaryError{Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+
+	"paige/pkg/inference"
+	"paige/pkg/inference/fake"
+	"paige/pkg/schema"
+)
+
+func TestNeedsConsolidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		chars []schema.Character
+		want  bool
+	}{
+		{"distinct", []schema.Character{{Name: "Ada"}, {Name: "Babbage"}}, false},
+		{"too many actions", []schema.Character{{Name: "Ada", NotableActions: []string{"a", "b", "c", "d", "e", "f"}}}, true},
+		{"action cap", []schema.Character{{Name: "Ada", NotableActions: []string{"a", "b", "c", "d", "e"}}}, false},
+		{"near-duplicate names", []schema.Character{{Name: "Ada Lovelace"}, {Name: "Ada Lovelac"}}, true},
+		{"alias of another", []schema.Character{{Name: "Ada", Aliases: []string{"the Countess"}}, {Name: "The Countess"}}, true},
+		{"alias listed by the later character", []schema.Character{{Name: "Ada"}, {Name: "Countess", Aliases: []string{" ada "}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := needsConsolidation(schema.Summary{Characters: tt.chars}); got != tt.want {
+				t.Errorf("needsConsolidation = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConsolidation(t *testing.T) {
+	before := schema.Summary{Characters: []schema.Character{{Name: "Ada"}, {Name: "The Countess"}, {Name: "Babbage"}}}
+	tests := []struct {
+		name    string
+		after   schema.Summary
+		problem string
+	}{
+		{"kept", schema.Summary{Characters: []schema.Character{{Name: "Ada"}, {Name: "The Countess"}, {Name: "Babbage"}}}, ""},
+		{"merged into alias", schema.Summary{Characters: []schema.Character{{Name: "Ada", Aliases: []string{"the countess"}}, {Name: "babbage", Kind: "Major"}}}, ""},
+		{"dropped character", schema.Summary{Characters: []schema.Character{{Name: "Ada", Aliases: []string{"The Countess"}}}}, "character Babbage was dropped"},
+		{"no characters", schema.Summary{}, "no characters"},
+		{"unnamed character", schema.Summary{Characters: []schema.Character{{Name: "Ada", Aliases: []string{"The Countess", "Babbage"}}, {Name: " "}}}, "characters[1] has no name"},
+		{"invalid kind", schema.Summary{Characters: []schema.Character{{Name: "Ada", Kind: "hero", Aliases: []string{"The Countess", "Babbage"}}}}, `Ada has invalid kind "hero"`},
+		{"undated timeline", schema.Summary{
+			Characters: before.Characters,
+			Timeline:   []schema.Timeline{{Date: "", Events: []schema.Event{{Description: "Met."}}}},
+		}, "timeline entry without date"},
+		{"event without description", schema.Summary{
+			Characters: before.Characters,
+			Timeline:   []schema.Timeline{{Date: "June 1, 1833", Events: []schema.Event{{Description: " "}}}},
+		}, "event on June 1, 1833 without description"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConsolidation(before, tt.after)
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("validateConsolidation = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Fatalf("validateConsolidation = %v, want %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+// hooked runs before on every Infer call, then delegates to the wrapped inferencer.
+type hooked struct {
+	*fake.Inferencer
+	before func()
+}
+
+func (h hooked) Infer(ctx context.Context, params *openai.ChatCompletionNewParams, system, user string) (inference.Result, error) {
+	h.before()
+	return h.Inferencer.Infer(ctx, params, system, user)
+}
+
+func TestConsolidateConflict(t *testing.T) {
+	inf := fake.New(fake.Response{Task: inference.TaskConsolidate, Content: `{"characters":[{"name":"Ada","aliases":["The Countess"]}],"timeline":[]}`})
+	var s *Server
+	merged := schema.Summary{Characters: []schema.Character{{Name: "Ada"}, {Name: "The Countess"}, {Name: "Babbage"}}}
+	s = newTestServer(t, hooked{inf, func() {
+		// A summarize run for the same story finishes while consolidation is in flight.
+		if err := s.storeSummary("1", merged); err != nil {
+			t.Error(err)
+		}
+	}})
+	if err := s.storeSummary("1", schema.Summary{Characters: []schema.Character{{Name: "Ada"}, {Name: "The Countess"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := post(t, s, "/api/consolidate", map[string]string{"id": "1"})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", rec.Code, rec.Body)
+	}
+	if got, _ := s.storedSummary("1"); len(got.Characters) != 3 {
+		t.Errorf("stored characters = %+v, want the concurrent update kept", got.Characters)
+	}
+}
+
+func TestConsolidate(t *testing.T) {
+	inf := fake.New(fake.Response{Task: inference.TaskConsolidate, Content: `{"characters":[{"name":"Ada","aliases":["The Countess"]}],"timeline":[]}`})
+	s := newTestServer(t, inf)
+	if err := s.storeSummary("1", schema.Summary{Characters: []schema.Character{{Name: "Ada"}, {Name: "The Countess"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := post(t, s, "/api/consolidate", map[string]string{"id": "1"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got, _ := s.storedSummary("1"); len(got.Characters) != 1 || got.Characters[0].Name != "Ada" {
+		t.Errorf("stored characters = %+v, want Ada only", got.Characters)
+	}
+}
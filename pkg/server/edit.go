@@ -11,8 +11,9 @@ import (
 	"github.com/openai/openai-go/v3"
 	"github.com/segmentio/ksuid"
 
+	"paige/pkg/inference"
+	"paige/pkg/prompt"
 	"paige/pkg/schema"
-	"paige/pkg/utils"
 )
 
 type editReq struct {
@@ -48,27 +49,30 @@ func (s *Server) handlePostEdit(c echo.Context) error {
 		req.Selection = string(runes[:maxEditSelectionRunes])
 	}
 
-	ctx := c.Request().Context()
+	ctx := inference.WithScope(c.Request().Context(), inference.Scope{Task: inference.TaskEdit, Story: req.ID})
 	params := &openai.ChatCompletionNewParams{
 		MaxCompletionTokens: openai.Int(int64(cmp.Or(len(req.Selection)*2, 4096))),
 		Temperature:         openai.Float(0.25),
 		TopP:                openai.Float(1.0),
 	}
-	systemPrompt := buildEditSystemPrompt(req.Rules, req.Prompt)
-	result, err := s.Inferencer.Edit(ctx, params, systemPrompt, req.Selection)
+	variant := prompt.VariantOf(req.Source, req.ID)
+	systemPrompt := s.renderPrompt(prompt.Edit, variant, prompt.Data{
+		Source:       variant.Source,
+		Story:        variant.Story,
+		Rules:        strings.TrimSpace(req.Rules),
+		Instructions: strings.TrimSpace(req.Prompt),
+	})
+	res, err := s.Inferencer.Edit(ctx, params, systemPrompt, req.Selection)
 	if err != nil {
 		log.Error("edit inference failed", "error", err)
-		return echo.NewHTTPError(http.StatusBadGateway, "edit inference failed")
+		return echo.NewHTTPError(inferenceStatus(err), "edit inference failed: "+err.Error())
 	}
-	result = strings.TrimSpace(result)
+	result := strings.TrimSpace(res.Content)
 	if result == "" {
 		return echo.NewHTTPError(http.StatusBadGateway, "empty edit result")
 	}
 
-	if s.Summary == nil {
-		s.Summary = make(map[string]schema.Summary)
-	}
-	summary := s.Summary[req.ID]
+	summary, _ := s.storedSummary(req.ID)
 	if summary.Edits == nil {
 		summary.Edits = make(map[string][]schema.EditHistoryEntry)
 	}
@@ -96,8 +100,7 @@ func (s *Server) handlePostEdit(c echo.Context) error {
 		history = history[:maxEditHistoryEntries]
 	}
 	summary.Edits[chapterKey] = history
-	s.Summary[req.ID] = summary
-	if err := utils.Save("CharacterSummary.json", s.Summary); err != nil {
+	if err := s.storeSummary(req.ID, summary); err != nil {
 		log.Warn("failed saving summary data after edit", "error", err)
 	}
 
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/openai/openai-go/v3"
+
+	"paige/pkg/inference"
+	"paige/pkg/prompt"
+)
+
+// NameVoter is one member of the name extraction ensemble.
+type NameVoter struct {
+	// Name labels the member in logs, usually its provider.
+	Name       string
+	Inferencer inference.Inferencer
+}
+
+// nameSampleTemperature is used for every sample after the first, so repeated samples of one
+// member actually differ.
+const nameSampleTemperature = 0.8
+
+// nameVoters returns the ensemble members, falling back to the primary inferencer.
+func (s *Server) nameVoters() []NameVoter {
+	if len(s.NameEnsemble) > 0 {
+		return s.NameEnsemble
+	}
+	return []NameVoter{{Name: "primary", Inferencer: s.Inferencer}}
+}
+
+func (s *Server) nameSamples() int {
+	return max(s.NameSamples, 1)
+}
+
+// ensembleNames reports whether /api/names votes across more than one extraction per chunk.
+func (s *Server) ensembleNames() bool {
+	return len(s.nameVoters())*s.nameSamples() > 1
+}
+
+// nameGroup is a candidate character and the voters that proposed it. Candidates are grouped
+// when their name or any alias matches case-insensitively.
+type nameGroup struct {
+	Character
+	keys  map[string]struct{}
+	votes int
+}
+
+func (g *nameGroup) matches(c Character) bool {
+	for _, k := range nameKeys(c) {
+		if _, ok := g.keys[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func nameKeys(c Character) []string {
+	keys := make([]string, 0, len(c.Aliases)+1)
+	for _, n := range append([]string{c.Name}, c.Aliases...) {
+		if k := strings.ToLower(strings.TrimSpace(n)); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// voteNames extracts names from text once per ensemble member and sample, concurrently, and
+// keeps the candidates proposed by a quorum of the voters that answered or seen in text. It
+// returns the kept characters and, at the same index, their confidence: the share of voters that
+// proposed them. It fails only when no voter answered.
+func (s *Server) voteNames(ctx context.Context, params *openai.ChatCompletionNewParams, system string, variant prompt.Variant, text string) ([]Character, []float64, error) {
+	voters := s.nameVoters()
+	samples := s.nameSamples()
+	ballots := make([][]Character, len(voters)*samples)
+	errs := make([]error, len(ballots))
+
+	var wg sync.WaitGroup
+	for i := range ballots {
+		voter, sample := voters[i/samples], i%samples
+		wg.Go(func() {
+			ballots[i], errs[i] = s.nameBallot(ctx, voter.Inferencer, params, sample, system, variant, text)
+			if errs[i] != nil {
+				log.Warn("ensemble voter failed on name chunk", "voter", voter.Name, "sample", sample, "error", errs[i])
+			}
+		})
+	}
+	wg.Wait()
+
+	// Ballots are tallied in voter order so the result does not depend on completion order.
+	var groups []*nameGroup
+	answered := 0
+	for i, ballot := range ballots {
+		if errs[i] != nil {
+			continue
+		}
+		answered++
+		voted := make(map[*nameGroup]bool)
+		for _, c := range ballot {
+			c.Name = strings.TrimSpace(c.Name)
+			if c.Name == "" {
+				continue
+			}
+			var group *nameGroup
+			for _, g := range groups {
+				if g.matches(c) {
+					group = g
+					break
+				}
+			}
+			if group == nil {
+				group = &nameGroup{Character: Character{Name: c.Name}, keys: make(map[string]struct{})}
+				groups = append(groups, group)
+			}
+			group.Character = mergeNameCharacters([]Character{group.Character}, []Character{{Name: group.Name, Aliases: slices.Concat(c.Aliases, []string{c.Name})}})[0]
+			for _, k := range nameKeys(c) {
+				group.keys[k] = struct{}{}
+			}
+			if !voted[group] {
+				voted[group] = true
+				group.votes++
+			}
+		}
+	}
+	if answered == 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	quorum := answered/2 + 1
+	if s.NameQuorum > 0 {
+		quorum = min(s.NameQuorum, answered)
+	}
+	var kept []Character
+	var confidence []float64
+	lower := strings.ToLower(text)
+	for _, g := range groups {
+		if g.votes < quorum && !seenInText(lower, g.Character) {
+			log.Debug("dropping unconfirmed ensemble name", "name", g.Name, "votes", g.votes, "quorum", quorum)
+			continue
+		}
+		kept = append(kept, g.Character)
+		confidence = append(confidence, float64(g.votes)/float64(answered))
+	}
+	log.Debug("ensemble name vote", "voters", len(ballots), "answered", answered, "candidates", len(groups), "kept", len(kept))
+	return kept, confidence, nil
+}
+
+// nameBallot runs one extraction of text. Samples after the first use a distinct seed and a
+// higher temperature, which also keeps them apart in the response cache.
+func (s *Server) nameBallot(ctx context.Context, inf inference.Inferencer, params *openai.ChatCompletionNewParams, sample int, system string, variant prompt.Variant, text string) ([]Character, error) {
+	if sample > 0 {
+		p := *params
+		p.Seed = openai.Int(int64(sample))
+		p.Temperature = openai.Float(nameSampleTemperature)
+		params = &p
+	}
+	res, err := inf.Infer(ctx, params, system, text)
+	if err != nil {
+		return nil, err
+	}
+	hasCharacters := func(v NameInferResponse) []inference.Violation {
+		return requireItems("$.characters", len(v.Characters))
+	}
+	part, err := decodeRepaired(ctx, s, inference.TaskNames, res, hasCharacters, func(ctx context.Context, out string, violations []inference.Violation) (inference.Result, error) {
+		return inf.Infer(ctx, params, system, text+"\n\n"+s.repairRequest(variant, out, violations))
+	})
+	return part.Characters, err
+}
+
+// seenInText reports whether c's name or any alias occurs in the lower-cased text as a whole word.
+func seenInText(lower string, c Character) bool {
+	for _, k := range nameKeys(c) {
+		if containsWord(lower, k) {
+			return true
+		}
+	}
+	return false
+}
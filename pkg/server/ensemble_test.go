@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"paige/pkg/inference"
+	"paige/pkg/inference/fake"
+)
+
+func TestNamesEnsemble(t *testing.T) {
+	first := fake.New(fake.Response{Task: inference.TaskNames, Content: `{"characters":[
+		{"name":"Éowyn","aliases":[]},{"name":"Éomer","aliases":[]},{"name":"Ghost","aliases":[]}]}`})
+	second := fake.New(fake.Response{Task: inference.TaskNames, Content: `{"characters":[{"name":"ÉOWYN","aliases":["the shieldmaiden"]}]}`})
+	s := newTestServer(t, first)
+	s.NameEnsemble = []NameVoter{{Name: "first", Inferencer: first}, {Name: "second", Inferencer: second}}
+
+	rec := post(t, s, "/api/names", map[string]string{"text": "Éowyn rode out with Éomer."})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp NameInferResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range resp.Characters {
+		names = append(names, c.Name)
+	}
+	// Éomer has one vote of two but is confirmed by the text; Ghost is not.
+	if len(names) != 2 || names[0] != "Éowyn" || names[1] != "Éomer" {
+		t.Fatalf("characters = %v, want Éowyn and Éomer", names)
+	}
+	if got := resp.Confidence["Éowyn"]; got != 1 {
+		t.Errorf("Éowyn confidence = %v, want both votes across spellings", got)
+	}
+	if got := resp.Confidence["Éomer"]; got != 0.5 {
+		t.Errorf("Éomer confidence = %v, want 0.5", got)
+	}
+}
@@ -1,9 +1,13 @@
 package server
 
 import (
+	"cmp"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
+
+	"paige/pkg/inference"
 )
 
 func (s *Server) handleGetRoot(c echo.Context) error {
@@ -12,3 +16,59 @@ func (s *Server) handleGetRoot(c echo.Context) error {
 		"status":  "ok",
 	})
 }
+
+// GET /api/metrics
+func (s *Server) handleGetMetrics(c echo.Context) error {
+	limiters := make([]inference.LimiterStats, 0, len(s.Limiters))
+	for _, l := range s.Limiters {
+		limiters = append(limiters, l.Stats())
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"limiters":    limiters,
+		"json_repair": s.Repairs.Snapshot(),
+	})
+}
+
+// GET /api/usage?story=
+func (s *Server) handleGetUsage(c echo.Context) error {
+	if s.Ledger == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "usage accounting disabled")
+	}
+	if story := c.QueryParam("story"); story != "" {
+		if source := c.QueryParam("source"); source != "" {
+			story = source + ":" + story
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"story":  story,
+			"totals": s.Ledger.Story(story),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"spent_today":  s.Ledger.Spent(),
+		"daily_budget": s.Ledger.DailyBudget,
+		"usage":        s.Ledger.Snapshot(),
+	})
+}
+
+// GET /api/transcripts?story=&source=&task=&limit=
+func (s *Server) handleGetTranscripts(c echo.Context) error {
+	if s.Transcripts == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "transcripts disabled")
+	}
+	story := c.QueryParam("story")
+	if source := c.QueryParam("source"); source != "" && story != "" {
+		story = source + ":" + story
+	}
+	limit, err := strconv.Atoi(cmp.Or(c.QueryParam("limit"), "100"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+	}
+	transcripts, err := s.Transcripts.Read(inference.TranscriptFilter{Story: story, Task: c.QueryParam("task"), Limit: limit})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed reading transcripts: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"story":       story,
+		"transcripts": transcripts,
+	})
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"image"
@@ -16,7 +17,10 @@ import (
 	"github.com/charmbracelet/log"
 	"github.com/gen2brain/webp"
 	"github.com/labstack/echo/v4"
+	"github.com/openai/openai-go/v3"
 
+	"paige/pkg/inference"
+	"paige/pkg/prompt"
 	"paige/pkg/schema"
 	"paige/pkg/utils"
 )
@@ -84,6 +88,11 @@ type PortraitPromptResponse struct {
 	Negative   string               `json:"negative"`
 }
 
+func (PortraitPromptResponse) SchemaName() string { return "portrait_prompt" }
+func (PortraitPromptResponse) SchemaDescription() string {
+	return "Image generation tags for a character portrait"
+}
+
 // POST /api/portrait
 func (s *Server) handlePostPortrait(c echo.Context) error {
 	var req PortraitRequest
@@ -95,18 +104,16 @@ func (s *Server) handlePostPortrait(c echo.Context) error {
 		req.ID = req.Source + ":" + req.ID
 	}
 	if req.Summary == nil {
-		if s.Summary != nil {
-			if sum, ok := s.Summary[req.ID]; ok {
-				nameLower := strings.ToLower(strings.TrimSpace(req.Name))
-				for _, ch := range sum.Characters {
-					if strings.ToLower(strings.TrimSpace(ch.Name)) == nameLower || slices.ContainsFunc(ch.Aliases, func(a string) bool {
-						return strings.ToLower(strings.TrimSpace(a)) == nameLower
-					}) {
-						// Found character, use full details as prompt
-						log.Infof("Found character summary for %s in %s", req.Name, req.ID)
-						req.Summary = &ch
-						break
-					}
+		if sum, ok := s.storedSummary(req.ID); ok {
+			nameLower := strings.ToLower(strings.TrimSpace(req.Name))
+			for _, ch := range sum.Characters {
+				if strings.ToLower(strings.TrimSpace(ch.Name)) == nameLower || slices.ContainsFunc(ch.Aliases, func(a string) bool {
+					return strings.ToLower(strings.TrimSpace(a)) == nameLower
+				}) {
+					// Found character, use full details as prompt
+					log.Infof("Found character summary for %s in %s", req.Name, req.ID)
+					req.Summary = &ch
+					break
 				}
 			}
 		}
@@ -222,23 +229,20 @@ func (s *Server) inferPortraitTags(req PortraitRequest) (PortraitPromptResponse,
 		return PortraitPromptResponse{}, fmt.Errorf("failed to marshal summary: %w", err)
 	}
 
-	var resp PortraitPromptResponse
-	respJSON, err := s.Inferencer.Infer(s.Ctx, nil, portraitPrompt, string(bin))
+	ctx := inference.WithScope(s.Ctx, inference.Scope{Task: inference.TaskPortrait, Story: req.ID})
+	params := &openai.ChatCompletionNewParams{ResponseFormat: schema.ResponseFormatFor[PortraitPromptResponse]()}
+	variant := prompt.VariantOf(req.Source, req.ID)
+	system := s.renderPrompt(prompt.Portrait, variant, prompt.Data{Source: variant.Source, Story: variant.Story})
+	res, err := s.Inferencer.Infer(ctx, params, system, string(bin))
 	if err != nil {
-		return resp, err
+		return PortraitPromptResponse{}, err
 	}
 
-	respJSON = utils.CleanJSON(respJSON)
-	if err := json.Unmarshal([]byte(respJSON), &resp); err != nil {
-		fixed, err := s.Inferencer.Infer(s.Ctx, nil, fixJSONPrompt, respJSON)
-		if err == nil {
-			fixed = utils.CleanJSON(fixed)
-			if err := json.Unmarshal([]byte(fixed), &resp); err != nil {
-				return resp, fmt.Errorf("failed to parse tags (fixed): %w", err)
-			}
-		} else {
-			return resp, fmt.Errorf("failed to parse tags: %w", err)
-		}
+	resp, err := decodeRepaired[PortraitPromptResponse](ctx, s, inference.TaskPortrait, res, nil, func(ctx context.Context, out string, violations []inference.Violation) (inference.Result, error) {
+		return s.Inferencer.Infer(ctx, params, system, string(bin)+"\n\n"+s.repairRequest(variant, out, violations))
+	})
+	if err != nil {
+		return resp, fmt.Errorf("failed to parse tags: %w", err)
 	}
 	return resp, nil
 }
@@ -2,29 +2,41 @@ package server
 
 import (
 	"cmp"
-	"encoding/json"
+	"context"
 	"io"
-	"iter"
 	"net/http"
 	"os"
 	"regexp"
 	"slices"
-	"strconv"
 	"strings"
 
 	"github.com/charmbracelet/log"
 	"github.com/labstack/echo/v4"
+	"github.com/openai/openai-go/v3"
 
+	"paige/pkg/inference"
+	"paige/pkg/prompt"
 	"paige/pkg/schema"
 	"paige/pkg/utils"
 )
 
 type namesReq struct {
 	Text string `json:"text"`
+	// ID and Source select per-source and per-story prompt variants and attribute the calls.
+	ID     string `json:"id,omitempty"`
+	Source string `json:"source,omitempty"`
 }
 
 type NameInferResponse struct {
 	Characters []Character `json:"characters"`
+	// Confidence is the share of ensemble voters that proposed each name, keyed by name. It is
+	// only set in ensemble mode.
+	Confidence map[string]float64 `json:"confidence,omitempty" jsonschema:"-"`
+}
+
+func (NameInferResponse) SchemaName() string { return "character_names" }
+func (NameInferResponse) SchemaDescription() string {
+	return "Character names and aliases found in a text"
 }
 
 type Character struct {
@@ -40,27 +52,59 @@ func (s *Server) handlePostNames(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid json")
 	}
 	req.Text = strings.TrimSpace(req.Text)
+	if req.Source != "" && req.ID != "" {
+		req.ID = req.Source + ":" + req.ID
+	}
 	if req.Text == "" {
 		log.Warn("empty text received for /api/names")
 		return c.JSON(http.StatusOK, NameInferResponse{Characters: nil})
 	}
 
-	chunks := utils.ChunkText(req.Text, 8192*4)
+	variant := prompt.VariantOf(req.Source, req.ID)
+	system := s.renderPrompt(prompt.Names, variant, prompt.Data{Source: variant.Source, Story: variant.Story})
+	chunks := utils.ChunkTokens(req.Text, s.chunkBudget(s.Tokenizer.Count(system), 0), s.Tokenizer)
 	ctx := c.Request().Context()
 	log.Info("processing /api/names", "chunks", len(chunks))
 
+	params := &openai.ChatCompletionNewParams{ResponseFormat: schema.ResponseFormatFor[NameInferResponse]()}
 	var accum []Character
+	// confidence is indexed like accum, so a vote counts for the character it was merged into.
+	var confidence []float64
+	ensemble := s.ensembleNames()
 	for i, ch := range chunks {
 		log.Debug("inferring name chunk", "index", i+1, "length", len(ch))
-		infer, err := s.Inferencer.Infer(ctx, nil, nameExtractPrompt, ch)
+		chunkCtx := inference.WithScope(ctx, inference.Scope{Task: inference.TaskNames, Story: req.ID, Chunk: i})
+		if ensemble {
+			kept, votes, err := s.voteNames(chunkCtx, params, system, variant, ch)
+			if err != nil {
+				log.Warn("every ensemble voter failed on name chunk, falling back to heuristic", "index", i+1, "error", err)
+				accum = mergeNameCharacters(accum, heuristicCharsFromText(ch))
+				continue
+			}
+			accum = mergeNameCharacters(accum, kept)
+			confidence = append(confidence, make([]float64, len(accum)-len(confidence))...)
+			// A character keeps the highest confidence it reached in any chunk.
+			for k, c := range kept {
+				if n := nameIndex(accum, c.Name); n >= 0 {
+					confidence[n] = max(confidence[n], votes[k])
+				}
+			}
+			continue
+		}
+		res, err := s.Inferencer.Infer(chunkCtx, params, system, ch)
 		if err != nil {
 			log.Warn("inference error on name chunk, falling back to heuristic", "index", i+1, "error", err)
 			accum = mergeNameCharacters(accum, heuristicCharsFromText(ch))
 			continue
 		}
 
-		var part NameInferResponse
-		if err := json.Unmarshal([]byte(infer), &part); err != nil || len(part.Characters) == 0 {
+		hasCharacters := func(v NameInferResponse) []inference.Violation {
+			return requireItems("$.characters", len(v.Characters))
+		}
+		part, err := decodeRepaired(chunkCtx, s, inference.TaskNames, res, hasCharacters, func(ctx context.Context, out string, violations []inference.Violation) (inference.Result, error) {
+			return s.Inferencer.Infer(ctx, params, system, ch+"\n\n"+s.repairRequest(variant, out, violations))
+		})
+		if err != nil {
 			log.Warn("name extraction parse error or empty result, using heuristic", "index", i+1, "error", err)
 			accum = mergeNameCharacters(accum, heuristicCharsFromText(ch))
 			continue
@@ -68,8 +112,26 @@ func (s *Server) handlePostNames(c echo.Context) error {
 		accum = mergeNameCharacters(accum, part.Characters)
 	}
 
-	log.Info("completed name extraction", "count", len(accum))
-	return c.JSON(http.StatusOK, NameInferResponse{Characters: accum})
+	resp := NameInferResponse{Characters: accum}
+	if ensemble {
+		resp.Confidence = make(map[string]float64, len(accum))
+		for n, ch := range accum {
+			if n < len(confidence) {
+				resp.Confidence[ch.Name] = confidence[n]
+			} else {
+				resp.Confidence[ch.Name] = 0
+			}
+		}
+	}
+	log.Info("completed name extraction", "count", len(accum), "ensemble", ensemble)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// nameIndex returns the index of the character in chars that mergeNameCharacters merges name
+// into, or -1.
+func nameIndex(chars []Character, name string) int {
+	key := strings.ToLower(strings.TrimSpace(name))
+	return slices.IndexFunc(chars, func(c Character) bool { return strings.ToLower(strings.TrimSpace(c.Name)) == key })
 }
 
 // Merge Characters by name (case-insensitive), union aliases (unique, trimmed).
@@ -142,39 +204,6 @@ func heuristicCharsFromText(text string) []Character {
 	return out
 }
 
-func chunkRequest(req summarizeReq, limit int) iter.Seq2[int, string] {
-	return func(yield func(int, string) bool) {
-		if len(req.Paragraphs) > 0 {
-			for i, chunk := range utils.ChunkParagraph(req.Paragraphs, limit) {
-				if len(chunk) == 0 {
-					return
-				}
-				var sb strings.Builder
-				sb.WriteByte('{')
-				for j, paragraph := range chunk {
-					if j > 0 {
-						sb.WriteByte(',')
-					}
-					sb.WriteString(strconv.Quote(strconv.Itoa(paragraph.Index)))
-					sb.WriteByte(':')
-					sb.WriteString(strconv.Quote(paragraph.Text))
-				}
-				sb.WriteByte('}')
-
-				if !yield(i, sb.String()) {
-					return
-				}
-			}
-		} else if len(req.Text) > 0 {
-			for i, chunk := range utils.ChunkText(req.Text, limit) {
-				if !yield(i, chunk) {
-					return
-				}
-			}
-		}
-	}
-}
-
 func cancelled(c echo.Context) bool {
 	select {
 	case <-c.Request().Context().Done():
@@ -1,186 +1,87 @@
 package server
 
-import "strings"
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
 
-const summarizePrompt = `You are a precise, low-latency character entity extraction system for fictional stories. Your task is to process the provided JSON of numbered paragraphs (e.g., {"1": "First paragraph text", "2": "Second paragraph text"}) and return a single, concise JSON object. Concatenate all paragraphs in order to form the full text for extracting characters and timeline. Evaluate each paragraph individually for heat. Do not add any commentary or markdown formatting to your response.
+	"github.com/charmbracelet/log"
+	"github.com/labstack/echo/v4"
 
-The JSON object must have three root keys: 'characters', 'timeline', and 'heat'.
+	"paige/pkg/prompt"
+	"paige/pkg/schema"
+)
 
-**Characters**:
-- 'characters' is an array of objects, each representing a distinct character and must include:
-  * 'name': The character's canonical name.
-  * 'aliases': An array of any nicknames or alternative names used.
-  * 'kind': Classify them as "main", "major", or "minor" based on their prominence in the text.
-  * 'role': A brief, one-sentence description of their role (e.g., "Babysitter," "Mom," "Love Interest").
-  * 'age': Age as stated or estimated; if estimated, append an asterisk (e.g., "17*").
-  * 'gender': Gender as stated or estimated; if estimated, append an asterisk (e.g., "female*"). This is their preferred gender and not biological sex.
-  * 'species' (optional): Species if explicitly stated or clearly implied.
-  * 'personality': A summary of their key personality traits.
-  * 'physical_description': An object with keys for 'height', 'build', 'fur', 'hair', and 'other' details. Do not put 'age' or 'gender' here.
-  * 'sexual_characteristics': An object with keys for 'genitalia', 'penis_length_flaccid', 'penis_length_erect', 'pubic_hair', and 'other'. Mark with an asterisk if it's estimated e.g. 1.5-2 inches* Mention presence of foreskin or knot or type of genitalia.
-  * 'notable_actions': An maximum array of 3-5 of strings listing their most significant events or their character. Avoid small, insignificant actions that do not describe the character or major events.
-
-**Timeline**:
-- 'timeline' is an array of objects, each representing a date with major or notable events, and must include:
-  * 'date': The date of the events in "Month Day, Year" format (e.g., "June 22, 2009").
-  * 'events': An array of event objects, each with:
-    * 'time': The time of the event (e.g., "7:30am" or "Morning").
-    * 'description': A brief description of the event. Be explicit for sexual content; avoid euphemisms.
-    * 'characters_involved': An array of character names involved in the event.
-
-**Heat**:
-- 'heat' is an object where keys are paragraph numbers (as strings) and values are numbers from 0 to 3 in 0.5 increments representing the sexual heat level of that paragraph.
-  * 0.0: No sexual or explicit content (e.g., everyday conversations, non-romantic interactions).
-  * 0.5: Extremely subtle hint of attraction (e.g., brief lingering glance, faint blush, non-sexual compliment on appearance).
-  * 1.0: Mild sexual content (e.g., innuendo, light flirting, brief non-explicit kiss).
-  * 1.5: Slightly intensified mild content (e.g., prolonged kissing, light caressing over clothing, suggestive dialogue; **non-sexual nudity**, e.g., changing clothes, bathing without arousal focus).
-  * 2.0: Moderate sexual content (e.g., touching/fondling over or under clothing, partial nudity with sexual context; **non-sexual erections**, e.g., morning wood or incidental without stimulation).
-  * 2.5: Heightened moderate content (e.g., full nudity with sexual intent, heavy petting, manual stimulation; **self-stimulation without a participant**, e.g., masturbation alone, short of climax).
-  * 3.0: High sexual content (e.g., explicit sexual acts including penetration, oral sex, **self-stimulation or acts involving other partners leading to cumming/orgasm**, ejaculation; highly detailed erotic descriptions. Lewd dialogue alone rates 1.0–1.5).
-
-**Rules**:
-- Characters is an array of objects [{}, {}], not a key object pair.
-- Extract details ONLY if they are explicitly mentioned in the text.
-- Try to interpolate and estimate typical physical and genital details if not mentioned, marking with * (e.g., "5'7\"*"). This applies to 'age', 'gender', and sexual characteristics as needed.
-- All values are strings to allow ranges or explanations (arrays may contain strings or objects as defined).
-- Always fill in sexual characteristics as much as possible.
-- Omit keys if details are not mentioned or cannot be reasonably estimated.
-- Be thorough; do not omit explicit or sensitive details from the source text.
-- Consolidate information about a single character under their primary name.
-- Keep the JSON response as compact as possible.
-- If fur is not present, use skin color or omit if not stated for humans (Always interpolate if missing for furry characters).
-- If hair is not stated, always use the same as the fur color, or brown hair for humans (Always interpolate if missing with fur color).
-- Only keep notable events in the timeline that involve significant actions or character interactions.
-- Avoid removing other details that were already in place when iterating; only change estimates if they now have explicit information.
-- Keep notable actions and events mostly on the timeline rather than 'notable_actions', as the latter is for character-defining actions.
-- These should not be actions that just so happen in the story as that's more fitting for the timeline.
-- Do not duplicate 'age' or 'gender' inside 'physical_description'; keep them at the character's top level.
-- Include 'species' only when it is explicitly stated or clearly implied.
-- Output only the JSON object.
-`
-
-const fixJSONPrompt = `You are a JSON correction utility.
-The user will provide text that is supposed to be a single, valid JSON object but is malformed.
-Your task is to fix the JSON and return only the corrected, valid JSON object.
-Do not add any commentary, markdown, or other text around the JSON.
-Common mistake is for the characters array to be formatted as an object with keys instead of an array of objects.
-It should be '{ "characters": [ {}, {}, ... ] }' not '{ "characters": { "James": {}, "James": {}, ... } }'
-
-**Common Mistakes to Fix:**
-- **Trailing Commas:** Ensure there are no trailing commas after the last element in an array or the last property in an object.
-  - Bad: '{ "name": "Joel", "aliases": ["Joe",], }'
-  - Good: '{ "name": "Joel", "aliases": ["Joe"] }'
-- **Unescaped Quotes:** Strings containing double quotes must have them escaped with a backslash (\").
-  - Bad: '{ "description": "He said "Hello"" }'
-  - Good: '{ "description": "He said \"Hello\"" }'
-- **Unclosed Brackets/Braces:** Ensure every opening '{' or '[' has a corresponding closing '}' or ']'.
-- **Invalid String Literals:** Ensure all strings are properly quoted. Newlines inside strings must be represented as '\n'.
-  - Bad: '{ "note": "This is a
-multi-line string." }'
-  - Good: '{ "note": "This is a\nmulti-line string." }'
-
-**Instructions:**
-- Analyze the provided text, identify the syntax errors, and correct them.
-- Output only the raw, corrected JSON object.`
-
-const nameExtractPrompt = `You are a highly accurate and efficient named-entity recognition system. Your task is to extract all character names from the provided text.
+const qualityTags = "masterpiece, best quality, highres, anime style, bust, upper body, portrait, close-up, white background, simple background, "
+const styleTags = ", [alkemanubis, tianliang_duohe_fangdongye], watercolor (medium)"
+const negativeTags = "english text, text, japanese text, writing, lowres, bad anatomy, bad hands, missing fingers, extra digit, fewer digits, cropped"
 
-**Rules:**
-- Identify all unique characters mentioned.
-- For each character, provide their canonical name and a list of any aliases or nicknames found in the text.
-- Output a single JSON object with a root key "characters".
-- The "characters" key should contain an array of objects, where each object has a "name" and an "aliases" field.
-- Do not infer or add any information not present in the text.
-- Do not include any commentary or markdown. Output only the raw JSON.
-- Do not include pronouns or "You" or "I" as character names.
-- Include names in possessive form (e.g., "Nathan's" should be recognized as referring to "Nathan").
+// renderPrompt renders the named system prompt for a story. Override templates that fail are
+// logged and skipped in favour of the next most specific one.
+func (s *Server) renderPrompt(name string, variant prompt.Variant, data prompt.Data) string {
+	out, _, err := s.Prompts.Render(name, variant, data)
+	if err != nil {
+		log.Warn("prompt template error", "prompt", name, "variant", variant, "error", err)
+	}
+	return out
+}
 
-**Example Output:**
-{"characters":[{"name":"James","aliases":["Jim"]},{"name":"Jonathan","aliases":["Jon"]}]}`
+// exampleCharacter returns the first main character as indented JSON for the few-shot slot of
+// the summarize prompt, or an empty string when there is none.
+func exampleCharacter(characters []schema.Character) string {
+	for _, char := range characters {
+		if !strings.EqualFold(char.Kind, "main") {
+			continue
+		}
+		bin, err := json.MarshalIndent(char, "", " ")
+		if err != nil {
+			return ""
+		}
+		return string(bin)
+	}
+	return ""
+}
 
-const editBasePrompt = `You are Paige, a meticulous inline fiction editor. You receive two inputs:
-1. Instructions + editing rules (from the system message)
-2. The raw story selection (user message)
+// GET /api/prompts
+func (s *Server) handleGetPrompts(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{
+		"dir":       s.Prompts.Dir,
+		"templates": s.Prompts.List(),
+	})
+}
 
-Rewrite only the provided selection while respecting the following:
-- Keep the original POV, tense, and voice unless the instructions explicitly say otherwise.
-- Preserve canonical character names, terminology, and facts not explicitly changed by the user.
-- Never summarize; always return fully rewritten prose that can replace the original selection.
-- Stay within the original length ±25% unless instructed otherwise.
-- Do not invent new plot beats that contradict the source material.
-- Output plain text with no markdown or explanations.`
+// GET /api/prompts/:name?source=&id=&rules=&instructions=
+// Renders the prompt that a request for the story would use. The summarize example is taken
+// from the stored summary of the story.
+func (s *Server) handleGetPrompt(c echo.Context) error {
+	name := c.Param("name")
+	source, id := c.QueryParam("source"), c.QueryParam("id")
+	if source != "" && id != "" {
+		id = source + ":" + id
+	}
+	variant := prompt.VariantOf(source, id)
+	data := prompt.Data{
+		Source:       variant.Source,
+		Story:        variant.Story,
+		Rules:        strings.TrimSpace(c.QueryParam("rules")),
+		Instructions: strings.TrimSpace(c.QueryParam("instructions")),
+	}
+	if name == prompt.Summarize {
+		stored, _ := s.storedSummary(id)
+		data.Example = exampleCharacter(stored.Characters)
+	}
 
-func buildEditSystemPrompt(customRules, userPrompt string) string {
-	parts := []string{editBasePrompt}
-	if trimmed := strings.TrimSpace(customRules); trimmed != "" {
-		parts = append(parts, "Additional rules:\n"+trimmed)
+	out, tmpl, err := s.Prompts.Render(name, variant, data)
+	if out == "" && err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	resp := map[string]any{
+		"template": tmpl,
+		"prompt":   out,
+		"tokens":   s.Tokenizer.Count(out),
 	}
-	if trimmed := strings.TrimSpace(userPrompt); trimmed != "" {
-		parts = append(parts, "User edit prompt:\n"+trimmed)
+	if err != nil {
+		resp["error"] = err.Error()
 	}
-	return strings.Join(parts, "\n\n")
+	return c.JSON(http.StatusOK, resp)
 }
-
-const portraitPrompt = `You are a strict tag generator for character portraits. Your task is to convert a character description into a JSON object containing Danbooru-style tags optimized for NovelAI (NAI).
-
-**JSON Structure:**
-- 'general': (string) General quality and style tags (e.g., "masterpiece, best quality, anime style").
-- 'characters': (array of objects) List of character captions.
-  - 'char_caption': (string) The character specific tags (hair, eyes, clothing, etc.).
-  - 'centers': (array of objects) Optional center point, usually just [{ "x": 0, "y": 0 }].
-- 'negative': (string) Negative prompt tags.
-
-**General Format for Char Caption:**
-[hair color] hair, [hair length], [fur color] fur, [ear type], [eye color] eyes, [special features], [clothing/nudity], [species/type tags]
-
-**Strict Ordering & Rules:**
-1. **Hair/Fur**: Start with hair color and fur color (e.g., "white hair, white fur"). Always infer hair and fur color if not a human.
-If not stated, use fur color for hair or brown for humans. If it says None but there is a fur color, use the fur color instead with short hair.
-Do not include fur if the character is strictly human.
-2. **Ears**: Specify ear type (e.g., "wolf ears", "fox ears").
-3. **Eyes**: Eye color (e.g., "brown eyes").
-4. **Clothing**: If nude, specify "nipples, navel" explicitly. If clothed, list items briefly.
-5. **Type Tags**: Always end with "cub, anthro, furry" (unless strictly human).
-6. **Age**: If described as young or childlike, include "cub". Include the age if specified. Write adult if over 18.
-7. **Role**: Put the character role in booru style tags if specified.
-8. **Personality**: Summarize and briefly list key personality traits if described.
-
-**Instructions:**
-- Output ONLY the raw JSON object.
-- Do not add markdown code blocks.
-
-**Example Input:**
-"A young white wolf boy with brown eyes. He's naked and excited."
-
-**Example JSON Output:**
-{
-  "general": "masterpiece, best quality, highres, anime style, bust, upper body, portrait, close-up, white background, simple background, [alkemanubis, tianliang_duohe_fangdongye], watercolor \(medium\)",
-  "characters": [
-    {
-      "char_caption": "white hair, white fur, wolf ears, penis, foreskin, brown eyes, multicolored hair, gloves (marking), nipples, navel, cub, anthro, furry",
-      "centers": [ { "x": 0, "y": 0 } ]
-    }
-  ],
-  "negative": "lowres, bad anatomy, bad hands, missing fingers, extra digit, fewer digits, cropped"
-}`
-
-const scenePrompt = `You are a strict tag generator for NSFW scenes. Your task is to convert a scene description into a comma-separated list of Danbooru-style tags for NovelAI.
-
-**Format:**
-[character tags (summarized)], [action tags], [position tags], [camera/framing], [location]
-
-**Rules:**
-1. **Characters**: Summarize visual traits briefly (e.g., "1boy, 1girl, wolf boy, fox girl").
-2. **Action**: Explicitly describe the act (e.g., "sex, vaginal penetration, doggy style, from behind").
-3. **Anatomy**: "penis, pussy, erection, knotting, cum inside".
-4. **Framing**: "cowboy shot, cinematic lighting, dutch angle".
-5. **Location**: "bedroom, bed, messy sheets, indoor".
-6. **Quality**: Do not add quality tags (added automatically).
-
-**Example:**
-Input: The wolf boy forms a knot inside the fox girl from behind on the squeaky bed.
-Output: 1boy, 1girl, wolf boy, fox girl, sex, vaginal penetration, doggy style, from behind, knotting, penis, pussy, cum inside, orgasm, sweating, blushing, bedroom, bed, messy sheets, indoor, anthro, furry,`
-
-const qualityTags = "masterpiece, best quality, highres, anime style, bust, upper body, portrait, close-up, white background, simple background, "
-const styleTags = ", [alkemanubis, tianliang_duohe_fangdongye], watercolor (medium)"
-const negativeTags = "english text, text, japanese text, writing, lowres, bad anatomy, bad hands, missing fingers, extra digit, fewer digits, cropped"
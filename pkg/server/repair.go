@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+
+	"paige/pkg/inference"
+	"paige/pkg/prompt"
+	"paige/pkg/schema"
+	"paige/pkg/utils"
+)
+
+// Paths by which model JSON output was decoded, reported by /api/metrics.
+const (
+	repairDirect = "direct"
+	repairLocal  = "local"
+	repairModel  = "model"
+	// repairLenient is output that decoded but still violated the schema after every attempt.
+	repairLenient = "lenient"
+	repairFailed  = "failed"
+)
+
+// RepairStats counts how model JSON output was decoded, per task.
+type RepairStats struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int
+}
+
+func (r *RepairStats) add(task, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counts == nil {
+		r.counts = make(map[string]map[string]int)
+	}
+	if r.counts[task] == nil {
+		r.counts[task] = make(map[string]int)
+	}
+	r.counts[task][path]++
+}
+
+// Snapshot returns a copy of the counters keyed by task, then path.
+func (r *RepairStats) Snapshot() map[string]map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]map[string]int, len(r.counts))
+	for task, paths := range r.counts {
+		out[task] = make(map[string]int, len(paths))
+		for path, n := range paths {
+			out[task][path] = n
+		}
+	}
+	return out
+}
+
+// defaultRepairAttempts bounds the validate-and-repair round trips to the model per result.
+const defaultRepairAttempts = 2
+
+func (s *Server) repairAttempts() int {
+	if s.RepairAttempts > 0 {
+		return s.RepairAttempts
+	}
+	return defaultRepairAttempts
+}
+
+// fixFunc asks the model to correct out, given the schema violations found in it. ctx carries
+// the scope of the original call with the repair attempt set.
+type fixFunc func(ctx context.Context, out string, violations []inference.Violation) (inference.Result, error)
+
+// decodeRepaired decodes a model's JSON output into a T and validates it against T's reflected
+// JSON Schema through Inferencer.Verify; check adds semantic violations the schema cannot
+// express. Output with violations is first repaired locally with utils.RepairJSON, then sent back
+// to the model through fix with the violation list, up to RepairAttempts times. A nil fix skips
+// the model. If every attempt still violates the schema but the last output decoded and passed
+// check, it is accepted. Rejected results are dropped from the response cache.
+func decodeRepaired[T any](ctx context.Context, s *Server, task string, res inference.Result, check func(T) []inference.Violation, fix fixFunc) (T, error) {
+	outputSchema := schema.SchemaFor[T]()
+	verify := func(content string) (T, []inference.Violation, bool) {
+		var v T
+		if err := json.Unmarshal([]byte(content), &v); err != nil {
+			return v, []inference.Violation{{Path: "$", Message: "invalid JSON: " + err.Error()}}, false
+		}
+		violations, err := s.Inferencer.Verify(ctx, outputSchema, content)
+		if err != nil {
+			log.Warn("could not validate model output", "task", task, "error", err)
+		}
+		if check != nil {
+			semantic := check(v)
+			violations = append(violations, semantic...)
+			return v, violations, len(semantic) == 0
+		}
+		return v, violations, true
+	}
+
+	content := res.Content
+	v, violations, usable := verify(content)
+	if len(violations) == 0 {
+		s.Repairs.add(task, repairDirect)
+		return v, nil
+	}
+	s.forget(res)
+
+	if repaired, err := utils.RepairJSON(content); err == nil {
+		rv, rviolations, rusable := verify(repaired)
+		if len(rviolations) == 0 {
+			log.Debug("repaired model JSON locally", "task", task)
+			s.Repairs.add(task, repairLocal)
+			return rv, nil
+		}
+		if rusable || !usable {
+			content, v, violations, usable = repaired, rv, rviolations, rusable
+		}
+	}
+
+	for attempt := 1; fix != nil && attempt <= s.repairAttempts(); attempt++ {
+		log.Warn("model output violates schema, asking the model to fix it", "task", task, "attempt", attempt, "violations", len(violations))
+		scope := inference.ScopeFrom(ctx)
+		scope.Repair = attempt
+		fixed, err := fix(inference.WithScope(ctx, scope), content, violations)
+		if err != nil {
+			log.Warn("repair request failed", "task", task, "error", err)
+			break
+		}
+		out := fixed.Content
+		if repaired, rerr := utils.RepairJSON(out); rerr == nil {
+			out = repaired
+		}
+		fv, fviolations, fusable := verify(out)
+		if len(fviolations) == 0 {
+			s.Repairs.add(task, repairModel)
+			return fv, nil
+		}
+		s.forget(fixed)
+		log.Debug("fixed model output", "output", fixed.Content)
+		if fusable || !usable {
+			content, v, violations, usable = out, fv, fviolations, fusable
+		}
+	}
+
+	if usable {
+		log.Warn("accepting model output with schema violations", "task", task, "violations", violations)
+		s.Repairs.add(task, repairLenient)
+		return v, nil
+	}
+	s.Repairs.add(task, repairFailed)
+	return v, &inference.ValidationError{Violations: violations}
+}
+
+// violationList formats violations as a bullet list for a repair prompt.
+func violationList(violations []inference.Violation) string {
+	var b strings.Builder
+	for _, v := range violations {
+		b.WriteString("- ")
+		b.WriteString(v.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// repairRequest renders the fix_schema prompt with the violations and the output to fix. It is
+// appended to the original user message rather than the system prompt, so the repair call
+// extends the original request and reuses its cached prefix.
+func (s *Server) repairRequest(variant prompt.Variant, out string, violations []inference.Violation) string {
+	return s.renderPrompt(prompt.FixSchema, variant, prompt.Data{}) + "\n\nSchema violations:\n" + violationList(violations) + "\nJSON to fix:\n\n" + out
+}
+
+// requireItems reports a violation at path when a decoded list is empty.
+func requireItems(path string, n int) []inference.Violation {
+	if n > 0 {
+		return nil
+	}
+	return []inference.Violation{{Path: path, Message: "must contain at least one item"}}
+}
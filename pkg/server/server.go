@@ -1,14 +1,23 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/charmbracelet/log"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 
 	"paige/pkg/flight"
 	"paige/pkg/inference"
+	"paige/pkg/prompt"
 	"paige/pkg/queue"
 	"paige/pkg/schema"
 	"paige/pkg/utils"
@@ -21,15 +30,63 @@ type Server struct {
 	Ctx        context.Context
 	Queue      queue.Queue
 
+	// summaryMu guards Summary, which handlers and the batch poller update concurrently.
+	summaryMu sync.Mutex
+
 	PortraitFlight flight.Cache[string, []byte]
 	// PortraitParams stores the request params for in-flight requests.
 	// Key matches the flight key.
 	// Using generic sync.Map equivalent or just a mutex protected map.
 	PortraitParams *utils.SyncMap[map[string]PortraitRequest, string, PortraitRequest]
 
-	Forbids map[string]schema.Forbids
+	Forbids   map[string]schema.Forbids
+	forbidsMu sync.Mutex
+
+	// Limiters are the outbound provider budgets, reported by /api/metrics.
+	Limiters []*inference.Limiter
+	// Ledger accumulates token usage and cost; nil disables accounting.
+	Ledger *inference.Ledger
+	// Cache is the inference response cache; nil when caching is disabled.
+	Cache *inference.DiskCache
+	// Transcripts logs every inference call; nil when transcripts are disabled.
+	Transcripts *inference.TranscriptLog
+
+	// Tokenizer counts tokens for the configured model when sizing chunks.
+	Tokenizer utils.Tokenizer
+	// ContextWindow is the configured model's context size in tokens.
+	ContextWindow int
+	// Prompts renders the system prompts, preferring overrides in its directory.
+	Prompts *prompt.Registry
+	// Repairs counts how model JSON output was decoded.
+	Repairs RepairStats
+	// RepairAttempts bounds how often invalid output is sent back to the model with its schema
+	// violations; zero uses the default.
+	RepairAttempts int
+	// NameEnsemble lists the inferencers voting on the names extracted by /api/names. Empty
+	// uses Inferencer alone; ensemble mode runs whenever members times samples exceeds one.
+	NameEnsemble []NameVoter
+	// NameSamples is how many extractions each ensemble member runs per chunk; zero means one.
+	NameSamples int
+	// NameQuorum is how many voters must propose a name not seen in the text for it to be kept;
+	// zero means a majority of the voters that answered.
+	NameQuorum int
+	// Ephemeral keeps summaries, forbids and batches in memory only, so evaluation runs leave
+	// the saved data untouched.
+	Ephemeral bool
+	// CompareModels are the models /api/compare summarizes the same chapter with.
+	CompareModels []ComparedModel
+	// SummarizeParallelism bounds concurrent chunk extractions in parallel summarize mode.
+	SummarizeParallelism int
+
+	// Batcher submits bulk summarization to the provider's batch API; nil disables batches.
+	Batcher   inference.Batcher
+	Batches   map[string]*BatchJob
+	batchesMu sync.Mutex
 }
 
+// cacheBypassHeader skips inference cache lookups for a request when set to "bypass".
+const cacheBypassHeader = "X-Paige-Cache"
+
 func NewServer(ctx context.Context, inf inference.Inferencer, q queue.Queue) *Server {
 	e := echo.New()
 	e.HideBanner = true
@@ -37,6 +94,7 @@ func NewServer(ctx context.Context, inf inference.Inferencer, q queue.Queue) *Se
 
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORS())
+	e.Use(bypassCache)
 
 	s := &Server{
 		Echo:           e,
@@ -44,7 +102,11 @@ func NewServer(ctx context.Context, inf inference.Inferencer, q queue.Queue) *Se
 		Ctx:            ctx,
 		Queue:          q,
 		PortraitParams: utils.NewSyncMap[map[string]PortraitRequest](),
+		Prompts:        prompt.NewRegistry(""),
+		Tokenizer:      utils.TokenizerForModel(inference.ModelOf(inf)),
+		ContextWindow:  inference.ContextWindowOf(inf),
 	}
+	s.Batcher, _ = inference.BatcherOf(inf)
 
 	s.PortraitFlight = flight.NewCache(func(key string) ([]byte, error) {
 		req, ok := s.PortraitParams.Load(key)
@@ -58,6 +120,39 @@ func NewServer(ctx context.Context, inf inference.Inferencer, q queue.Queue) *Se
 	return s
 }
 
+// bypassCache marks the request context so cached inference results are not reused.
+func bypassCache(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if strings.EqualFold(c.Request().Header.Get(cacheBypassHeader), "bypass") {
+			c.SetRequest(c.Request().WithContext(inference.WithCacheBypass(c.Request().Context())))
+		}
+		return next(c)
+	}
+}
+
+// forget drops a rejected result from the response cache so the next attempt regenerates it.
+func (s *Server) forget(res inference.Result) {
+	if s.Cache != nil {
+		s.Cache.Forget(res.CacheKey)
+	}
+}
+
+// inferenceStatus maps a classified inference error to the HTTP status reported to clients.
+func inferenceStatus(err error) int {
+	switch {
+	case errors.Is(err, inference.ErrContentRefused):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, inference.ErrContextTooLong):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, inference.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
 func (s *Server) registerRoutes() {
 	s.Echo.GET("/", s.handleGetRoot)
 
@@ -65,6 +160,16 @@ func (s *Server) registerRoutes() {
 	api.POST("/names", s.handlePostNames)         // name detection -> []schema.Character (Name only required)
 	api.POST("/summarize", s.handlePostSummarize) // extend/merge details -> []schema.Character
 	api.POST("/edit", s.handlePostEdit)           // inline story edits
+	api.POST("/consolidate", s.handlePostConsolidate)
+	api.GET("/metrics", s.handleGetMetrics)                  // limiter state and pipeline counters
+	api.GET("/usage", s.handleGetUsage)                      // token usage and cost per story/task/day/provider
+	api.GET("/prompts", s.handleGetPrompts)                  // prompt templates and their variants
+	api.GET("/transcripts", s.handleGetTranscripts)          // logged inference calls per story
+	api.GET("/prompts/:name", s.handleGetPrompt)             // rendered prompt preview for a story
+	api.POST("/batch/summarize", s.handlePostBatchSummarize) // bulk summarization through the batch API
+	api.GET("/batch", s.handleGetBatches)
+	api.GET("/batch/:id", s.handleGetBatch)
+	api.POST("/compare", s.handlePostCompare) // one chapter summarized by several models, with diffs
 
 	// generate character portrait
 	api.POST("/portrait", s.handlePostPortrait)
@@ -79,11 +184,113 @@ func (s *Server) Start(addr string) error {
 	return s.Echo.Start(addr)
 }
 
+// save writes v to path unless the server is ephemeral.
+func (s *Server) save(path string, v any) error {
+	if s.Ephemeral {
+		return nil
+	}
+	return utils.Save(path, v)
+}
+
+// SaveUsage persists the ledger to Usage.json. Usage is real spend and is kept even for
+// ephemeral runs.
+func (s *Server) SaveUsage() error {
+	if s.Ledger == nil {
+		return nil
+	}
+	return utils.Save("Usage.json", s.Ledger.Snapshot())
+}
+
+// RunUsageSaves persists the ledger every interval in which calls were recorded, until ctx is
+// done, so a crash loses at most one interval of spend and the daily budget survives restarts.
+func (s *Server) RunUsageSaves(ctx context.Context, interval time.Duration) {
+	if s.Ledger == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var saved int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if records := s.Ledger.Records(); records != saved {
+			if err := s.SaveUsage(); err != nil {
+				log.Warn("failed saving usage", "error", err)
+				continue
+			}
+			saved = records
+		}
+	}
+}
+
+// storedSummary returns a copy of the summary stored under id, so the caller can merge into it
+// while other requests read and save the stored summaries.
+func (s *Server) storedSummary(id string) (schema.Summary, bool) {
+	s.summaryMu.Lock()
+	defer s.summaryMu.Unlock()
+	existing, ok := s.Summary[id]
+	if !ok {
+		return schema.Summary{}, false
+	}
+	var summary schema.Summary
+	if bin, err := json.Marshal(existing); err != nil || json.Unmarshal(bin, &summary) != nil {
+		return existing, true
+	}
+	return summary, true
+}
+
+// storeSummary stores summary under id and persists CharacterSummary.json.
+func (s *Server) storeSummary(id string, summary schema.Summary) error {
+	s.summaryMu.Lock()
+	defer s.summaryMu.Unlock()
+	if s.Summary == nil {
+		s.Summary = make(map[string]schema.Summary)
+	}
+	s.Summary[id] = summary
+	return s.save("CharacterSummary.json", s.Summary)
+}
+
+// errSummaryChanged is returned by replaceSummary when the stored summary no longer matches.
+var errSummaryChanged = errors.New("summary changed")
+
+// replaceSummary stores summary under id only if the stored summary still equals before, so a
+// slow rewrite of a summary does not overwrite updates merged in the meantime.
+func (s *Server) replaceSummary(id string, before, summary schema.Summary) error {
+	s.summaryMu.Lock()
+	defer s.summaryMu.Unlock()
+	current, err := json.Marshal(s.Summary[id])
+	if err != nil {
+		return err
+	}
+	expected, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, expected) {
+		return errSummaryChanged
+	}
+	s.Summary[id] = summary
+	return s.save("CharacterSummary.json", s.Summary)
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
 	utils.Logf("Shutting down server...")
 
-	saveErr := utils.Save("CharacterSummary.json", s.Summary)
-	_ = utils.Save("Forbids.json", s.Forbids)
+	s.summaryMu.Lock()
+	saveErr := s.save("CharacterSummary.json", s.Summary)
+	s.summaryMu.Unlock()
+	s.forbidsMu.Lock()
+	_ = s.save("Forbids.json", s.Forbids)
+	s.forbidsMu.Unlock()
+	s.batchesMu.Lock()
+	if len(s.Batches) > 0 {
+		_ = s.save("Batches.json", s.Batches)
+	}
+	s.batchesMu.Unlock()
+	_ = s.SaveUsage()
 	shutDownErr := s.Echo.Shutdown(ctx)
 	if shutDownErr != nil {
 		return shutDownErr
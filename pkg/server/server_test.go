@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"paige/pkg/inference"
+	"paige/pkg/inference/fake"
+	"paige/pkg/schema"
+	"paige/pkg/utils"
+)
+
+// newTestServer returns an ephemeral server on inf that leaves no files behind.
+func newTestServer(t *testing.T, inf inference.Inferencer) *Server {
+	t.Helper()
+	s := NewServer(context.Background(), inf, nil)
+	s.Summary = make(map[string]schema.Summary)
+	s.Ephemeral = true
+	return s
+}
+
+func post(t *testing.T, s *Server, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	bin, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(bin))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.Echo.ServeHTTP(rec, req)
+	return rec
+}
+
+type sseEvent struct {
+	Name string
+	Data string
+}
+
+// events parses a server-sent event stream.
+func events(t *testing.T, rec *httptest.ResponseRecorder) []sseEvent {
+	t.Helper()
+	var out []sseEvent
+	var name string
+	sc := bufio.NewScanner(rec.Body)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			out = append(out, sseEvent{Name: name, Data: strings.TrimPrefix(line, "data: ")})
+		}
+	}
+	return out
+}
+
+func lastEvent(t *testing.T, evs []sseEvent, name string) (sseEvent, bool) {
+	t.Helper()
+	for i := len(evs) - 1; i >= 0; i-- {
+		if evs[i].Name == name {
+			return evs[i], true
+		}
+	}
+	return sseEvent{}, false
+}
+
+const namesJSON = `{"characters":[{"name":"Ada","aliases":["the Countess"]},{"name":"Babbage","aliases":[]}]}`
+
+const summaryJSON = `{"characters":[{"name":"Ada","age":"36","gender":"female","aliases":[],"kind":"main",
+	"role":"Mathematician","species":"","personality":"Curious","physical_description":{},
+	"sexual_characteristics":{},"notable_actions":["Wrote the first program"]}],
+	"timeline":[{"date":"June 5, 1833","events":[{"time":"Evening","description":"Ada meets Babbage","characters_involved":["Ada"]}]}],
+	"heat":[{"paragraph":1,"level":0}]}`
+
+func TestNames(t *testing.T) {
+	inf := fake.New(fake.Response{Task: inference.TaskNames, Content: namesJSON})
+	s := newTestServer(t, inf)
+	rec := post(t, s, "/api/names", map[string]string{"text": "Ada met Babbage at a party.", "id": "1", "source": "ao3"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp NameInferResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Characters) != 2 || resp.Characters[0].Name != "Ada" || resp.Characters[0].Aliases[0] != "the Countess" {
+		t.Errorf("characters = %+v", resp.Characters)
+	}
+	calls := inf.Calls()
+	if len(calls) != 1 || calls[0].Scope.Story != "ao3:1" || calls[0].User != "Ada met Babbage at a party." {
+		t.Errorf("calls = %+v", calls)
+	}
+}
+
+func TestNamesReplayedCassette(t *testing.T) {
+	body := map[string]string{"text": "Ada met Babbage at a party."}
+	cassette := new(fake.Cassette)
+	recorded := fake.New(fake.Response{Task: inference.TaskNames, Content: namesJSON})
+	if rec := post(t, newTestServer(t, fake.WithRecorder(recorded, cassette, fake.ModeRecord)), "/api/names", body); rec.Code != http.StatusOK {
+		t.Fatalf("record status = %d", rec.Code)
+	}
+
+	// The replaying server has no script, so every answer must come from the cassette.
+	unscripted := fake.New()
+	s := newTestServer(t, fake.WithRecorder(unscripted, &fake.Cassette{Interactions: cassette.Interactions}, fake.ModeReplay))
+	rec := post(t, s, "/api/names", body)
+	var resp NameInferResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Characters) != 2 || resp.Characters[1].Name != "Babbage" {
+		t.Errorf("replayed characters = %+v", resp.Characters)
+	}
+	if n := len(unscripted.Calls()); n != 0 {
+		t.Errorf("replay called the inferencer %d times", n)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	inf := fake.New(fake.Response{Task: inference.TaskSummarize, Content: summaryJSON})
+	s := newTestServer(t, inf)
+	rec := post(t, s, "/api/summarize", map[string]any{
+		"id":         "1",
+		"source":     "ao3",
+		"chapter":    "c1",
+		"paragraphs": map[string]string{"1": "Ada met Babbage at a party."},
+	})
+	evs := events(t, rec)
+	done, ok := lastEvent(t, evs, "done")
+	if !ok {
+		t.Fatalf("no done event in %+v", evs)
+	}
+	var summary schema.Summary
+	if err := json.Unmarshal([]byte(done.Data), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Characters) != 1 || summary.Characters[0].Name != "Ada" || len(summary.Timeline) != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if _, ok := summary.Heat["1"]; !ok || !summary.Chapters["c1"] {
+		t.Errorf("heat, chapters = %v, %v", summary.Heat, summary.Chapters)
+	}
+	if stored := s.Summary["ao3:1"]; len(stored.Characters) != 1 || stored.StoredHeat["c1"] == nil {
+		t.Errorf("stored summary = %+v", stored)
+	}
+}
+
+func TestSummarizeReplayedCassette(t *testing.T) {
+	body := map[string]any{"id": "1", "paragraphs": map[string]string{"1": "Ada met Babbage at a party."}}
+	cassette := new(fake.Cassette)
+	recorded := fake.New(fake.Response{Task: inference.TaskSummarize, Content: summaryJSON})
+	post(t, newTestServer(t, fake.WithRecorder(recorded, cassette, fake.ModeRecord)), "/api/summarize", body)
+
+	s := newTestServer(t, fake.WithRecorder(fake.New(), &fake.Cassette{Interactions: cassette.Interactions}, fake.ModeReplay))
+	if _, ok := lastEvent(t, events(t, post(t, s, "/api/summarize", body)), "done"); !ok {
+		t.Fatal("replayed summarization did not finish")
+	}
+
+	// A chapter the cassette does not hold fails instead of reaching a provider.
+	s = newTestServer(t, fake.WithRecorder(fake.New(), &fake.Cassette{Interactions: cassette.Interactions}, fake.ModeReplay))
+	body["paragraphs"] = map[string]string{"1": "Something else entirely."}
+	ev, ok := lastEvent(t, events(t, post(t, s, "/api/summarize", body)), "error")
+	if !ok || !strings.Contains(ev.Data, fake.ErrNotRecorded.Error()) {
+		t.Errorf("error event = %+v, want %q", ev, fake.ErrNotRecorded)
+	}
+}
+
+func TestSummarizeRefusalRecordsForbid(t *testing.T) {
+	inf := fake.New(fake.Response{Task: inference.TaskSummarize, Kind: "content_refused", Error: `{"error":"policy"}`})
+	s := newTestServer(t, inf)
+	evs := events(t, post(t, s, "/api/summarize", map[string]any{
+		"id":         "1",
+		"source":     "ao3",
+		"chapter":    "c1",
+		"paragraphs": map[string]string{"1": "Forbidden text."},
+	}))
+	if _, ok := lastEvent(t, evs, "error"); !ok {
+		t.Errorf("no error event in %+v", evs)
+	}
+	if len(s.Forbids) != 1 {
+		t.Fatalf("forbids = %+v, want one entry", s.Forbids)
+	}
+	for id, f := range s.Forbids {
+		if !strings.HasPrefix(id, "ao3:ao3:1 chapter:c1 chunk:0") || f.Raw != `{"error":"policy"}` || !strings.Contains(f.Text, "Forbidden text.") {
+			t.Errorf("forbid %q = %+v", id, f)
+		}
+	}
+}
+
+func TestSummarizeTruncatedSplits(t *testing.T) {
+	inf := fake.New(
+		fake.Response{Task: inference.TaskSummarize, Kind: "truncated", Times: 1},
+		fake.Response{Task: inference.TaskSummarize, Content: summaryJSON},
+	)
+	s := newTestServer(t, inf)
+	paragraphs := map[string]string{
+		"1": "Ada " + strings.Repeat("walked through the garden. ", 100),
+		"2": "Babbage " + strings.Repeat("worked on the engine. ", 120),
+	}
+	evs := events(t, post(t, s, "/api/summarize", map[string]any{"id": "1", "paragraphs": paragraphs}))
+	split, ok := lastEvent(t, evs, "split")
+	if !ok {
+		t.Fatalf("no split event in %+v", evs)
+	}
+	var info struct {
+		Parts int `json:"parts"`
+	}
+	if err := json.Unmarshal([]byte(split.Data), &info); err != nil || info.Parts != 2 {
+		t.Errorf("split = %s", split.Data)
+	}
+	if _, ok := lastEvent(t, evs, "done"); !ok {
+		t.Errorf("no done event in %+v", evs)
+	}
+	calls := inf.Calls()
+	if len(calls) != 3 {
+		t.Fatalf("calls = %d, want the truncated chunk and its two halves", len(calls))
+	}
+	if !strings.Contains(calls[1].User, "garden") || strings.Contains(calls[1].User, "engine") {
+		t.Errorf("first half = %.80q", calls[1].User)
+	}
+}
+
+func TestSummarizeRepairsSchemaViolation(t *testing.T) {
+	invalid := strings.Replace(summaryJSON, `"kind":"main"`, `"kind":"protagonist"`, 1)
+	inf := fake.New(
+		fake.Response{Task: inference.TaskSummarize, Content: invalid, Times: 1},
+		fake.Response{Task: inference.TaskSummarize, Content: summaryJSON},
+	)
+	s := newTestServer(t, inf)
+	evs := events(t, post(t, s, "/api/summarize", map[string]any{"id": "1", "paragraphs": map[string]string{"1": "Ada met Babbage."}}))
+	done, ok := lastEvent(t, evs, "done")
+	if !ok {
+		t.Fatalf("no done event in %+v", evs)
+	}
+	var summary schema.Summary
+	if err := json.Unmarshal([]byte(done.Data), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Characters) != 1 || summary.Characters[0].Kind != "main" {
+		t.Errorf("characters = %+v, want the repaired kind", summary.Characters)
+	}
+	calls := inf.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("calls = %d, want the extraction and one repair", len(calls))
+	}
+	if calls[1].Scope.Repair != 1 || !strings.Contains(calls[1].User, "$.characters[0].kind: must be one of") {
+		t.Errorf("repair call scope %+v, prompt %.200q", calls[1].Scope, calls[1].User)
+	}
+}
+
+func TestCacheBypassHeader(t *testing.T) {
+	inf := fake.New(fake.Response{Task: inference.TaskNames, Content: namesJSON})
+	cache := inference.NewDiskCache(t.TempDir(), time.Hour, 0)
+	s := newTestServer(t, inference.WithCache(inf, "fake", cache))
+	s.Cache = cache
+	body := map[string]string{"text": "Ada met Babbage at a party."}
+	for range 2 {
+		if rec := post(t, s, "/api/names", body); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+	}
+	if n := len(inf.Calls()); n != 1 {
+		t.Fatalf("calls = %d, want the repeat served from cache", n)
+	}
+
+	bin, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/api/names", bytes.NewReader(bin))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(cacheBypassHeader, "bypass")
+	rec := httptest.NewRecorder()
+	s.Echo.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if n := len(inf.Calls()); n != 2 {
+		t.Errorf("calls = %d, want the bypassed request to reach the provider", n)
+	}
+}
+
+func TestUsageBudgetRefusal(t *testing.T) {
+	inf := fake.New(fake.Response{Task: inference.TaskSummarize, Content: summaryJSON})
+	s := newTestServer(t, inf)
+	s.Ledger = inference.NewLedger(inference.LedgerData{}, inference.PriceTable{"*": {Input: 1}}, 1)
+	s.Ledger.Record(inference.Scope{}, "fake", "fake", inference.Usage{PromptTokens: 2_000_000})
+
+	ev, ok := lastEvent(t, events(t, post(t, s, "/api/summarize", map[string]any{"id": "1", "paragraphs": map[string]string{"1": "Ada."}})), "error")
+	if !ok || !strings.Contains(ev.Data, "budget") {
+		t.Errorf("error event = %+v, want the budget refusal", ev)
+	}
+	if rec := post(t, s, "/api/compare", map[string]any{"text": "Ada."}); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("compare status = %d, want 429", rec.Code)
+	}
+	if n := len(inf.Calls()); n != 0 {
+		t.Errorf("calls = %d, want none over budget", n)
+	}
+}
+
+func TestRunUsageSaves(t *testing.T) {
+	t.Chdir(t.TempDir())
+	s := newTestServer(t, fake.New())
+	s.Ledger = inference.NewLedger(inference.LedgerData{}, nil, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.RunUsageSaves(ctx, 5*time.Millisecond)
+
+	s.Ledger.Record(inference.Scope{Story: "1"}, "fake", "fake", inference.Usage{PromptTokens: 3})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		saved, err := utils.Load[inference.LedgerData]("Usage.json")
+		if err == nil && saved.Stories["1"] != nil && saved.Stories["1"].Usage.PromptTokens == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("usage not saved: %+v, %v", saved, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
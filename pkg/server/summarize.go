@@ -1,19 +1,24 @@
 package server
 
 import (
-	"encoding/json"
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
 	"maps"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/charmbracelet/log"
 	"github.com/labstack/echo/v4"
 	"github.com/openai/openai-go/v3"
 
+	"paige/pkg/inference"
+	"paige/pkg/prompt"
 	"paige/pkg/schema"
 	"paige/pkg/utils"
 )
@@ -26,6 +31,11 @@ type summarizeReq struct {
 	Characters []schema.Character `json:"characters"`
 	Timeline   []schema.Timeline  `json:"timeline"`
 	Paragraphs map[string]string  `json:"paragraphs,omitempty"`
+	// Parallel extracts all chunks concurrently and consolidates the merged result,
+	// instead of carrying the summary from chunk to chunk.
+	Parallel bool `json:"parallel,omitempty"`
+	// Thinking streams each chunk's reasoning trace as a thinking event, for debugging.
+	Thinking bool `json:"thinking,omitempty"`
 }
 
 // POST /api/summarize
@@ -47,7 +57,7 @@ func (s *Server) handlePostSummarize(c echo.Context) error {
 		Characters: req.Characters,
 		Timeline:   req.Timeline,
 	}
-	if existing, ok := s.Summary[req.ID]; ok {
+	if existing, ok := s.storedSummary(req.ID); ok {
 		summary = existing
 		summary.Heat = nil
 		if heat, ok := summary.StoredHeat[req.Chapter]; ok && len(heat) > 0 {
@@ -78,208 +88,394 @@ func (s *Server) handlePostSummarize(c echo.Context) error {
 		return w.Event("done", schema.Summary{Characters: seed, Timeline: req.Timeline, Chapters: summary.Chapters})
 	}
 
-	ctx := c.Request().Context()
+	if s.Ledger != nil && s.Ledger.OverBudget() {
+		log.Warn("usage budget exceeded, rejecting summarization", "id", req.ID, "spent", s.Ledger.Spent(), "budget", s.Ledger.DailyBudget)
+		return w.Event("error", map[string]string{"error": "daily usage budget exceeded"})
+	}
 
-	systemPrompt := summarizePrompt
-	for i, char := range req.Characters {
-		if strings.Contains(systemPrompt, "Example") {
-			break
-		}
-		if strings.EqualFold(char.Role, "main") {
-			bin, _ := json.MarshalIndent(char, "", " ")
-			if len(bin) > 0 {
-				systemPrompt = summarizePrompt + "\nExample:\n```\n" + string(bin) + "\n```\n"
+	ctx := inference.WithRetryNotify(c.Request().Context(), func(e inference.RetryEvent) {
+		log.Warn("retrying summarization chunk", "chunk", e.Chunk+1, "attempt", e.Attempt, "delay_ms", e.DelayMS, "error", e.Error)
+		_ = w.Event("retry", e)
+	})
+
+	systemPrompt, systemTokens, chunks := s.planSummary(req, summary)
+	log.Debug("chunked summarization request", "chunks", len(chunks), "tokenizer", s.Tokenizer.Name(), "context_window", s.ContextWindow)
+
+	job := &summarizeJob{s: s, c: c, w: w, ctx: ctx, req: req, systemPrompt: systemPrompt, systemTokens: systemTokens}
+	if req.Parallel && len(chunks) > 1 {
+		summary = job.parallel(chunks, summary, cmp.Or(s.SummarizeParallelism, 4))
+	} else {
+		for n := 0; n < len(chunks); n++ {
+			res := job.extract(chunks[n], summary)
+			if res.Stop {
 				break
 			}
-		}
-		if i == len(s.Summary)-1 {
-			log.Warn("no example character available for summarization prompt")
-		}
-	}
-
-	for i, chunk := range chunkRequest(req, 8192*4) {
-		if cancelled(c) {
-			log.Warn("summarization cancelled by client", "index", i)
-			break
-		}
-
-		id := fmt.Sprintf("%s:%s chapter:%s chunk:%d", req.Source, req.ID, req.Chapter, i)
-		if _, ok := s.Forbids[id]; ok {
-			continue
-		}
-
-		var wg sync.WaitGroup
-		var forbidden *schema.Forbids
-		for _, forbid := range s.Forbids {
-			wg.Go(func() {
-				if utils.Similarity(forbid.Text, chunk) >= 0.8 {
-					forbidden = &forbid
-				}
-			})
-		}
-		wg.Wait()
-		if forbidden != nil {
-			compressed, _ := utils.CompressToBase64(chunk)
-			forbidden.Compressed = compressed
-			s.Forbids[id] = schema.Forbids{
-				Reason:     "similar to forbidden content",
-				Text:       chunk,
-				Compressed: compressed,
-				Error:      forbidden.Error,
-				Raw:        forbidden.Raw,
+			if res.Split != nil {
+				chunks = slices.Insert(chunks, n+1, res.Split...)
+				continue
+			}
+			if res.Summary == nil {
+				continue
 			}
-			continue
-		}
 
-		if len(summary.Characters) > 0 || len(summary.Timeline) > 0 {
-			bin, err := json.MarshalIndent(schema.Summary{Characters: summary.Characters, Timeline: summary.Timeline}, "", " ")
-			if err != nil {
-				log.Warn("failed preparing summarization context", "error", err)
-				return c.JSON(http.StatusInternalServerError, utils.ErrJSON("failed preparing summarization context"))
+			log.Debug("merging summarization results", "chunk", chunks[n].Index+1, "chars", len(res.Summary.Characters), "events", len(res.Summary.Timeline))
+			mergeSummary(&summary, *res.Summary, req.Chapter)
+			if err := w.Event("data", summary); err != nil {
+				log.Warn("SSE write error", "error", err)
+				return c.JSON(http.StatusInternalServerError, utils.ErrJSON("failed sending summarization progress"))
 			}
-			chunk += "\n\nIterate on the following JSON, only changing details if mentioned or explicitly stated:\n" + string(bin)
 		}
+	}
+
+	if cancelled(c) {
+		log.Warn("summarization aborted after client disconnect")
+		return nil
+	}
+
+	if len(summary.Characters) == 0 && len(seed) == 0 {
+		log.Warn("no summary data extracted")
+		return c.JSON(http.StatusInternalServerError, utils.ErrJSON("failed parsing summarization result"))
+	}
 
-		totalCharacters := int64(len(systemPrompt) + len(chunk))
-		tokenCount, err := utils.NumTokensFromMessages(systemPrompt + chunk)
+	// Chunks extracted independently always need reconciling; sequential runs only when duplicates accumulated.
+	if req.Parallel || needsConsolidation(summary) {
+		consolidated, d, err := s.consolidateSummary(ctx, req.ID, summary)
 		if err != nil {
-			log.Debug("summarizing chunk", "chunk", i+1, "chars", totalCharacters)
+			log.Warn("consolidation failed, keeping merged summary", "id", req.ID, "error", err)
 		} else {
-			log.Debug("summarizing chunk", "chunk", i+1, "chars", totalCharacters, "tokens", tokenCount, "ratio", float64(totalCharacters)/float64(tokenCount))
+			summary = consolidated
+			chars, events := diffChanges(d)
+			_ = w.Event("consolidate", map[string]int{"characters": chars, "events": events})
 		}
+	}
 
-		params := &openai.ChatCompletionNewParams{
-			MaxCompletionTokens: openai.Int(max(int64(tokenCount), totalCharacters, 8192*4) * 2),
-			ResponseFormat:      schema.StructuredOutputsResponseFormat(),
+	if req.Chapter != "" {
+		if summary.Chapters == nil {
+			summary.Chapters = make(map[string]bool)
 		}
+		summary.Chapters[req.Chapter] = true
+	}
 
-		if cancelled(c) {
-			break
-		}
+	if err := s.storeSummary(req.ID, summary); err != nil {
+		log.Warn("failed saving summary data", "error", err)
+	}
+	log.Info("summarization complete", "id", req.ID, "characters", len(summary.Characters), "timeline", len(summary.Timeline))
+
+	return w.Event("done", summary)
+}
+
+// baseSummary returns the summary a story's chunks are extracted against when they are not
+// summarized one after another: the stored summary, or the characters and timeline sent with
+// the request.
+func (s *Server) baseSummary(req summarizeReq) schema.Summary {
+	if existing, ok := s.storedSummary(req.ID); ok {
+		return existing
+	}
+	return schema.Summary{Characters: req.Characters, Timeline: req.Timeline}
+}
+
+// planSummary renders the summarize prompt for req and splits its text into chunks that fit the
+// context window next to the prompt and the summary context.
+func (s *Server) planSummary(req summarizeReq, summary schema.Summary) (systemPrompt string, systemTokens int, chunks []summaryChunk) {
+	return s.planSummaryFor(req, summary, s.ContextWindow, s.Tokenizer)
+}
+
+// planSummaryFor is planSummary for a model with the given context window and tokenizer.
+func (s *Server) planSummaryFor(req summarizeReq, summary schema.Summary, window int, tok utils.Tokenizer) (systemPrompt string, systemTokens int, chunks []summaryChunk) {
+	variant := prompt.VariantOf(req.Source, req.ID)
+	example := exampleCharacter(req.Characters)
+	if example == "" {
+		log.Debug("no example character available for summarization prompt")
+	}
+	systemPrompt = s.renderPrompt(prompt.Summarize, variant, prompt.Data{Source: variant.Source, Story: variant.Story, Example: example})
+
+	systemTokens = tok.Count(systemPrompt)
+	var contextTokens int
+	if bin, err := summaryContext(summary, "", 0); err == nil && (len(summary.Characters) > 0 || len(summary.Timeline) > 0) {
+		contextTokens = tok.Count(string(bin))
+	}
+	return systemPrompt, systemTokens, chunkRequest(req, chunkBudgetFor(window, systemTokens, contextTokens), tok)
+}
+
+// summarizeJob holds the per-request state shared by the sequential and parallel summarize loops.
+type summarizeJob struct {
+	s            *Server
+	c            echo.Context
+	w            *utils.SSEWriter
+	ctx          context.Context
+	req          summarizeReq
+	systemPrompt string
+	systemTokens int
+}
+
+// chunkResult is the outcome of summarizing one chunk. Summary is set when the chunk was
+// extracted, Split when it was halved instead, and neither when it was skipped.
+// Stop asks the caller to abandon the remaining chunks.
+type chunkResult struct {
+	Summary *schema.Summary
+	Split   []summaryChunk
+	Stop    bool
+}
+
+// extract summarizes a single chunk against the given summary context.
+func (j *summarizeJob) extract(part summaryChunk, summary schema.Summary) chunkResult {
+	s, c, w, req, systemPrompt := j.s, j.c, j.w, j.req, j.systemPrompt
+	i, chunk := part.Index, part.String()
+	if cancelled(c) {
+		log.Warn("summarization cancelled by client", "index", i)
+		return chunkResult{Stop: true}
+	}
+
+	if s.Ledger != nil && s.Ledger.OverBudget() {
+		log.Warn("usage budget exceeded during summarization", "chunk", i+1)
+		_ = w.Event("error", map[string]string{"chunk": strconv.Itoa(i + 1), "error": "daily usage budget exceeded"})
+		return chunkResult{Stop: true}
+	}
+
+	id := fmt.Sprintf("%s:%s chapter:%s chunk:%d%s", req.Source, req.ID, req.Chapter, i, part.Part)
+	if s.similarForbid(id, chunk) {
+		return chunkResult{}
+	}
 
-		out, err := s.Inferencer.Infer(ctx, params, systemPrompt, chunk)
+	var summaryJSON []byte
+	var contextTokens int
+	if len(summary.Characters) > 0 || len(summary.Timeline) > 0 {
+		bin, err := summaryContext(summary, chunk, part.Depth)
 		if err != nil {
-			var apiErr *openai.Error
-			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden {
-				log.Error("summarization forbidden", "chunk", i+1, "error", err)
-				if s.Forbids == nil {
-					s.Forbids = make(map[string]schema.Forbids)
-				}
-				compressed, _ := utils.CompressToBase64(chunk)
-				s.Forbids[id] = schema.Forbids{
-					Reason:     "summarization forbidden",
-					Text:       chunk,
-					Compressed: compressed,
-					Raw:        apiErr.RawJSON(),
-				}
-				_ = w.Event("error", map[string]string{
-					"chunk": strconv.Itoa(i + 1),
-					"error": apiErr.Error(),
-					"text":  chunk,
-				})
-				if err := utils.Save("Forbids.json", s.Forbids); err != nil {
-					log.Warn("failed saving forbids data", "error", err)
-				}
-				continue
-			} else {
-				log.Warn("summarization inference error", "chunk", i+1, "error", err)
-				_ = w.Event("error", map[string]string{"chunk": strconv.Itoa(i + 1), "error": err.Error(), "text": chunk})
-				break
-			}
+			log.Warn("failed preparing summarization context", "error", err)
+			_ = w.Event("error", map[string]string{"chunk": strconv.Itoa(i + 1), "error": "failed preparing summarization context"})
+			return chunkResult{Stop: true}
 		}
+		summaryJSON, contextTokens = bin, s.Tokenizer.Count(string(bin))
+	}
 
-		if cancelled(c) {
-			break
+	// The summary context grows as chunks are merged, so a chunk that fit when the request was split may no longer fit.
+	if budget := s.chunkBudget(j.systemTokens, contextTokens); s.Tokenizer.Count(chunk) > budget {
+		if halves, ok := part.split(minChunkRunes); ok {
+			log.Debug("chunk exceeds token budget, splitting", "chunk", i+1, "part", part.Part, "budget", budget, "parts", len(halves))
+			return chunkResult{Split: halves}
 		}
+	}
+	prefix := contextPrefix(summaryJSON)
+	chunk = prefix + chunk
+	params := s.summarizeParams(systemPrompt, chunk)
+	log.Debug("summarizing chunk", "chunk", i+1, "chars", len(systemPrompt)+len(chunk), "max_tokens", params.MaxCompletionTokens.Value)
 
-		if strings.Contains(out, "<think>") {
-			if idx := strings.LastIndex(out, "</think>"); idx != -1 {
-				out = out[idx+len("</think>"):]
-			}
-		}
+	if cancelled(c) {
+		return chunkResult{Stop: true}
+	}
 
-		if len(out) == 0 {
-			log.Warn("summarization returned empty output", "chunk", i+1)
-			continue
-		}
-		if out[0] != '{' {
-			if j := strings.Index(out, "{"); j != -1 {
-				out = out[j:]
-			} else {
-				log.Warn("no JSON start found in summarization output", "chunk", i+1)
-				log.Debug("raw output", "output", out)
-				continue
-			}
+	chunkCtx := inference.WithScope(j.ctx, inference.Scope{Task: inference.TaskSummarize, Story: req.ID, Chunk: i})
+	chunkCtx = inference.WithCachePrefix(chunkCtx, prefix)
+	res, err := s.Inferencer.Infer(chunkCtx, params, systemPrompt, chunk)
+	if errors.Is(err, inference.ErrContextTooLong) || errors.Is(err, inference.ErrTruncated) {
+		if halves, ok := part.split(minChunkRunes); ok {
+			log.Warn("chunk too large for model, splitting", "chunk", i+1, "part", part.Part, "parts", len(halves), "error", err)
+			_ = w.Event("split", map[string]any{"chunk": i + 1, "part": part.Part, "parts": len(halves), "error": err.Error()})
+			s.forget(res)
+			return chunkResult{Split: halves}
 		}
-		if out[len(out)-1] != '}' {
-			if j := strings.LastIndex(out, "}"); j != -1 {
-				out = out[:j+1]
-			} else {
-				log.Warn("no JSON end found in summarization output", "chunk", i+1)
-				log.Debug("raw output", "output", out)
-				continue
-			}
+	}
+	if errors.Is(err, inference.ErrTruncated) && res.Content != "" {
+		// A truncated response may still hold usable JSON; the fix pass below completes it otherwise.
+		log.Warn("summarization output truncated, using partial content", "chunk", i+1)
+		err = nil
+	}
+	if err != nil {
+		if errors.Is(err, inference.ErrContentRefused) {
+			log.Error("summarization forbidden", "chunk", i+1, "error", err)
+			s.recordForbid(id, schema.Forbids{
+				Reason: "summarization forbidden",
+				Text:   chunk,
+				Raw:    inference.RawError(err),
+			})
+			_ = w.Event("error", map[string]string{
+				"chunk": strconv.Itoa(i + 1),
+				"error": err.Error(),
+				"text":  chunk,
+			})
+			return chunkResult{}
 		}
+		log.Warn("summarization inference error", "chunk", i+1, "error", err)
+		_ = w.Event("error", map[string]string{"chunk": strconv.Itoa(i + 1), "error": err.Error(), "text": chunk})
+		return chunkResult{Stop: true}
+	}
 
-		var parsed schema.Summary
-		if err := json.Unmarshal([]byte(out), &parsed); err != nil || len(parsed.Characters) == 0 {
-			log.Warn("failed to parse summarization JSON, attempting to fix", "chunk", i+1, "error", err)
-			log.Debug("original model output", "output", out)
+	if cancelled(c) {
+		return chunkResult{Stop: true}
+	}
 
-			fixedOut, fixErr := s.Inferencer.Infer(ctx, params, systemPrompt+"\n\n"+fixJSONPrompt, chunk+"\n\nFix and complete the following malformed JSON:\n\n"+out)
-			if fixErr != nil {
-				log.Warn("failed to fix inference", "chunk", i+1, "error", fixErr)
-				continue
-			}
+	if req.Thinking && res.Reasoning != "" {
+		_ = w.Event("thinking", map[string]any{"chunk": i + 1, "part": part.Part, "reasoning": res.Reasoning})
+	}
 
-			if err := json.Unmarshal([]byte(fixedOut), &parsed); err != nil || len(parsed.Characters) == 0 {
-				log.Warn("failed to parse summarization JSON after fix attempt", "chunk", i+1, "error", err)
-				log.Debug("fixed model output", "output", fixedOut)
-				continue
-			}
-		}
+	if strings.TrimSpace(res.Content) == "" {
+		log.Warn("summarization returned empty output", "chunk", i+1)
+		return chunkResult{}
+	}
 
-		log.Debug("merging summarization results", "chunk", i+1, "chars", len(parsed.Characters), "events", len(parsed.Timeline))
-		summary.Characters = mergeCharacters(summary.Characters, dedupeByName(parsed.Characters))
-		summary.Timeline = mergeTimelines(summary.Timeline, parsed.Timeline)
-		if summary.Heat != nil {
-			maps.Copy(summary.Heat, parsed.Heat)
-		} else {
-			summary.Heat = parsed.Heat
+	hasCharacters := func(v schema.Summary) []inference.Violation { return requireItems("$.characters", len(v.Characters)) }
+	parsed, err := decodeRepaired(chunkCtx, s, inference.TaskSummarize, res, hasCharacters, func(ctx context.Context, out string, violations []inference.Violation) (inference.Result, error) {
+		return s.Inferencer.Infer(ctx, params, systemPrompt, chunk+"\n\n"+s.repairRequest(prompt.VariantOf(req.Source, req.ID), out, violations))
+	})
+	if err != nil {
+		log.Warn("failed to parse summarization JSON", "chunk", i+1, "error", err)
+		log.Debug("raw output", "output", res.Content)
+		return chunkResult{}
+	}
+	return chunkResult{Summary: &parsed}
+}
+
+// contextPrefix introduces the story context of a chunk. The context goes before the passage, so
+// the system prompt and context form a prefix that repair calls and providers' prompt caches can
+// reuse. It is empty without context.
+func contextPrefix(summaryJSON []byte) string {
+	if summaryJSON == nil {
+		return ""
+	}
+	return "Iterate on the following JSON of the characters and dates relevant to the passage below, only changing details if mentioned or explicitly stated:\n" + string(summaryJSON) + "\n\nPassage:\n"
+}
+
+// summarizeParams returns the request parameters for summarizing user.
+func (s *Server) summarizeParams(systemPrompt, user string) *openai.ChatCompletionNewParams {
+	return summarizeParamsFor(s.ContextWindow, s.Tokenizer, systemPrompt, user)
+}
+
+// summarizeParamsFor is summarizeParams for a model with the given context window and tokenizer.
+func summarizeParamsFor(window int, tok utils.Tokenizer, systemPrompt, user string) *openai.ChatCompletionNewParams {
+	totalCharacters := int64(len(systemPrompt) + len(user))
+	tokenCount := tok.Count(systemPrompt + user)
+	return &openai.ChatCompletionNewParams{
+		// The output budget is capped by what is left of the context window after the prompt.
+		MaxCompletionTokens: openai.Int(min(max(int64(tokenCount), totalCharacters, 8192*4)*2, max(int64(window-tokenCount), 1024))),
+		ResponseFormat:      schema.ResponseFormatFor[schema.Summary](),
+	}
+}
+
+// parallel extracts every chunk concurrently against the same starting summary and merges the
+// partial results as they complete. Progress is reported with a chunk event per completed
+// chunk, in completion order.
+func (j *summarizeJob) parallel(chunks []summaryChunk, summary schema.Summary, parallelism int) schema.Summary {
+	base := summary
+	sem := make(chan struct{}, max(parallelism, 1))
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		stop      atomic.Bool
+		total     = len(chunks)
+		completed int
+	)
+
+	var run func(part summaryChunk)
+	run = func(part summaryChunk) {
+		defer wg.Done()
+		if stop.Load() {
+			return
 		}
-		if summary.StoredHeat == nil {
-			summary.StoredHeat = make(map[string]map[string]float64)
+		sem <- struct{}{}
+		res := j.extract(part, base)
+		<-sem
+
+		switch {
+		case res.Stop:
+			stop.Store(true)
+			return
+		case res.Split != nil:
+			mu.Lock()
+			total += len(res.Split) - 1
+			mu.Unlock()
+			wg.Add(len(res.Split))
+			for _, half := range res.Split {
+				go run(half)
+			}
+			return
 		}
-		summary.StoredHeat[req.Chapter] = summary.Heat
 
-		if err := w.Event("data", summary); err != nil {
-			log.Warn("SSE write error", "error", err)
-			return c.JSON(http.StatusInternalServerError, utils.ErrJSON("failed sending summarization progress"))
+		mu.Lock()
+		defer mu.Unlock()
+		completed++
+		if res.Summary != nil {
+			mergeSummary(&summary, *res.Summary, j.req.Chapter)
 		}
+		_ = j.w.Event("chunk", map[string]any{"chunk": part.Index + 1, "part": part.Part, "completed": completed, "total": total})
+		_ = j.w.Event("data", summary)
 	}
 
-	if cancelled(c) {
-		log.Warn("summarization aborted after client disconnect")
-		return nil
+	log.Info("summarizing chunks in parallel", "chunks", len(chunks), "parallelism", cap(sem))
+	wg.Add(len(chunks))
+	for _, part := range chunks {
+		go run(part)
 	}
+	wg.Wait()
+	return summary
+}
 
-	if len(summary.Characters) == 0 && len(seed) == 0 {
-		log.Warn("no summary data extracted")
-		return c.JSON(http.StatusInternalServerError, utils.ErrJSON("failed parsing summarization result"))
+// mergeSummary merges a chunk's extraction into summary and records its heat for chapter.
+func mergeSummary(summary *schema.Summary, parsed schema.Summary, chapter string) {
+	summary.Characters = mergeCharacters(summary.Characters, dedupeByName(parsed.Characters))
+	summary.Timeline = mergeTimelines(summary.Timeline, parsed.Timeline)
+	if summary.Heat != nil {
+		maps.Copy(summary.Heat, parsed.Heat)
+	} else {
+		summary.Heat = parsed.Heat
+	}
+	if summary.StoredHeat == nil {
+		summary.StoredHeat = make(map[string]map[string]float64)
 	}
+	summary.StoredHeat[chapter] = summary.Heat
+}
 
-	if req.Chapter != "" {
-		if summary.Chapters == nil {
-			summary.Chapters = make(map[string]bool)
-		}
-		summary.Chapters[req.Chapter] = true
+// similarForbid reports whether chunk was already refused, recording it under id when it
+// resembles previously forbidden content.
+func (s *Server) similarForbid(id, chunk string) bool {
+	s.forbidsMu.Lock()
+	defer s.forbidsMu.Unlock()
+	if _, ok := s.Forbids[id]; ok {
+		return true
 	}
 
-	s.Summary[req.ID] = summary
-	if err := utils.Save("CharacterSummary.json", s.Summary); err != nil {
-		log.Warn("failed saving summary data", "error", err)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var forbidden *schema.Forbids
+	for _, forbid := range s.Forbids {
+		wg.Go(func() {
+			if utils.Similarity(forbid.Text, chunk) >= 0.8 {
+				mu.Lock()
+				forbidden = &forbid
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	if forbidden == nil {
+		return false
 	}
-	log.Info("summarization complete", "id", req.ID, "characters", len(summary.Characters), "timeline", len(summary.Timeline))
 
-	return w.Event("done", summary)
+	compressed, _ := utils.CompressToBase64(chunk)
+	if s.Forbids == nil {
+		s.Forbids = make(map[string]schema.Forbids)
+	}
+	s.Forbids[id] = schema.Forbids{
+		Reason:     "similar to forbidden content",
+		Text:       chunk,
+		Compressed: compressed,
+		Error:      forbidden.Error,
+		Raw:        forbidden.Raw,
+	}
+	return true
+}
+
+// recordForbid stores a refused chunk under id and persists Forbids.json.
+func (s *Server) recordForbid(id string, forbid schema.Forbids) {
+	s.forbidsMu.Lock()
+	defer s.forbidsMu.Unlock()
+	if s.Forbids == nil {
+		s.Forbids = make(map[string]schema.Forbids)
+	}
+	forbid.Compressed, _ = utils.CompressToBase64(forbid.Text)
+	s.Forbids[id] = forbid
+	if err := s.save("Forbids.json", s.Forbids); err != nil {
+		log.Warn("failed saving forbids data", "error", err)
+	}
 }
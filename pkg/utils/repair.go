@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// RepairJSON mechanically fixes the malformed JSON that models commonly produce and returns it
+// if the result is valid. It strips <think> blocks and code fences, drops text around the
+// outermost value, removes trailing commas, turns single-quoted strings into double-quoted ones,
+// closes unterminated strings and brackets, and turns a "characters" object keyed by name into
+// an array.
+func RepairJSON(s string) (string, error) {
+	s = stripThink(s)
+	s = stripFences(s)
+
+	start := strings.IndexAny(s, "{[")
+	if start == -1 {
+		return "", errors.New("no JSON value found")
+	}
+	repaired := balance(s[start:])
+	if !json.Valid([]byte(repaired)) {
+		return "", errors.New("JSON could not be repaired")
+	}
+	return charactersToArray(repaired), nil
+}
+
+// stripThink removes <think>...</think> reasoning blocks. An unterminated block is dropped with
+// everything after it unless a JSON value precedes it.
+func stripThink(s string) string {
+	for {
+		open := strings.Index(s, "<think>")
+		if open == -1 {
+			return s
+		}
+		end := strings.Index(s[open:], "</think>")
+		if end == -1 {
+			if strings.ContainsAny(s[:open], "{[") {
+				return s[:open]
+			}
+			return s[open+len("<think>"):]
+		}
+		s = s[:open] + s[open+end+len("</think>"):]
+	}
+}
+
+// stripFences returns the contents of the first ``` fenced block, or s when there is none.
+func stripFences(s string) string {
+	open := strings.Index(s, "```")
+	if open == -1 {
+		return s
+	}
+	body := s[open+3:]
+	if nl := strings.IndexByte(body, '\n'); nl != -1 && !strings.ContainsAny(body[:nl], "{[") {
+		body = body[nl+1:] // language tag such as ```json
+	}
+	if end := strings.Index(body, "```"); end != -1 {
+		body = body[:end]
+	}
+	return body
+}
+
+// balance scans the first JSON value in s, dropping trailing commas and anything after the value,
+// rewrites single-quoted strings with double quotes, and closes strings and brackets left open by
+// a truncated output.
+func balance(s string) string {
+	var out bytes.Buffer
+	var stack []byte
+	inString, escaped := false, false
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+				if ch == '\'' {
+					out.Truncate(out.Len() - 1) // \' is not a JSON escape
+				}
+			case ch == '\\':
+				escaped = true
+			case ch == quote:
+				inString = false
+				ch = '"'
+			case ch == '"':
+				out.WriteString(`\"`)
+				continue
+			case ch == '\n':
+				out.WriteString(`\n`)
+				continue
+			}
+			out.WriteByte(ch)
+			continue
+		}
+
+		switch ch {
+		case '"', '\'':
+			inString, quote = true, ch
+			ch = '"'
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			trimTrailingComma(&out)
+			if len(stack) == 0 {
+				return out.String()
+			}
+			ch = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			out.WriteByte(ch)
+			if len(stack) == 0 {
+				return out.String()
+			}
+			continue
+		}
+		out.WriteByte(ch)
+	}
+
+	// Truncated output: close the open string, drop a dangling key or comma, then close brackets.
+	if escaped {
+		out.Truncate(out.Len() - 1)
+	}
+	if inString {
+		out.WriteByte('"')
+	}
+	for len(stack) > 0 {
+		trimDangling(&out, stack[len(stack)-1])
+		out.WriteByte(stack[len(stack)-1])
+		stack = stack[:len(stack)-1]
+	}
+	return out.String()
+}
+
+func trimTrailingComma(out *bytes.Buffer) {
+	b := bytes.TrimRight(out.Bytes(), " \t\r\n")
+	if len(b) > 0 && b[len(b)-1] == ',' {
+		out.Truncate(len(b) - 1)
+	}
+}
+
+// trimDangling removes an incomplete trailing member before closer is written: a trailing comma,
+// a key without a value, or a key followed only by a colon.
+func trimDangling(out *bytes.Buffer, closer byte) {
+	for {
+		b := bytes.TrimRight(out.Bytes(), " \t\r\n")
+		out.Truncate(len(b))
+		switch {
+		case len(b) == 0:
+			return
+		case b[len(b)-1] == ',':
+			out.Truncate(len(b) - 1)
+		case b[len(b)-1] == ':':
+			out.WriteString("null")
+			return
+		case closer == '}' && b[len(b)-1] == '"' && isKey(b):
+			out.Truncate(lastStringStart(b))
+		default:
+			return
+		}
+	}
+}
+
+// isKey reports whether the string that ends b is an object key, i.e. follows '{' or ','.
+func isKey(b []byte) bool {
+	start := lastStringStart(b)
+	if start <= 0 {
+		return false
+	}
+	prev := bytes.TrimRight(b[:start], " \t\r\n")
+	return len(prev) > 0 && (prev[len(prev)-1] == '{' || prev[len(prev)-1] == ',')
+}
+
+// lastStringStart returns the index of the opening quote of the string literal ending b.
+func lastStringStart(b []byte) int {
+	for i := len(b) - 2; i >= 0; i-- {
+		if b[i] != '"' {
+			continue
+		}
+		backslashes := 0
+		for j := i - 1; j >= 0 && b[j] == '\\'; j-- {
+			backslashes++
+		}
+		if backslashes%2 == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// charactersToArray converts {"characters": {"Name": {...}}} into {"characters": [{"name": "Name", ...}]}.
+func charactersToArray(s string) string {
+	var doc map[string]json.RawMessage
+	if json.Unmarshal([]byte(s), &doc) != nil {
+		return s
+	}
+	raw, ok := doc["characters"]
+	if !ok {
+		return s
+	}
+
+	// Decode token by token to keep the characters in the order the model wrote them.
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return s
+	}
+	var chars []map[string]any
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return s
+		}
+		name, _ := tok.(string)
+		var char map[string]any
+		if err := dec.Decode(&char); err != nil {
+			return s
+		}
+		if char == nil {
+			char = make(map[string]any)
+		}
+		if n, _ := char["name"].(string); n == "" {
+			char["name"] = name
+		}
+		chars = append(chars, char)
+	}
+	raw, err := json.Marshal(chars)
+	if err != nil {
+		return s
+	}
+	doc["characters"] = raw
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return s
+	}
+	return string(out)
+}
@@ -0,0 +1,60 @@
+package utils
+
+import "testing"
+
+func TestRepairJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"valid object unchanged", `{"a":1,"b":[true,null,"x"]}`, `{"a":1,"b":[true,null,"x"]}`},
+		{"valid array unchanged", `[1, 2, {"c": "d"}]`, `[1, 2, {"c": "d"}]`},
+		{"apostrophes in strings unchanged", `{"quote":"it's Ada's"}`, `{"quote":"it's Ada's"}`},
+		{"escaped quotes unchanged", `{"s":"say \"hi\" \\ done"}`, `{"s":"say \"hi\" \\ done"}`},
+		{"code fence", "```json\n{\"a\":1}\n```", `{"a":1}`},
+		{"code fence without language", "```\n[1]\n```", `[1]`},
+		{"prose around the value", "Here is the JSON:\n{\"a\":1}\nLet me know!", `{"a":1}`},
+		{"think block", `<think>maybe {"a":0}</think>{"a":1}`, `{"a":1}`},
+		{"unterminated think block before the value", `<think>reasoning{"a":1}`, `{"a":1}`},
+		{"unterminated think block after the value", `{"a":1}<think>second thoughts`, `{"a":1}`},
+		{"trailing comma in object", `{"a":1,}`, `{"a":1}`},
+		{"trailing comma in array", `{"a":[1,2,
+		]}`, `{"a":[1,2]}`},
+		{"truncated string", `{"a":"hel`, `{"a":"hel"}`},
+		{"truncated after escape", `{"a":"x\`, `{"a":"x"}`},
+		{"truncated nested arrays", `{"a":[[1,2],[3`, `{"a":[[1,2],[3]]}`},
+		{"truncated after comma", `[{"a":1},`, `[{"a":1}]`},
+		{"truncated after key", `{"a":1,"b"`, `{"a":1}`},
+		{"truncated after colon", `{"a":1,"b":`, `{"a":1,"b":null}`},
+		{"newline inside string", "{\"a\":\"line\nbreak\"}", `{"a":"line\nbreak"}`},
+		{"single quotes", `{'a': 'it\'s "x"'}`, `{"a": "it's \"x\""}`},
+		{"characters map", `{"characters":{"Ada":{"age":"36"},"Babbage":{"name":"Charles Babbage"}}}`,
+			`{"characters":[{"age":"36","name":"Ada"},{"name":"Charles Babbage"}]}`},
+		{"characters array unchanged", `{"characters":[{"name":"Ada"}]}`, `{"characters":[{"name":"Ada"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RepairJSON(tt.in)
+			if err != nil {
+				t.Fatalf("RepairJSON(%q) error: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("RepairJSON(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepairJSONFails(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"no json here",
+		`{"a": nope}`,
+		`{"a" 1}`,
+	} {
+		if got, err := RepairJSON(in); err == nil {
+			t.Errorf("RepairJSON(%q) = %s, want an error", in, got)
+		}
+	}
+}
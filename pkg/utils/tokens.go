@@ -1,14 +1,122 @@
 package utils
 
 import (
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/pkoukk/tiktoken-go"
 )
 
+// Tokenizer counts tokens the way a model family does.
+type Tokenizer interface {
+	Count(text string) int
+	Name() string
+}
+
+var encoders sync.Map // encoding name -> *tiktoken.Tiktoken
+
+// Tiktoken returns a tokenizer for a tiktoken encoding such as "o200k_base" or "cl100k_base".
+// Encoders are built once and shared, since loading the BPE ranks is expensive.
+func Tiktoken(encoding string) (Tokenizer, error) {
+	if enc, ok := encoders.Load(encoding); ok {
+		return tiktokenizer{name: encoding, enc: enc.(*tiktoken.Tiktoken)}, nil
+	}
+	enc, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := encoders.LoadOrStore(encoding, enc)
+	return tiktokenizer{name: encoding, enc: actual.(*tiktoken.Tiktoken)}, nil
+}
+
+type tiktokenizer struct {
+	name string
+	enc  *tiktoken.Tiktoken
+}
+
+func (t tiktokenizer) Count(text string) int {
+	return len(t.enc.Encode(text, nil, nil))
+}
+
+func (t tiktokenizer) Name() string {
+	return t.name
+}
+
+// HeuristicTokenizer estimates tokens from character counts for models without a local tokenizer.
+type HeuristicTokenizer struct {
+	// CharsPerToken is the average number of Latin characters per token.
+	CharsPerToken float64
+}
+
+// Count estimates Latin text at CharsPerToken characters per token and counts other scripts,
+// such as CJK, as one token per character. It errs on the side of overcounting.
+func (h HeuristicTokenizer) Count(text string) int {
+	var latin, other int
+	for _, r := range text {
+		if r < utf8.RuneSelf || unicode.Is(unicode.Latin, r) {
+			latin++
+		} else if !unicode.IsSpace(r) {
+			other++
+		}
+	}
+	return int(math.Ceil(float64(latin)/max(h.CharsPerToken, 1))) + other
+}
+
+func (h HeuristicTokenizer) Name() string {
+	return "heuristic"
+}
+
+// GeminiTokenizer approximates the Gemini CountTokens endpoint without a network call.
+// Google documents roughly four characters per token for Gemini models.
+var GeminiTokenizer Tokenizer = geminiTokenizer{HeuristicTokenizer{CharsPerToken: 4}}
+
+type geminiTokenizer struct{ HeuristicTokenizer }
+
+func (geminiTokenizer) Name() string {
+	return "gemini-estimate"
+}
+
+// FallbackTokenizer is used for models whose tokenizer is unknown or cannot be loaded.
+var FallbackTokenizer Tokenizer = HeuristicTokenizer{CharsPerToken: 3.5}
+
+// TokenizerForModel picks the tokenizer matching a model name. Router prefixes such as
+// "openai/" are ignored. It falls back to a heuristic when the encoding cannot be loaded.
+func TokenizerForModel(model string) Tokenizer {
+	model = strings.ToLower(model)
+	if i := strings.LastIndexByte(model, '/'); i != -1 {
+		model = model[i+1:]
+	}
+
+	var encoding string
+	switch {
+	case strings.HasPrefix(model, "gemini"), strings.HasPrefix(model, "gemma"):
+		return GeminiTokenizer
+	case strings.HasPrefix(model, "gpt-5"), strings.HasPrefix(model, "gpt-4o"), strings.HasPrefix(model, "gpt-4.1"),
+		strings.HasPrefix(model, "gpt-4.5"), strings.HasPrefix(model, "gpt-oss"),
+		strings.HasPrefix(model, "o1"), strings.HasPrefix(model, "o3"), strings.HasPrefix(model, "o4"):
+		encoding = "o200k_base"
+	case strings.HasPrefix(model, "gpt-4"), strings.HasPrefix(model, "gpt-3.5"):
+		encoding = "cl100k_base"
+	default:
+		return FallbackTokenizer
+	}
+
+	tok, err := Tiktoken(encoding)
+	if err != nil {
+		Logf("tiktoken %s unavailable, using heuristic: %v", encoding, err)
+		return FallbackTokenizer
+	}
+	return tok
+}
+
+// NumTokensFromMessages counts tokens with the cl100k_base encoding used by gpt-4.
 func NumTokensFromMessages(text string) (int, error) {
-	tkm, err := tiktoken.EncodingForModel("gpt-4-0613")
+	tok, err := Tiktoken("cl100k_base")
 	if err != nil {
 		return 0, err
 	}
-
-	return len(tkm.Encode(text, nil, nil)), nil
+	return tok.Count(text), nil
 }
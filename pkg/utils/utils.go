@@ -140,11 +140,22 @@ func Similarity(a, b string) float64 {
 var paragraphRX = regexp.MustCompile(`\n{2,}`)
 
 func ChunkText(text string, limit int) []string {
+	return ChunkTextFunc(text, limit, runeLen)
+}
+
+// ChunkTokens splits text into chunks of at most limit tokens as counted by tok.
+func ChunkTokens(text string, limit int, tok Tokenizer) []string {
+	return ChunkTextFunc(text, limit, tok.Count)
+}
+
+// ChunkTextFunc splits text into chunks whose size, as reported by measure, stays within limit.
+// It prefers paragraph boundaries, then lines, then spaces.
+func ChunkTextFunc(text string, limit int, measure func(string) int) []string {
 	text = strings.TrimSpace(text)
 	if text == "" {
 		return nil
 	}
-	if runeLen(text) <= limit {
+	if measure(text) <= limit {
 		return []string{text}
 	}
 
@@ -161,43 +172,46 @@ func ChunkText(text string, limit int) []string {
 		blocks = []string{text}
 		joiner = " "
 	}
+	jlen := measure(joiner)
 
 	out := make([]string, 0, len(blocks))
 	cur := ""
+	curLen := 0
 
-	var appendPiece func(piece string)
-	appendPiece = func(piece string) {
-		piece = strings.TrimSpace(piece)
+	var appendPiece func(piece string, plen int)
+	appendPiece = func(piece string, plen int) {
+		if trimmed := strings.TrimSpace(piece); trimmed != piece {
+			piece, plen = trimmed, measure(trimmed)
+		}
 		if piece == "" {
 			return
 		}
 		if cur == "" {
-			if runeLen(piece) <= limit {
-				cur = piece
+			if plen <= limit {
+				cur, curLen = piece, plen
 				return
 			}
 			// piece itself too large: split by spaces safely
-			for _, p := range splitBySpaceRune(piece, limit) {
-				if cur == "" {
-					cur = p
-				} else if runeLen(cur)+runeLen(joiner)+runeLen(p) <= limit {
-					cur = cur + joiner + p
-				} else {
-					out = append(out, cur)
-					cur = p
-				}
+			parts := splitBySpace(piece, plen, limit)
+			if len(parts) < 2 {
+				out = append(out, piece)
+				return
+			}
+			for _, p := range parts {
+				appendPiece(p, measure(p))
 			}
 			return
 		}
 		// Try to add with joiner
-		if runeLen(cur)+runeLen(joiner)+runeLen(piece) <= limit {
+		if curLen+jlen+plen <= limit {
 			cur = cur + joiner + piece
+			curLen += jlen + plen
 			return
 		}
 		// Flush and handle piece
 		out = append(out, cur)
-		cur = ""
-		appendPiece(piece)
+		cur, curLen = "", 0
+		appendPiece(piece, plen)
 	}
 
 	for _, b := range blocks {
@@ -205,14 +219,7 @@ func ChunkText(text string, limit int) []string {
 		if b == "" {
 			continue
 		}
-		// If block fits, try to pack as-is; otherwise split by spaces.
-		if runeLen(b) <= limit {
-			appendPiece(b)
-		} else {
-			for _, p := range splitBySpaceRune(b, limit) {
-				appendPiece(p)
-			}
-		}
+		appendPiece(b, measure(b))
 	}
 
 	if strings.TrimSpace(cur) != "" {
@@ -221,12 +228,29 @@ func ChunkText(text string, limit int) []string {
 	return out
 }
 
+// splitBySpace splits an oversized piece of measured size plen so each part fits limit,
+// converting the limit to runes proportionally for measures other than rune counts.
+func splitBySpace(piece string, plen, limit int) []string {
+	runes := runeLen(piece)
+	runeLimit := limit
+	if plen > 0 && plen != runes {
+		runeLimit = max(limit*runes/plen, 1)
+	}
+	return splitBySpaceRune(piece, runeLimit)
+}
+
 type Paragraph struct {
 	Index int
 	Text  string
 }
 
 func ChunkParagraph(paras map[string]string, limit int) [][]Paragraph {
+	return ChunkParagraphFunc(paras, limit, runeLen)
+}
+
+// ChunkParagraphFunc groups paragraphs in index order into chunks whose size, as reported
+// by measure, stays within limit. A paragraph larger than limit becomes its own chunk.
+func ChunkParagraphFunc(paras map[string]string, limit int, measure func(string) int) [][]Paragraph {
 	if limit <= 0 || len(paras) == 0 {
 		return nil
 	}
@@ -252,7 +276,7 @@ func ChunkParagraph(paras map[string]string, limit int) [][]Paragraph {
 	})
 
 	const joiner = "\n\n"
-	jlen := runeLen(joiner)
+	jlen := measure(joiner)
 
 	var out [][]Paragraph
 	var cur []Paragraph
@@ -267,7 +291,7 @@ func ChunkParagraph(paras map[string]string, limit int) [][]Paragraph {
 	}
 
 	for _, p := range paragraphs {
-		plen := runeLen(p.Text)
+		plen := measure(p.Text)
 
 		if plen > limit {
 			flush()
@@ -355,7 +379,9 @@ func byteIndexAtRunePos(s string, pos int) int {
 
 func runeLen(s string) int { return utf8.RuneCountInString(s) }
 
+// SSEWriter writes server-sent events. It is safe for concurrent use.
 type SSEWriter struct {
+	mu   sync.Mutex
 	c    echo.Context
 	w    http.ResponseWriter
 	fl   http.Flusher
@@ -379,6 +405,8 @@ func NewSSEWriter(c echo.Context) *SSEWriter {
 
 // Event sends an SSE event with an event name and data (struct/map/string).
 func (s *SSEWriter) Event(event string, data any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.done {
 		return nil
 	}
@@ -400,6 +428,8 @@ func (s *SSEWriter) Event(event string, data any) error {
 
 // Close finalizes the stream.
 func (s *SSEWriter) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.done {
 		return
 	}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func words(s string) int {
+	return len(strings.Fields(s))
+}
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"fits", "  short text  ", 20, []string{"short text"}},
+		{"empty", " \n\n ", 10, nil},
+		{"paragraphs", "one two\n\nthree four\n\nfive", 16, []string{"one two", "three four\n\nfive"}},
+		{"lines", "alpha\nbeta\ngamma", 10, []string{"alpha\nbeta", "gamma"}},
+		{"spaces", "aaa bbb ccc ddd", 7, []string{"aaa bbb", "ccc ddd"}},
+		{"hard cut", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ChunkText(tt.text, tt.limit); !slices.Equal(got, tt.want) {
+				t.Errorf("ChunkText(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkTextFunc(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		limit   int
+		measure func(string) int
+	}{
+		{"words in one line", strings.Repeat("lorem ipsum dolor sit amet ", 20), 7, words},
+		{"words across paragraphs", strings.Repeat("lorem ipsum dolor sit amet consectetur adipiscing elit\n\n", 6), 5, words},
+		{"bytes of multibyte text", strings.Repeat("élan vital über alles ", 30), 40, func(s string) int { return len(s) }},
+		{"tokens at four runes", strings.Repeat("The engine hummed through the night. ", 40), 25, func(s string) int {
+			return (utf8.RuneCountInString(s) + 3) / 4
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := ChunkTextFunc(tt.text, tt.limit, tt.measure)
+			if len(chunks) < 2 {
+				t.Fatalf("chunks = %q, want the text split", chunks)
+			}
+			for i, c := range chunks {
+				if size := tt.measure(c); size > tt.limit {
+					t.Errorf("chunk %d measures %d, above %d: %q", i, size, tt.limit, c)
+				}
+				if c != strings.TrimSpace(c) || c == "" {
+					t.Errorf("chunk %d is not trimmed: %q", i, c)
+				}
+			}
+			if got, want := strings.Fields(strings.Join(chunks, " ")), strings.Fields(tt.text); !slices.Equal(got, want) {
+				t.Errorf("chunks lost or reordered words: %d words, want %d", len(got), len(want))
+			}
+		})
+	}
+}